@@ -49,12 +49,17 @@ type APInterface interface {
 // API teonet api receiver
 type API struct {
 	*Teonet
-	name    string        // API (application) name
-	short   string        // API short name
-	long    string        // API decription (or long name)
-	version string        // API version
-	cmds    []APInterface // API commands
-	cmd     byte          // API cmdApi command number
+	name       string         // API (application) name
+	short      string         // API short name
+	long       string         // API decription (or long name)
+	version    string         // API version
+	cmds       []APInterface  // API commands
+	cmd        byte           // API cmdApi command number
+	versions   *apiVersions   // Other API versions
+	access     *apiAccess     // Peers roles and access settings
+	middleware *apiMiddleware // Commands middleware chain
+	client     bool           // Client API executed by connected servers
+	builtin    *apiBuiltin    // Built-in commands data
 	bslice.ByteSlice
 }
 
@@ -135,29 +140,49 @@ func MakeAPI2() *APIData {
 	}
 }
 
-// NewAPI create new teonet api
-func (teo *Teonet) NewAPI(name, short, long, version string, cmdAPIs ...byte) (api *API) {
+// NewAPI create new teonet api. The attr parameters:
+//
+//	byte or int   api command number, CmdServerAPI by default
+//	APIBuiltin    add built-in service commands: ping, version, uptime,
+//	              health, peers and stats
+func (teo *Teonet) NewAPI(name, short, long, version string, attr ...interface{}) (api *API) {
 	api = &API{
-		Teonet:  teo,
-		name:    name,
-		short:   short,
-		long:    long,
-		version: version,
+		Teonet:     teo,
+		name:       name,
+		short:      short,
+		long:       long,
+		version:    version,
+		versions:   &apiVersions{channels: make(map[string]*API)},
+		access:     newAPIAccess(),
+		middleware: new(apiMiddleware),
 	}
 	var cmdApi APInterface
 	var cmd byte = CmdServerAPI
-	if len(cmdAPIs) > 0 {
-		cmd = cmdAPIs[0]
+	var builtin bool
+	for i := range attr {
+		switch v := attr[i].(type) {
+		case byte:
+			cmd = v
+		case int:
+			cmd = byte(v)
+		case APIBuiltin:
+			builtin = bool(v)
+		}
 	}
 	cmdApi = MakeAPI2().SetName("api").SetCmd(cmd).SetShort("get api").SetReturn("<api APIDataAr>").
 		SetConnectMode(AnyMode).SetAnswerMode(CmdAnswer).
 		SetReader(func(c *Channel, p *Packet, data []byte) bool {
 			log.Debug.Println("got api request, cmd:", cmdApi.Cmd(), p.From())
-			outData, _ := api.MarshalBinary()
+			// Request data contains required version range
+			v := api.negotiate(c, string(data)).visible(c)
+			outData, _ := v.MarshalBinary()
 			api.SendAnswer(cmdApi, c, outData, p)
 			return true
 		})
 	api.Add(cmdApi)
+	if builtin {
+		api.addBuiltin()
+	}
 	return api
 }
 
@@ -169,6 +194,12 @@ func (a API) Short() string {
 // Send answer to request
 func (a *API) SendAnswer(cmd APInterface, c *Channel, data []byte, p *Packet) (id uint32, err error) {
 
+	// Reply to request received from Request function
+	if _, ok := p.RequestID(); ok {
+		_, err = c.Reply(p, data)
+		return
+	}
+
 	// Get answer mode
 	_, answerMode := cmd.ExecMode()
 	if answerMode&PacketIDAnswer > 0 {
@@ -179,7 +210,9 @@ func (a *API) SendAnswer(cmd APInterface, c *Channel, data []byte, p *Packet) (i
 
 	// Send answer
 	if answerMode&CmdAnswer > 0 {
-		a.Command(cmd.Cmd(), data).Send(c)
+		command := a.Command(cmd.Cmd(), data)
+		command.Ext = apiCmdExt(cmd)
+		command.Send(c)
 	} else {
 		c.Send(data)
 	}
@@ -207,43 +240,98 @@ func (a *API) CmdNext() byte {
 
 // Add api command
 func (a *API) Add(cmds ...APInterface) {
+	if a.client {
+		clientMode(cmds)
+	}
 	a.cmds = append(a.cmds, cmds...)
 }
 
 // Reader process teonet commands as described in API
 func (a API) Reader() func(c *Channel, p *Packet, e *Event) (processed bool) {
 	return func(c *Channel, p *Packet, e *Event) (processed bool) {
+		// Forget negotiated version of disconnected channel
+		if e.Event == EventDisconnected {
+			a.forget(c)
+		}
 		// Skip not Data Events
 		if e.Event != EventData {
 			return
 		}
-		// Execute reader
-		return a.readerExec(
-			p.Data(),
-			func(i int) bool { return a.canExecute(a.cmds[i], c) },
-			func(i int, data []byte) bool { return a.cmds[i].Reader(c, p, data) },
+		// Execute reader of API version negotiated by channel
+		v := a.channelVersion(c)
+		processed = v.readerExec(
+			c.command(p.Data()),
+			func(i int) bool { return v.canExecute(v.cmds[i], c) },
+			func(i int, data []byte) bool {
+				// Answer error to denied commands
+				if err := v.authorize(v.cmds[i], c); err != nil {
+					log.Debugv.Println("command", v.cmds[i].Name(), "denied to", c)
+					c.SendError(p, err)
+					return true
+				}
+				return v.exec(&APICall{Channel: c, Packet: p, Cmd: v.cmds[i],
+					Data: data})
+			},
 		)
+		if !processed {
+			processed = v.sendReaderError(c, p)
+		}
+		return
 	}
 }
 
+// sendReaderError send error answer when command was not processed by api
+// reader. The error sends to peers which support api errors, so WaitFrom
+// and Request return it. Unknown commands may be processed by other readers,
+// so unknown command error sends to requests received from Request function
+// only
+func (a API) sendReaderError(c *Channel, p *Packet) bool {
+	if len(p.Data()) == 0 {
+		return false
+	}
+	cmd := c.command(p.Data())
+	var known, executable bool
+	for i := range a.cmds {
+		if apiCmdMatch(a.cmds[i], cmd.Cmd, cmd.Ext) {
+			known = true
+			executable = executable || a.canExecute(a.cmds[i], c)
+		}
+	}
+	switch {
+	case known && executable:
+		return c.SendError(p, ErrAPIFailed)
+	case known:
+		return c.SendError(p, ErrAPINotAuthorized)
+	case p.rpc && a.ownChannel(c):
+		return c.SendError(p, ErrAPIUnknownCommand)
+	}
+	return false
+}
+
 // Reader2 process not teonet (webrtc for example) commands as described in API
 func (a API) Reader2() func(data []byte, answer func(data []byte)) (processed bool) {
 	return func(data []byte, answer func(data []byte)) (processed bool) {
 		return a.readerExec(
-			data,
+			a.Command(data),
 			func(i int) bool { return true },
-			func(i int, data []byte) bool { return a.cmds[i].Reader2(data, answer) },
+			func(i int, data []byte) bool {
+				// Commands with access policies can't be executed without
+				// channel
+				if err := a.authorize(a.cmds[i], nil); err != nil {
+					a.SendError2(a.cmds[i], err, answer)
+					return true
+				}
+				return a.exec(&APICall{Cmd: a.cmds[i], Data: data,
+					Answer: answer})
+			},
 		)
 	}
 }
 
 // readerExec parce and execute command
-func (a API) readerExec(data []byte, canExecute func(i int) bool,
+func (a API) readerExec(cmd *Command, canExecute func(i int) bool,
 	execute func(i int, data []byte) bool) (processed bool) {
 
-	// Parse command
-	cmd := a.Command(data)
-
 	// Select and Execute commands readers
 	for i := range a.cmds {
 
@@ -253,7 +341,7 @@ func (a API) readerExec(data []byte, canExecute func(i int) bool,
 			continue
 
 		// Check command number
-		case a.cmds[i].Cmd() != cmd.Cmd:
+		case !apiCmdMatch(a.cmds[i], cmd.Cmd, cmd.Ext):
 			continue
 
 		// Execute command
@@ -291,17 +379,22 @@ func (a API) Help(shorts ...bool) (str string) {
 			}
 		}
 		if short {
-			str += fmt.Sprintf("%-*s %3d - %s", max, a.cmds[i].Name(), a.cmds[i].Cmd(), a.cmds[i].Short())
+			str += fmt.Sprintf("%-*s %3s - %s", max, a.cmds[i].Name(), a.cmdString(i), a.cmds[i].Short())
 			continue
 		}
 		str += fmt.Sprintf("%-*s %s\n", max, a.cmds[i].Name(), a.cmds[i].Short())
-		str += fmt.Sprintf("%*s command: %d\n", max, "", a.cmds[i].Cmd())
+		str += fmt.Sprintf("%*s command: %s\n", max, "", a.cmdString(i))
 		str += fmt.Sprintf("%*s usage:   %s\n", max, "", a.cmds[i].Name()+" "+a.cmds[i].Usage())
 		str += fmt.Sprintf("%*s return:  %s", max, "", a.cmds[i].Ret())
 	}
 	return
 }
 
+// cmdString return command number of api command in string
+func (a API) cmdString(i int) string {
+	return cmdString(a.cmds[i].Cmd(), apiCmdExt(a.cmds[i]))
+}
+
 // String is API stringlify, it return help text in string
 func (a API) String() (str string) {
 	return a.Help()
@@ -331,6 +424,9 @@ func (a API) makeAPIData(in APInterface) (ret *APIData) {
 		usage:       in.Usage(),
 		ret:         in.Ret(),
 		cmd:         in.Cmd(),
+		ext:         apiCmdExt(in),
+		params:      apiParams(in),
+		returns:     apiReturns(in),
 		connectMode: connectMode,
 		answerMode:  answerMode,
 	}
@@ -351,6 +447,7 @@ func (a API) MarshalBinary() (data []byte, err error) {
 		data, _ := a.makeAPIData(a.cmds[i]).MarshalBinary()
 		binary.Write(buf, binary.LittleEndian, data)
 	}
+	makeAPIExts(a.cmds).write(buf)
 	data = buf.Bytes()
 	return
 }
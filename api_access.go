@@ -0,0 +1,211 @@
+// Copyright 2023 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet api access module: per command access policies. The policy allows
+// command execution to listed peer addresses, to peers with listed roles and
+// checks custom Authorize hook. Denied calls answered with api
+// ErrAPINotAuthorized error.
+
+package teonet
+
+import (
+	"errors"
+	"sync"
+)
+
+// AccessPolicy is api command access policy. Command allowed to peers from
+// Addresses list or to peers with one of Roles (roles assigned to peers
+// addresses by API.SetRoles). Command allowed to all peers if Addresses and
+// Roles are empty. The Authorize hook calls after addresses and roles checks,
+// command denied if it returns error. Addresses and Roles does not allow
+// commands to peers connected directly (see Channel.Direct) as their
+// addresses does not checked by teonet auth server
+type AccessPolicy struct {
+	Addresses []string                                // Allowed peers addresses
+	Roles     []string                                // Allowed peers roles
+	Authorize func(c *Channel, cmd APInterface) error // Custom authorize hook
+}
+
+// apiAccess contains api peers roles and api level access settings
+type apiAccess struct {
+	roles      map[string][]string                     // Peers roles by address
+	authorize  func(c *Channel, cmd APInterface) error // Api authorize hook
+	hideDenied bool                                    // Hide denied commands in api answer
+	sync.RWMutex
+}
+
+// newAPIAccess create new apiAccess object
+func newAPIAccess() *apiAccess {
+	return &apiAccess{roles: make(map[string][]string)}
+}
+
+// SetAccess set APIData access policy
+func (a *APIData) SetAccess(policy AccessPolicy) *APIData {
+	a.access = &policy
+	return a
+}
+
+// Access return APIData access policy or nil if policy does not set
+func (a APIData) Access() *AccessPolicy { return a.access }
+
+// cmdAccess return access policy of api command or nil
+func cmdAccess(in APInterface) *AccessPolicy {
+	if a, ok := in.(interface{ Access() *AccessPolicy }); ok {
+		return a.Access()
+	}
+	return nil
+}
+
+// accessData return api access data, create it if does not exists
+func (a *API) accessData() *apiAccess {
+	if a.access == nil {
+		a.access = newAPIAccess()
+	}
+	return a.access
+}
+
+// SetRoles set peer roles. Empty roles remove peer roles
+func (a *API) SetRoles(address string, roles ...string) {
+	access := a.accessData()
+	access.Lock()
+	defer access.Unlock()
+	if len(roles) == 0 {
+		delete(access.roles, address)
+		return
+	}
+	access.roles[address] = roles
+}
+
+// Roles return peer roles
+func (a *API) Roles(address string) []string {
+	access := a.accessData()
+	access.RLock()
+	defer access.RUnlock()
+	return access.roles[address]
+}
+
+// SetAuthorize set api authorize hook. It calls for each command executed
+// by this api, command denied if it returns error
+func (a *API) SetAuthorize(authorize func(c *Channel, cmd APInterface) error) {
+	access := a.accessData()
+	access.Lock()
+	defer access.Unlock()
+	access.authorize = authorize
+}
+
+// SetHideDenied hide commands denied to peer in the api command answer
+func (a *API) SetHideDenied(hide bool) {
+	access := a.accessData()
+	access.Lock()
+	defer access.Unlock()
+	access.hideDenied = hide
+}
+
+// authorize check peer can execute command. Returns nil if command allowed
+// or error to answer the peer
+func (a API) authorize(cmd APInterface, c *Channel) (err error) {
+	policy := cmdAccess(cmd)
+
+	var hook func(c *Channel, cmd APInterface) error
+	if a.access != nil {
+		a.access.RLock()
+		hook = a.access.authorize
+		a.access.RUnlock()
+	}
+	if policy == nil && hook == nil {
+		return
+	}
+
+	// Commands with access policies executed in channels only
+	if c == nil {
+		return ErrAPINotAuthorized
+	}
+
+	// Check addresses and roles
+	if policy != nil && !a.allowed(policy, c) {
+		return ErrAPINotAuthorized
+	}
+
+	// Check authorize hooks
+	for _, f := range []func(c *Channel, cmd APInterface) error{hook,
+		policy.authorizeHook()} {
+		if f == nil {
+			continue
+		}
+		if err = f(c, cmd); err != nil {
+			return notAuthorized(err)
+		}
+	}
+	return
+}
+
+// allowed check addresses and roles of policy. Directly connected peers
+// does not allowed by addresses and roles
+func (a API) allowed(policy *AccessPolicy, c *Channel) bool {
+	if len(policy.Addresses) == 0 && len(policy.Roles) == 0 {
+		return true
+	}
+	if c.Direct() {
+		return false
+	}
+	address := c.Address()
+	for _, addr := range policy.Addresses {
+		if addr == address {
+			return true
+		}
+	}
+	if a.access == nil {
+		return false
+	}
+	a.access.RLock()
+	defer a.access.RUnlock()
+	for _, role := range a.access.roles[address] {
+		for _, r := range policy.Roles {
+			if r == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// authorizeHook return policy authorize hook, the policy may be nil
+func (p *AccessPolicy) authorizeHook() func(c *Channel, cmd APInterface) error {
+	if p == nil {
+		return nil
+	}
+	return p.Authorize
+}
+
+// notAuthorized return authorize hook error as api error. Not api errors
+// returns as ErrAPINotAuthorized with error message
+func notAuthorized(err error) (apiErr *APIError) {
+	if errors.As(err, &apiErr) {
+		return
+	}
+	return NewAPIError(APIErrNotAuthorized, err.Error())
+}
+
+// visible return api with commands allowed to peer if hide denied commands
+// mode set
+func (a API) visible(c *Channel) API {
+	if a.access == nil {
+		return a
+	}
+	a.access.RLock()
+	hide := a.access.hideDenied
+	a.access.RUnlock()
+	if !hide {
+		return a
+	}
+
+	var cmds []APInterface
+	for _, cmd := range a.cmds {
+		if a.canExecute(cmd, c) && a.authorize(cmd, c) == nil {
+			cmds = append(cmds, cmd)
+		}
+	}
+	a.cmds = cmds
+	return a
+}
@@ -0,0 +1,144 @@
+// Test of api access policies
+package teonet
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// newLinkedPeers create two teonet peers connected by tru link, the peers
+// addresses are trusted as received from teonet auth. Returns client, server
+// and server address
+func newLinkedPeers(t *testing.T) (cli, srv *Teonet, addr string) {
+	srv, err := New("test-server", OsConfigDir(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Close)
+	cli, err = New("test-client", OsConfigDir(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cli.Close)
+	c, s := truLink(t, cli, srv)
+	cli.SetConnected(c, srv.Address())
+	srv.SetConnected(s, cli.Address())
+	return cli, srv, srv.Address()
+}
+
+func TestAPIAccess(t *testing.T) {
+	cli, srv, addr := newLinkedPeers(t)
+
+	reader := func(c *Channel, p *Packet, data []byte) bool {
+		c.Reply(p, []byte("ok"))
+		return true
+	}
+	errLimit := NewAPIError(APIErrUserCodes, "limit exceeded")
+	api := srv.NewAPI("test", "test", "test api", "0.0.1")
+	api.Add(
+		MakeAPI2().SetName("open").SetCmd(129).SetReader(reader),
+		MakeAPI2().SetName("address").SetCmd(130).SetReader(reader).
+			SetAccess(AccessPolicy{Addresses: []string{cli.Address()}}),
+		MakeAPI2().SetName("other").SetCmd(131).SetReader(reader).
+			SetAccess(AccessPolicy{Addresses: []string{"other-address"}}),
+		MakeAPI2().SetName("admin").SetCmd(132).SetReader(reader).
+			SetAccess(AccessPolicy{Roles: []string{"admin"}}),
+		MakeAPI2().SetName("hook").SetCmd(133).SetReader(reader).
+			SetAccess(AccessPolicy{Authorize: func(c *Channel, cmd APInterface) error {
+				return errLimit
+			}}),
+	)
+	srv.AddReader(api.Reader())
+
+	request := func(cmd byte) error {
+		_, err := cli.Request(context.Background(), addr, cmd, nil)
+		return err
+	}
+
+	t.Run("Policies", func(t *testing.T) {
+		for _, test := range []struct {
+			cmd  byte
+			want error
+		}{
+			{129, nil},
+			{130, nil},
+			{131, ErrAPINotAuthorized},
+			{132, ErrAPINotAuthorized},
+			{133, errLimit},
+		} {
+			if err := request(test.cmd); !errors.Is(err, test.want) {
+				t.Errorf("wrong cmd %d error: %v, want: %v", test.cmd, err, test.want)
+			}
+		}
+
+		// Assign role to client
+		api.SetRoles(cli.Address(), "admin")
+		if err := request(132); err != nil {
+			t.Errorf("wrong cmd 132 error after role assigned: %v", err)
+		}
+		api.SetRoles(cli.Address())
+	})
+
+	t.Run("HideDenied", func(t *testing.T) {
+		api.SetHideDenied(true)
+		apicli, err := cli.NewAPIClient(addr)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		var names []string
+		for _, a := range apicli.Snapshot().Apis {
+			names = append(names, a.Name())
+		}
+		if len(names) != 3 || names[1] != "open" || names[2] != "address" {
+			t.Errorf("wrong visible commands: %v", names)
+		}
+	})
+
+	t.Run("Reader2", func(t *testing.T) {
+		var answer []byte
+		if !api.Reader2()([]byte{132}, func(data []byte) { answer = data }) {
+			t.Error("denied command does not answered by Reader2")
+		}
+		if apiErr, ok := APIErrorAnswer(answer); !ok ||
+			!errors.Is(apiErr, ErrAPINotAuthorized) {
+			t.Errorf("wrong Reader2 answer: %q", answer)
+		}
+	})
+}
+
+// Addresses and roles policies does not allow commands to directly connected
+// peers
+func TestAPIAccessDirect(t *testing.T) {
+	cli, srv, addr := newLocalPeers(t)
+
+	reader := func(c *Channel, p *Packet, data []byte) bool {
+		c.Reply(p, []byte("ok"))
+		return true
+	}
+	api := srv.NewAPI("test", "test", "test api", "0.0.1")
+	api.Add(
+		MakeAPI2().SetName("open").SetCmd(129).SetReader(reader),
+		MakeAPI2().SetName("address").SetCmd(130).SetReader(reader).
+			SetAccess(AccessPolicy{Addresses: []string{cli.Address()}}),
+		MakeAPI2().SetName("admin").SetCmd(131).SetReader(reader).
+			SetAccess(AccessPolicy{Roles: []string{"admin"}}),
+	)
+	api.SetRoles(cli.Address(), "admin")
+	srv.AddReader(api.Reader())
+
+	for _, test := range []struct {
+		cmd  byte
+		want error
+	}{
+		{129, nil},
+		{130, ErrAPINotAuthorized},
+		{131, ErrAPINotAuthorized},
+	} {
+		_, err := cli.Request(context.Background(), addr, test.cmd, nil)
+		if !errors.Is(err, test.want) {
+			t.Errorf("wrong cmd %d error: %v, want: %v", test.cmd, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+// Copyright 2023 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet api built-in commands module: standard service commands which
+// NewAPI adds with APIBuiltin parameter: ping, version, uptime, health,
+// peers and stats. The commands use extended commands numbers from teonet
+// reserved range, so generic tools may execute them on any teonet service.
+
+package teonet
+
+import (
+	"context"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Built-in commands numbers
+const (
+	CmdPing ExtCmd = CmdReservedFirst + 0x10 + iota
+	CmdVersion
+	CmdUptime
+	CmdHealth
+	CmdPeers
+	CmdStats
+)
+
+// DefaultHealthTimeout is health checkers execution timeout
+const DefaultHealthTimeout = 5 * time.Second
+
+// APIBuiltin used in NewAPI parameter to add built-in service commands
+type APIBuiltin bool
+
+// HealthChecker check service dependency and return error if it does not
+// healthy
+type HealthChecker func(ctx context.Context) error
+
+// apiBuiltin contains built-in commands data
+type apiBuiltin struct {
+	started time.Time     // Service start time
+	metrics *APIMetrics   // Api commands metrics
+	checks  []healthCheck // Health checkers
+	cmds    []APInterface // Built-in commands
+	sync.RWMutex
+}
+
+// healthCheck is named health checker
+type healthCheck struct {
+	name  string
+	check HealthChecker
+}
+
+// Built-in commands return schemas
+var (
+	versionSchema = Schema{Encoding: EncodingJSON, Fields: []SchemaField{
+		{Name: "name", Type: SchemaString},
+		{Name: "short", Type: SchemaString},
+		{Name: "version", Type: SchemaString},
+		{Name: "teonet", Type: SchemaString},
+		{Name: "go", Type: SchemaString},
+		{Name: "revision", Type: SchemaString, Optional: true},
+		{Name: "time", Type: SchemaString, Optional: true},
+		{Name: "modified", Type: SchemaBool, Optional: true},
+	}}
+	uptimeSchema = Schema{Encoding: EncodingJSON, Fields: []SchemaField{
+		{Name: "started", Type: SchemaString},
+		{Name: "seconds", Type: SchemaInt},
+	}}
+	healthSchema = Schema{Encoding: EncodingJSON, Fields: []SchemaField{
+		{Name: "healthy", Type: SchemaBool},
+		{Name: "checks", Type: SchemaList, Elem: &SchemaField{
+			Type: SchemaStruct, Fields: []SchemaField{
+				{Name: "name", Type: SchemaString},
+				{Name: "healthy", Type: SchemaBool},
+				{Name: "error", Type: SchemaString, Optional: true},
+			}}},
+	}}
+	peersSchema = Schema{Encoding: EncodingJSON, Fields: []SchemaField{
+		{Name: "peers", Type: SchemaInt},
+		{Name: "clients", Type: SchemaInt},
+	}}
+	statsSchema = Schema{Encoding: EncodingJSON, Fields: []SchemaField{
+		{Name: "goroutines", Type: SchemaInt},
+		{Name: "alloc", Type: SchemaUint},
+		{Name: "sys", Type: SchemaUint},
+		{Name: "gc", Type: SchemaUint},
+		{Name: "commands", Type: SchemaList, Elem: &SchemaField{
+			Type: SchemaStruct, Fields: []SchemaField{
+				{Name: "name", Type: SchemaString},
+				{Name: "calls", Type: SchemaUint},
+				{Name: "errors", Type: SchemaUint},
+				{Name: "avg", Type: SchemaInt},
+				{Name: "max", Type: SchemaInt},
+			}}},
+	}}
+)
+
+// addBuiltin add built-in commands and metrics middleware to api
+func (a *API) addBuiltin() {
+	b := &apiBuiltin{started: time.Now(), metrics: NewAPIMetrics()}
+	a.builtin = b
+	a.Use(b.metrics.Middleware())
+
+	rawBytes := Schema{Fields: []SchemaField{{Name: "data", Type: SchemaBytes}}}
+	b.cmds = []APInterface{
+		a.builtinCmd(MakeAPI2().SetName("ping").SetExtCmd(CmdPing).
+			SetShort("check service is alive, return request data").
+			SetParams(rawBytes).SetReturns(rawBytes),
+			func(data []byte) []byte { return data }),
+		a.builtinCmd(MakeAPI2().SetName("version").SetExtCmd(CmdVersion).
+			SetShort("get service version and build info").
+			SetReturns(versionSchema),
+			func([]byte) []byte { return a.versionData() }),
+		a.builtinCmd(MakeAPI2().SetName("uptime").SetExtCmd(CmdUptime).
+			SetShort("get service start time and uptime").
+			SetReturns(uptimeSchema),
+			func([]byte) []byte { return b.uptimeData() }),
+		a.builtinCmd(MakeAPI2().SetName("health").SetExtCmd(CmdHealth).
+			SetShort("check service health").
+			SetReturns(healthSchema),
+			func([]byte) []byte { return b.healthData() }),
+		a.builtinCmd(MakeAPI2().SetName("peers").SetExtCmd(CmdPeers).
+			SetShort("get number of connected peers and clients").
+			SetReturns(peersSchema),
+			func([]byte) []byte { return a.peersData() }),
+		a.builtinCmd(MakeAPI2().SetName("stats").SetExtCmd(CmdStats).
+			SetShort("get service runtime and api commands stats").
+			SetReturns(statsSchema),
+			func([]byte) []byte { return b.statsData() }),
+	}
+	a.Add(b.cmds...)
+}
+
+// builtinCmd set built-in command readers which answer data returned by f.
+// Reader answers in goroutine because health checkers may be slow
+func (a *API) builtinCmd(cmd *APIData, f func(data []byte) []byte) *APIData {
+	return cmd.SetConnectMode(AnyMode).
+		SetReader(func(c *Channel, p *Packet, data []byte) bool {
+			go func() { a.SendAnswer(cmd, c, f(data), p) }()
+			return true
+		}).
+		SetReader2(func(data []byte, answer func(data []byte)) bool {
+			a.SendAnswer2(f(data), answer)
+			return true
+		})
+}
+
+// AddHealthCheck add named health checker executed by built-in health
+// command. Checker with the same name replaced. The api should be created
+// with APIBuiltin parameter
+func (a *API) AddHealthCheck(name string, check HealthChecker) {
+	b := a.builtin
+	if b == nil {
+		log.Error.Println("can't add health check", name,
+			"api created without built-in commands")
+		return
+	}
+	b.Lock()
+	defer b.Unlock()
+	for i := range b.checks {
+		if b.checks[i].name == name {
+			b.checks[i].check = check
+			return
+		}
+	}
+	b.checks = append(b.checks, healthCheck{name, check})
+}
+
+// Metrics return api commands metrics collected by built-in commands
+// middleware, or nil if api created without built-in commands
+func (a *API) Metrics() *APIMetrics {
+	if a.builtin == nil {
+		return nil
+	}
+	return a.builtin.metrics
+}
+
+// versionData return version command answer
+func (a *API) versionData() []byte {
+	values := map[string]interface{}{
+		"name":    a.name,
+		"short":   a.short,
+		"version": a.version,
+		"teonet":  Version,
+		"go":      runtime.Version(),
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				values["revision"] = s.Value
+			case "vcs.time":
+				values["time"] = s.Value
+			case "vcs.modified":
+				values["modified"] = s.Value
+			}
+		}
+	}
+	return builtinEncode(versionSchema, values)
+}
+
+// uptimeData return uptime command answer
+func (b *apiBuiltin) uptimeData() []byte {
+	return builtinEncode(uptimeSchema, map[string]interface{}{
+		"started": b.started.Format(time.RFC3339),
+		"seconds": int64(time.Since(b.started) / time.Second),
+	})
+}
+
+// healthData return health command answer
+func (b *apiBuiltin) healthData() []byte {
+	b.RLock()
+	checks := append([]healthCheck{}, b.checks...)
+	b.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(),
+		DefaultHealthTimeout)
+	defer cancel()
+
+	healthy, list := true, []interface{}{}
+	for _, c := range checks {
+		check := map[string]interface{}{"name": c.name, "healthy": true}
+		if err := c.check(ctx); err != nil {
+			healthy = false
+			check["healthy"], check["error"] = false, err.Error()
+		}
+		list = append(list, check)
+	}
+	return builtinEncode(healthSchema, map[string]interface{}{
+		"healthy": healthy,
+		"checks":  list,
+	})
+}
+
+// peersData return peers command answer
+func (a *API) peersData() []byte {
+	return builtinEncode(peersSchema, map[string]interface{}{
+		"peers":   len(a.channels.peersChannels()),
+		"clients": len(a.Clients()),
+	})
+}
+
+// statsData return stats command answer
+func (b *apiBuiltin) statsData() []byte {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	stats := b.metrics.Stats()
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	commands := []interface{}{}
+	for _, name := range names {
+		s := stats[name]
+		commands = append(commands, map[string]interface{}{
+			"name":   name,
+			"calls":  s.Calls,
+			"errors": s.Errors,
+			"avg":    int64(s.AvgLatency()),
+			"max":    int64(s.MaxLatency),
+		})
+	}
+	return builtinEncode(statsSchema, map[string]interface{}{
+		"goroutines": runtime.NumGoroutine(),
+		"alloc":      mem.Alloc,
+		"sys":        mem.Sys,
+		"gc":         uint64(mem.NumGC),
+		"commands":   commands,
+	})
+}
+
+// builtinEncode encode built-in command answer
+func builtinEncode(s Schema, values map[string]interface{}) (data []byte) {
+	data, err := s.Encode(values)
+	if err != nil {
+		log.Error.Println("can't encode built-in command answer, err:", err)
+	}
+	return
+}
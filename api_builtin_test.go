@@ -0,0 +1,106 @@
+// Test of api built-in commands
+package teonet
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAPIBuiltin(t *testing.T) {
+	cli, srv, addr := newLocalPeers(t)
+
+	api := srv.NewAPI("test", "test", "test api", "1.0.0", APIBuiltin(true))
+	api.AddHealthCheck("db", func(ctx context.Context) error { return nil })
+	api.AddHealthCheck("queue", func(ctx context.Context) error {
+		return errors.New("queue is full")
+	})
+	api.NewVersion("2.0.0")
+	srv.AddReader(api.Reader())
+
+	apicli, err := cli.NewAPIClient(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer apicli.Close()
+
+	// request execute command and decode answer by its return schema
+	request := func(t *testing.T, name string, data []byte) map[string]interface{} {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		reply, err := apicli.Request(ctx, name, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, ok := apicli.Returns(name)
+		if !ok {
+			t.Fatalf("command %s has no return schema", name)
+		}
+		values, err := s.Decode(reply)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return values
+	}
+
+	t.Run("Ping", func(t *testing.T) {
+		if v := request(t, "ping", []byte("hello")); string(v["data"].([]byte)) != "hello" {
+			t.Errorf("wrong ping answer: %v", v)
+		}
+	})
+
+	t.Run("Version", func(t *testing.T) {
+		v := request(t, "version", nil)
+		if v["short"] != "test" || v["version"] != "1.0.0" || v["teonet"] != Version {
+			t.Errorf("wrong version answer: %v", v)
+		}
+	})
+
+	t.Run("Uptime", func(t *testing.T) {
+		if v := request(t, "uptime", nil); v["seconds"].(int64) < 0 {
+			t.Errorf("wrong uptime answer: %v", v)
+		}
+	})
+
+	t.Run("Health", func(t *testing.T) {
+		v := request(t, "health", nil)
+		checks := v["checks"].([]interface{})
+		if v["healthy"] != false || len(checks) != 2 ||
+			checks[1].(map[string]interface{})["error"] != "queue is full" {
+			t.Errorf("wrong health answer: %v", v)
+		}
+	})
+
+	t.Run("Peers", func(t *testing.T) {
+		if v := request(t, "peers", nil); v["clients"] != int64(1) {
+			t.Errorf("wrong peers answer: %v", v)
+		}
+	})
+
+	t.Run("Stats", func(t *testing.T) {
+		v := request(t, "stats", nil)
+		found := false
+		for _, c := range v["commands"].([]interface{}) {
+			found = found || c.(map[string]interface{})["name"] == "ping"
+		}
+		if !found {
+			t.Errorf("ping command not found in stats: %v", v)
+		}
+	})
+
+	// Built-in commands executes by numbers in other api versions
+	t.Run("OtherVersion", func(t *testing.T) {
+		v2, err := cli.NewAPIClient(addr, APIVersionRange("^2"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer v2.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		reply, err := v2.Request(ctx, CmdPing, []byte("v2"))
+		if err != nil || string(reply) != "v2" {
+			t.Errorf("wrong ping answer: %s, err: %v", reply, err)
+		}
+	})
+}
@@ -0,0 +1,190 @@
+// Copyright 2023 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet api client refresh and cache module: APIClient refreshes peer api
+// description when peer reconnected, caches descriptions on disk and calls
+// OnAPIChanged callback when peer commands added or removed.
+
+package teonet
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// APICache used in NewAPIClient parameter to cache peer api descriptions on
+// disk. The NewAPIClient returns api from cache immediately and refreshes it
+// from peer in background
+type APICache bool
+
+// apiCacheDir is api cache folder name in application config folder
+const apiCacheDir = "api-cache"
+
+// OnAPIChanged set callback which calls when peer api commands added or
+// removed after refresh. Added and removed contains commands names, the
+// command with changed number present in both lists
+func (api *APIClient) OnAPIChanged(f func(added, removed []string)) {
+	api.m.Lock()
+	defer api.m.Unlock()
+	api.onChanged = f
+}
+
+// Refresh get api description from peer and update api client. It calls
+// automatically when peer connected
+func (api *APIClient) Refresh() (err error) {
+	data, err := api.fetchApi()
+	if err != nil {
+		return
+	}
+	var ar APIDataAr
+	if err = ar.UnmarshalBinary(data); err != nil {
+		log.Error.Println("can't unmarshal api data, err", err)
+		return
+	}
+
+	if err = api.checkVersion(ar.version); err != nil {
+		return
+	}
+
+	// Update api data
+	api.m.Lock()
+	old := *api.ar
+	*api.ar = ar
+	onChanged := api.onChanged
+	api.m.Unlock()
+	api.saveCache(ar.version, data)
+
+	// Call OnAPIChanged callback
+	added, removed := diffAPI(old.Apis, ar.Apis)
+	if onChanged != nil && (len(added) > 0 || len(removed) > 0) {
+		onChanged(added, removed)
+	}
+	return
+}
+
+// Close stop api client refreshing
+func (api *APIClient) Close() {
+	if api.readerIdx > 0 {
+		api.teo.clientReaders.del(api.readerIdx - 1)
+		api.readerIdx = 0
+	}
+}
+
+// refreshOnConnect add teonet reader which refreshes api when peer connected
+func (api *APIClient) refreshOnConnect() {
+	idx := api.teo.clientReaders.addShort(func(c *Channel, p *Packet, e *Event) bool {
+		if e.Event == EventConnected && c.Address() == api.address {
+			go func() {
+				if err := api.Refresh(); err != nil {
+					log.Debug.Println("can't refresh api of", api.address, "err:", err)
+				}
+			}()
+		}
+		return false
+	})
+	api.readerIdx = idx + 1
+}
+
+// diffAPI return names of added and removed commands
+func diffAPI(old, new []APIData) (added, removed []string) {
+	key := func(a APIData) string { return a.name + "/" + cmdString(a.cmd, a.ext) }
+	keys := func(apis []APIData) map[string]bool {
+		m := make(map[string]bool, len(apis))
+		for i := range apis {
+			m[key(apis[i])] = true
+		}
+		return m
+	}
+	oldKeys, newKeys := keys(old), keys(new)
+	for i := range new {
+		if !oldKeys[key(new[i])] {
+			added = append(added, new[i].name)
+		}
+	}
+	for i := range old {
+		if !newKeys[key(old[i])] {
+			removed = append(removed, old[i].name)
+		}
+	}
+	return
+}
+
+// cacheFile return api cache file name of address and version
+func (api *APIClient) cacheFile(version string) (string, error) {
+	version = strings.NewReplacer("/", "_", "\\", "_").Replace(version)
+	return api.teo.config.configFile(api.teo.config.appName,
+		apiCacheDir+"/"+api.address+"_"+version)
+}
+
+// saveCache save api data received from peer to cache
+func (api *APIClient) saveCache(version string, data []byte) {
+	if !api.cache {
+		return
+	}
+	file, err := api.cacheFile(version)
+	if err != nil {
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		log.Debug.Println("can't create api cache folder, err:", err)
+		return
+	}
+	if err = os.WriteFile(file, data, 0644); err != nil {
+		log.Debug.Println("can't save api cache, err:", err)
+	}
+}
+
+// loadCache load the highest cached api version matching required version
+// range. Returns false if there is not cached api
+func (api *APIClient) loadCache() (ok bool) {
+	file, err := api.cacheFile("")
+	if err != nil {
+		return
+	}
+	dir, prefix := filepath.Split(file)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	// Select the highest version
+	var best *SemVer
+	var bestFile string
+	for _, entry := range entries {
+		version, found := strings.CutPrefix(entry.Name(), prefix)
+		if !found {
+			continue
+		}
+		v, err := ParseSemVer(version)
+		if err != nil {
+			continue
+		}
+		if api.versionRange != "" {
+			if ok, _ := api.versionRange.Match(version); !ok {
+				continue
+			}
+		}
+		if best == nil || v.Compare(*best) > 0 {
+			best, bestFile = &v, entry.Name()
+		}
+	}
+	if best == nil {
+		return
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, bestFile))
+	if err != nil {
+		return
+	}
+	var ar APIDataAr
+	if err = ar.UnmarshalBinary(data); err != nil ||
+		api.checkVersion(ar.version) != nil {
+		return
+	}
+	api.m.Lock()
+	*api.ar = ar
+	api.m.Unlock()
+	return true
+}
@@ -0,0 +1,113 @@
+// Test of api client refresh and cache
+package teonet
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAPIClientRefresh(t *testing.T) {
+
+	// newServer start server with api commands, servers started with the same
+	// config have the same address
+	dir := t.TempDir()
+	newServer := func(cmds ...APInterface) *Teonet {
+		srv, err := New("test-server", OsConfigDir(dir), DirectConnect(true))
+		if err != nil {
+			t.Fatal(err)
+		}
+		api := srv.NewAPI("test", "test", "test api", "1.0.0")
+		api.Add(cmds...)
+		srv.AddReader(api.Reader())
+		return srv
+	}
+	srv := newServer(
+		MakeAPI2().SetName("a").SetCmd(129),
+		MakeAPI2().SetName("b").SetCmd(130),
+	)
+	defer func() { srv.Close() }()
+
+	cli, err := New("test-client", OsConfigDir(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	addr, err := cli.ConnectDirect(fmt.Sprintf("127.0.0.1:%d", srv.Port()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	apicli, err := cli.NewAPIClient(addr, APICache(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer apicli.Close()
+	changed := make(chan [2][]string, 1)
+	apicli.OnAPIChanged(func(added, removed []string) {
+		changed <- [2][]string{added, removed}
+	})
+
+	t.Run("Cache", func(t *testing.T) {
+		file, _ := apicli.cacheFile("1.0.0")
+		if _, err := os.Stat(file); err != nil {
+			t.Errorf("api cache does not saved: %v", err)
+			return
+		}
+		cached, err := cli.NewAPIClient(addr, APICache(true))
+		if err != nil || len(cached.Snapshot().Apis) != 3 || cached.AppVersion() != "1.0.0" {
+			t.Errorf("wrong cached api: %v %v", cached, err)
+		}
+		cached.Close()
+	})
+
+	t.Run("OnConnect", func(t *testing.T) {
+		// Peer restarts with changed api and client reconnects to it
+		srv.Close()
+		srv = newServer(
+			MakeAPI2().SetName("a").SetCmd(129),
+			MakeAPI2().SetName("c").SetCmd(131),
+		)
+		if _, err := cli.ConnectDirect(fmt.Sprintf("127.0.0.1:%d",
+			srv.Port())); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case ch := <-changed:
+			sort.Strings(ch[0])
+			if len(ch[0]) != 1 || ch[0][0] != "c" || len(ch[1]) != 1 || ch[1][0] != "b" {
+				t.Errorf("wrong changes: added %v, removed %v", ch[0], ch[1])
+			}
+		case <-time.After(time.Second):
+			t.Error("api changed callback does not called")
+			return
+		}
+		if _, ok := apicli.Cmd("c"); !ok {
+			t.Error("api does not refreshed")
+		}
+	})
+
+	// Api description read while it refreshed
+	t.Run("Snapshot", func(t *testing.T) {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				apicli.Refresh()
+			}
+		}()
+		for i := 0; i < 100; i++ {
+			apicli.Help(true)
+			apicli.AppVersion()
+		}
+		wg.Wait()
+		if ar := apicli.Snapshot(); len(ar.Apis) != 3 || ar.AppName() != "test" {
+			t.Errorf("wrong api snapshot: %v", ar.Apis)
+		}
+	})
+}
@@ -8,10 +8,13 @@ package teonet
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"net"
+	"sync"
 
 	"github.com/kirill-scherba/bslice"
 )
@@ -19,7 +22,7 @@ import (
 const (
 	// Get server api command
 	CmdServerAPI = 255
-	// Get server api command
+	// Get client api command
 	CmdClientAPI = 254
 )
 
@@ -29,14 +32,22 @@ const (
 
 var (
 	ErrWoronCommand = errors.New("wrong command")
+	ErrNoSchema     = errors.New("command has no schema")
 )
 
-// APIClient contains clients api data and receive methods
+// APIClient contains clients api data and receive methods. The api
+// description replaced when api refreshed, read it by Snapshot
 type APIClient struct {
-	APIDataAr
-	address string
-	cmdAPI  byte
-	teo     *Teonet
+	UserField    interface{} // Some user field
+	ar           *APIDataAr  // API description, guarded by m
+	address      string
+	cmdAPI       byte
+	versionRange APIVersionRange
+	cache        bool
+	onChanged    func(added, removed []string)
+	readerIdx    int // Refresh reader index + 1, 0 if reader not added
+	teo          *Teonet
+	m            *sync.RWMutex
 }
 type APIDataAr struct {
 	name      string      // API (application) name
@@ -48,6 +59,16 @@ type APIDataAr struct {
 	bslice.ByteSlice
 }
 
+// MarshalBinary binary marshal APIDataAr, the data is the same as received
+// from peer api command
+func (a APIDataAr) MarshalBinary() (data []byte, err error) {
+	api := API{name: a.name, short: a.short, long: a.long, version: a.version}
+	for i := range a.Apis {
+		api.cmds = append(api.cmds, a.Apis[i])
+	}
+	return api.MarshalBinary()
+}
+
 // UnmarshalBinary binary unmarshal APIDataAr
 func (a *APIDataAr) UnmarshalBinary(data []byte) (err error) {
 	var buf = bytes.NewBuffer(data)
@@ -76,20 +97,55 @@ func (a *APIDataAr) UnmarshalBinary(data []byte) (err error) {
 		a.Apis = append(a.Apis, api)
 	}
 
+	// Read commands extensions
+	var exts apiExts
+	if err = exts.read(buf); err != nil {
+		return
+	}
+	exts.apply(a.Apis)
+
 	return
 }
 
-// NewAPIClient create new APIClient object
-func (teo *Teonet) NewAPIClient(address string, cmdAPIs ...byte) (apicli *APIClient, err error) {
+// NewAPIClient create new APIClient object. The attr parameters:
+//
+//	byte or int            api command number, CmdServerAPI by default
+//	APIVersionRange        required peer api version range, ErrAPIVersion
+//	                       returns if peer api version does not match
+//	APICache               cache api description on disk
+//
+// The api description refreshes automatically when peer connected, use
+// Close to stop refreshing.
+func (teo *Teonet) NewAPIClient(address string, attr ...interface{}) (apicli *APIClient, err error) {
 	apicli = new(APIClient)
 	apicli.teo = teo
 	apicli.address = address
-	if len(cmdAPIs) > 0 {
-		apicli.cmdAPI = cmdAPIs[0]
-	} else {
-		apicli.cmdAPI = CmdServerAPI
+	apicli.cmdAPI = CmdServerAPI
+	apicli.ar = new(APIDataAr)
+	apicli.m = new(sync.RWMutex)
+	for i := range attr {
+		switch v := attr[i].(type) {
+		case byte:
+			apicli.cmdAPI = v
+		case int:
+			apicli.cmdAPI = byte(v)
+		case APIVersionRange:
+			apicli.versionRange = v
+		case APICache:
+			apicli.cache = bool(v)
+		}
 	}
-	err = apicli.getApi()
+
+	// Get api from cache and refresh it in background, or get api from peer
+	switch {
+	case apicli.cache && apicli.loadCache():
+		go apicli.Refresh()
+	default:
+		if err = apicli.Refresh(); err != nil {
+			return
+		}
+	}
+	apicli.refreshOnConnect()
 	return
 }
 
@@ -97,17 +153,102 @@ func (teo *Teonet) NewAPIClient(address string, cmdAPIs ...byte) (apicli *APICli
 func (api *APIClient) SendTo(command interface{}, data []byte,
 	waits ...func(data []byte, err error)) (id int, err error) {
 
-	cmd, err := api.GetCmd(command)
+	cmd, ext, err := api.GetCmdExt(command)
 	if err != nil {
 		return
 	}
-	id, err = api.teo.Command(cmd, data).SendTo(api.address)
+	if !api.hasCmd(cmd, ext) {
+		err = ErrAPIUnknownCommand
+		return
+	}
+	c := api.teo.Command(cmd, data)
+	c.Ext = ext
+	id, err = c.SendTo(api.address)
 	if len(waits) > 0 {
-		go func() { waits[0](api.WaitFrom(cmd, uint32(id))) }()
+		go func() { waits[0](api.WaitFrom(cmdAttr(cmd, ext), uint32(id))) }()
 	}
 	return
 }
 
+// Request sends api command and wait answer. The answer is received by
+// request id, or depending of command answer mode from old peers. Commands
+// with NoAnswer mode return after sending
+func (api *APIClient) Request(ctx context.Context, command interface{},
+	data []byte) (reply []byte, err error) {
+
+	cmd, ext, err := api.GetCmdExt(command)
+	if err != nil {
+		return
+	}
+	if !api.hasCmd(cmd, ext) {
+		err = ErrAPIUnknownCommand
+		return
+	}
+	answerMode := CmdAnswer
+	if mode, ok := api.AnswerMode(cmdAttr(cmd, ext)); ok && cmd != api.cmdAPI {
+		answerMode = mode
+	}
+	return api.teo.RequestAPI(ctx, api.address, Command{Cmd: cmd, Ext: ext,
+		Data: data}, answerMode)
+}
+
+// APIStream is streaming reply received by APIClient.Stream
+type APIStream struct {
+	C   <-chan []byte // Reply parts, closed after last part or error
+	err error
+}
+
+// Err return reply error after C channel closed: error sent by handler or
+// ctx error. It returns nil when reply finished successfully
+func (s *APIStream) Err() error {
+	return s.err
+}
+
+// Stream sends streaming request and returns stream to receive reply parts.
+// The stream channel closes after last part, on error or when ctx canceled,
+// the stream Err returns the reply error after channel closed
+func (api *APIClient) Stream(ctx context.Context, command interface{},
+	data []byte) (stream *APIStream, err error) {
+
+	cmd, ext, err := api.GetCmdExt(command)
+	if err != nil {
+		return
+	}
+	if !api.hasCmd(cmd, ext) {
+		err = ErrAPIUnknownCommand
+		return
+	}
+	// Extended command number sends at the beginning of data
+	req := Command{Cmd: cmd, Ext: ext, Data: data}.Bytes()
+	s, err := api.teo.RequestStream(ctx, api.address, req[0], req[1:])
+	if err != nil {
+		return
+	}
+
+	ch := make(chan []byte)
+	stream = &APIStream{C: ch}
+	go func() {
+		defer close(ch)
+		defer s.Close()
+		for {
+			data, err := s.Recv()
+			if err != nil {
+				if err != io.EOF {
+					stream.err = err
+				}
+				return
+			}
+			select {
+			case ch <- data:
+			case <-ctx.Done():
+				stream.err = ctx.Err()
+				return
+			}
+		}
+	}()
+	return
+}
+
 // WaitFrom wait receiving data from peer. The third function parameter is
 // timeout. It may be omitted or contain timeout time of time. Duration type.
 // If timeout parameter is omitted than default timeout value sets to 2 second.
@@ -117,7 +258,7 @@ func (api *APIClient) SendTo(command interface{}, data []byte,
 func (api *APIClient) WaitFrom(command interface{}, packetID ...interface{}) (data []byte, err error) {
 
 	// Get command number
-	cmd, err := api.GetCmd(command)
+	cmd, ext, err := api.GetCmdExt(command)
 	if err != nil {
 		return
 	}
@@ -141,7 +282,7 @@ func (api *APIClient) WaitFrom(command interface{}, packetID ...interface{}) (da
 	// Set WaitFrom attributes depend of answer mode
 	var attr []interface{}
 	if answerMode&CmdAnswer > 0 {
-		attr = append(attr, cmd)
+		attr = append(attr, cmdAttr(cmd, ext))
 	}
 	if answerMode&PacketIDAnswer > 0 {
 		attr = append(attr, packetID...)
@@ -154,9 +295,10 @@ func (api *APIClient) WaitFrom(command interface{}, packetID ...interface{}) (da
 
 // Cmd get command number by name.
 func (api *APIClient) Cmd(name string) (cmd byte, ok bool) {
-	for i := range api.Apis {
-		if api.Apis[i].name == name {
-			cmd = api.Apis[i].cmd
+	apis := api.apis()
+	for i := range apis {
+		if apis[i].name == name {
+			cmd = apis[i].cmd
 			ok = true
 			return
 		}
@@ -164,6 +306,24 @@ func (api *APIClient) Cmd(name string) (cmd byte, ok bool) {
 	return
 }
 
+// hasCmd return true if command exists in api. The api command and any
+// command when api is not loaded yet are valid
+func (api *APIClient) hasCmd(cmd byte, ext ExtCmd) bool {
+	if cmd == api.cmdAPI || len(api.apis()) == 0 {
+		return true
+	}
+	_, ok := api.apiData(cmdAttr(cmd, ext))
+	return ok
+}
+
+// cmdAttr return command number as byte or as ExtCmd for extended command
+func cmdAttr(cmd byte, ext ExtCmd) interface{} {
+	if cmd == CmdExtended {
+		return ext
+	}
+	return cmd
+}
+
 // Return get return parameter by cmd number or name.
 func (api *APIClient) Return(command interface{}) (ret string, ok bool) {
 	a, ok := api.apiData(command)
@@ -182,6 +342,51 @@ func (api *APIClient) AnswerMode(command interface{}) (ret APIanswerMode, ok boo
 	return
 }
 
+// Params get parameters schema by cmd number or name.
+func (api *APIClient) Params(command interface{}) (s *Schema, ok bool) {
+	a, ok := api.apiData(command)
+	if ok && a.params != nil {
+		s = a.params
+		return
+	}
+	return nil, false
+}
+
+// Returns get return data schema by cmd number or name.
+func (api *APIClient) Returns(command interface{}) (s *Schema, ok bool) {
+	a, ok := api.apiData(command)
+	if ok && a.returns != nil {
+		s = a.returns
+		return
+	}
+	return nil, false
+}
+
+// EncodeArgs validate command arguments and encode it by command
+// parameters schema. Arguments values may be in go types or in strings.
+func (api *APIClient) EncodeArgs(command interface{},
+	args map[string]interface{}) (data []byte, err error) {
+
+	s, ok := api.Params(command)
+	if !ok {
+		err = ErrNoSchema
+		return
+	}
+	return s.Encode(args)
+}
+
+// DecodeReturn decode command answer data by command return data schema.
+func (api *APIClient) DecodeReturn(command interface{}, data []byte) (
+	values map[string]interface{}, err error) {
+
+	s, ok := api.Returns(command)
+	if !ok {
+		err = ErrNoSchema
+		return
+	}
+	return s.Decode(data)
+}
+
 // String stringlify APIClient, return same string as Help function.
 func (api APIClient) String() (str string) {
 	str += api.Help(false)
@@ -190,18 +395,19 @@ func (api APIClient) String() (str string) {
 
 // APIClient return APICient help in string.
 func (api APIClient) Help(short bool) (str string) {
+	ar := api.Snapshot()
 
 	// Name version and description
-	str += fmt.Sprintf("%s, ver %s\n", api.name, api.version)
-	str += fmt.Sprintf("(short name: %s)\n\n", api.short)
-	if api.long != "" {
-		str += api.long + "\n\n"
+	str += fmt.Sprintf("%s, ver %s\n", ar.name, ar.version)
+	str += fmt.Sprintf("(short name: %s)\n\n", ar.short)
+	if ar.long != "" {
+		str += ar.long + "\n\n"
 	}
 
 	// Calculate name lenngth
 	var max int
-	for i := range api.Apis {
-		if l := len(api.Apis[i].Name()); l > max {
+	for i := range ar.Apis {
+		if l := len(ar.Apis[i].Name()); l > max {
 			max = l
 		}
 	}
@@ -209,17 +415,17 @@ func (api APIClient) Help(short bool) (str string) {
 	// Commands
 	// TODO: make common function to get commands here and in api server print
 	str += "API commands:\n\n"
-	for i, a := range api.Apis {
+	for i, a := range ar.Apis {
 		if i > 0 {
 			str += "\n"
 		}
 		if short {
-			str += fmt.Sprintf("%-*s %3d - %s", max, a.Name(), a.Cmd(), a.Short())
+			str += fmt.Sprintf("%-*s %3s - %s", max, a.Name(), cmdString(a.cmd, a.ext), a.Short())
 			continue
 		}
 
 		str += fmt.Sprintf("%-*s %s\n", max, a.Name(), a.Short())
-		str += fmt.Sprintf("%*s cmd:    %d\n", max, "", a.Cmd())
+		str += fmt.Sprintf("%*s cmd:    %s\n", max, "", cmdString(a.cmd, a.ext))
 		str += fmt.Sprintf("%*s usage:  %s\n", max, "", a.Name()+" "+a.Usage())
 		var answer string
 		if a.answerMode&CmdAnswer > 0 {
@@ -240,28 +446,58 @@ func (api APIClient) Help(short bool) (str string) {
 func (api APIClient) Address() string { return api.address }
 
 // AppShort returns application short name.
-func (api APIClient) AppShort() string { return api.short }
+func (api APIClient) AppShort() string { return api.Snapshot().short }
 
 // AppName returns application name.
-func (api APIClient) AppName() string { return api.name }
+func (api APIClient) AppName() string { return api.Snapshot().name }
 
 // AppLong returns application long name (description).
-func (api APIClient) AppLong() string { return api.long }
+func (api APIClient) AppLong() string { return api.Snapshot().long }
+
+// AppVersion returns application version.
+func (api APIClient) AppVersion() string { return api.Snapshot().version }
+
+// AppShort returns application short name.
+func (a APIDataAr) AppShort() string { return a.short }
+
+// AppName returns application name.
+func (a APIDataAr) AppName() string { return a.name }
 
-// GetCmd check command type and return command number.
+// AppLong returns application long name (description).
+func (a APIDataAr) AppLong() string { return a.long }
+
+// AppVersion returns application version.
+func (a APIDataAr) AppVersion() string { return a.version }
+
+// GetCmd check command type and return command number. The command may be
+// byte, int, ExtCmd or command name. The CmdExtended returns for extended
+// commands, use GetCmdExt to get extended command number.
 func (api *APIClient) GetCmd(command interface{}) (cmd byte, err error) {
+	cmd, _, err = api.GetCmdExt(command)
+	return
+}
+
+// GetCmdExt check command type and return command number and extended
+// command number.
+func (api *APIClient) GetCmdExt(command interface{}) (cmd byte, ext ExtCmd,
+	err error) {
+
 	switch v := command.(type) {
 	case byte:
 		cmd = v
 	case int:
 		cmd = byte(v)
+	case ExtCmd:
+		cmd, ext = CmdExtended, v
 	case string:
-		var ok bool
-		cmd, ok = api.Cmd(v)
-		if !ok {
-			err = fmt.Errorf(FmtMsgCommandNotCount, v)
-			return
+		apis := api.apis()
+		for i := range apis {
+			if apis[i].name == v {
+				cmd, ext = apis[i].cmd, apis[i].ext
+				return
+			}
 		}
+		err = fmt.Errorf(FmtMsgCommandNotCount, v)
 	default:
 		panic("wrong type of 'command' argument")
 	}
@@ -279,13 +515,14 @@ func (api *APIClient) DataserverIp() (ip string) {
 
 // apiData get return pointer to APIData by cmd number or name.
 func (api *APIClient) apiData(command interface{}) (ret *APIData, ok bool) {
-	cmd, err := api.GetCmd(command)
+	cmd, ext, err := api.GetCmdExt(command)
 	if err != nil {
 		return
 	}
-	for i := range api.Apis {
-		if api.Apis[i].cmd == cmd {
-			ret = &api.Apis[i]
+	apis := api.apis()
+	for i := range apis {
+		if apis[i].cmd == cmd && (cmd != CmdExtended || apis[i].ext == ext) {
+			ret = &apis[i]
 			ok = true
 			return
 		}
@@ -293,20 +530,28 @@ func (api *APIClient) apiData(command interface{}) (ret *APIData, ok bool) {
 	return
 }
 
-// getApi send cmdAPI command and get answer with APIDataAr: all API definition.
-func (api *APIClient) getApi() (err error) {
-	api.SendTo(api.cmdAPI, nil)
-	data, err := api.WaitFrom(api.cmdAPI)
-	if err != nil {
-		log.Error.Println("can't get api data, err", err)
+// Snapshot return copy of api description. The description replaced when
+// api refreshed, so use this function to read it while api client is in use
+func (api APIClient) Snapshot() (ar APIDataAr) {
+	if api.ar == nil {
 		return
 	}
+	api.m.RLock()
+	defer api.m.RUnlock()
+	return *api.ar
+}
+
+// apis return api commands data
+func (api *APIClient) apis() []APIData { return api.Snapshot().Apis }
 
-	err = api.APIDataAr.UnmarshalBinary(data)
+// fetchApi send cmdAPI command and get answer with APIDataAr: all API
+// definition.
+func (api *APIClient) fetchApi() (data []byte, err error) {
+	// Send required version range to negotiate api version with peer
+	api.SendTo(api.cmdAPI, []byte(api.versionRange))
+	data, err = api.WaitFrom(api.cmdAPI)
 	if err != nil {
-		log.Error.Println("can't unmarshal api data, err", err)
-		return
+		log.Error.Println("can't get api data, err", err)
 	}
-
 	return
 }
@@ -9,6 +9,7 @@ package teonet
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 
 	"github.com/kirill-scherba/bslice"
 )
@@ -21,10 +22,15 @@ type APIData struct {
 	usage       string
 	ret         string
 	cmd         byte
+	ext         ExtCmd
+	params      *Schema
+	returns     *Schema
 	connectMode APIconnectMode
 	answerMode  APIanswerMode
+	access      *AccessPolicy
 	reader      func(c *Channel, p *Packet, data []byte) bool
 	reader2     func(data []byte, answer func(data []byte)) bool
+	handler     APIHandler // Executes instead of readers, set by Handle
 	bslice.ByteSlice
 }
 
@@ -64,6 +70,38 @@ func (a *APIData) SetCmd(cmd byte) *APIData {
 	return a
 }
 
+// SetExtCmd set APIData extended command number
+func (a *APIData) SetExtCmd(ext ExtCmd) *APIData {
+	a.cmd, a.ext = CmdExtended, ext
+	return a
+}
+
+// SetParams set APIData parameters schema. The usage text sets from schema
+// if it is empty. It panics if schema is wrong
+func (a *APIData) SetParams(params Schema) *APIData {
+	if err := params.Check(); err != nil {
+		panic(fmt.Sprintf("wrong params schema of command '%s': %s", a.name, err))
+	}
+	a.params = &params
+	if a.usage == "" {
+		a.usage = params.String()
+	}
+	return a
+}
+
+// SetReturns set APIData return data schema. The return description sets
+// from schema if it is empty. It panics if schema is wrong
+func (a *APIData) SetReturns(returns Schema) *APIData {
+	if err := returns.Check(); err != nil {
+		panic(fmt.Sprintf("wrong returns schema of command '%s': %s", a.name, err))
+	}
+	a.returns = &returns
+	if a.ret == "" {
+		a.ret = returns.String()
+	}
+	return a
+}
+
 // SetConnectMode set APIData connect mode ( server|client|client&server )
 func (a *APIData) SetConnectMode(connectMode APIconnectMode) *APIData {
 	a.connectMode = connectMode
@@ -106,6 +144,15 @@ func (a APIData) Ret() string { return a.ret }
 // Cmd return APIData cmd number
 func (a APIData) Cmd() byte { return a.cmd }
 
+// ExtCmd return APIData extended command number
+func (a APIData) ExtCmd() ExtCmd { return a.ext }
+
+// Params return APIData parameters schema or nil if schema is not set
+func (a APIData) Params() *Schema { return a.params }
+
+// Returns return APIData return data schema or nil if schema is not set
+func (a APIData) Returns() *Schema { return a.returns }
+
 // ExecMode return APIData exec mode
 func (a APIData) ExecMode() (APIconnectMode, APIanswerMode) {
 	return a.connectMode, a.answerMode
@@ -118,6 +165,9 @@ func (a APIData) Reader(c *Channel, p *Packet, data []byte) bool {
 
 // Reader2 return APIData second reader
 func (a APIData) Reader2(data []byte, answer func(data []byte)) bool {
+	if a.reader2 == nil {
+		return false
+	}
 	return a.reader2(data, answer)
 }
 
@@ -0,0 +1,184 @@
+// Copyright 2023 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet api errors module: error answer frames which server sends when
+// command fails and client returns as typed go error
+
+package teonet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/kirill-scherba/bslice"
+)
+
+const (
+	apiErrorPrefix    = "aerr-" // Reader2 error answer prefix
+	apiErrorHeaderLen = 1 + 4   // cmd, packet id
+)
+
+// APIErrorCode is api error code
+type APIErrorCode uint32
+
+// API error codes. Applications may use own codes started from
+// APIErrUserCodes
+const (
+	APIErrInternal APIErrorCode = iota + 1
+	APIErrUnknownCommand
+	APIErrBadArgument
+	APIErrNotAuthorized
+	APIErrFailed
+	APIErrTimeout
+	APIErrBusy
+
+	APIErrUserCodes APIErrorCode = 1000
+)
+
+// API errors to match received errors with errors.Is. Errors matches by code
+var (
+	ErrAPIInternal       = &APIError{Code: APIErrInternal, Message: "internal error"}
+	ErrAPIUnknownCommand = &APIError{Code: APIErrUnknownCommand, Message: "unknown command"}
+	ErrAPIBadArgument    = &APIError{Code: APIErrBadArgument, Message: "bad argument"}
+	ErrAPINotAuthorized  = &APIError{Code: APIErrNotAuthorized, Message: "not authorized"}
+	ErrAPIFailed         = &APIError{Code: APIErrFailed, Message: "command processing failed"}
+	ErrAPITimeout        = &APIError{Code: APIErrTimeout, Message: "command processing timeout"}
+	ErrAPIBusy           = &APIError{Code: APIErrBusy, Message: "too many commands in process"}
+)
+
+// APIError is error received from remote api. Use errors.Is to check error
+// code or errors.As to get message and details
+type APIError struct {
+	Code    APIErrorCode // Error code
+	Message string       // Error message
+	Details []byte       // Optional error details
+	bslice.ByteSlice
+}
+
+// NewAPIError create new api error
+func NewAPIError(code APIErrorCode, message string, details ...[]byte) *APIError {
+	e := &APIError{Code: code, Message: message}
+	if len(details) > 0 {
+		e.Details = details[0]
+	}
+	return e
+}
+
+// Error return error string
+func (e *APIError) Error() string {
+	return fmt.Sprintf("api error %d: %s", e.Code, e.Message)
+}
+
+// Is return true if target is *APIError with the same code
+func (e *APIError) Is(target error) bool {
+	t, ok := target.(*APIError)
+	return ok && t.Code == e.Code
+}
+
+// MarshalBinary binary marshal APIError
+func (e APIError) MarshalBinary() (data []byte, err error) {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, e.Code)
+	e.WriteSlice(buf, []byte(e.Message))
+	e.WriteSlice(buf, e.Details)
+	data = buf.Bytes()
+	return
+}
+
+// UnmarshalBinary binary unmarshal APIError
+func (e *APIError) UnmarshalBinary(data []byte) (err error) {
+	buf := bytes.NewBuffer(data)
+	if err = binary.Read(buf, binary.LittleEndian, &e.Code); err != nil {
+		return
+	}
+	if e.Message, err = e.ReadString(buf); err != nil {
+		return
+	}
+	e.Details, err = e.ReadSlice(buf)
+	return
+}
+
+// toAPIError convert any error to *APIError
+func toAPIError(err error) (apiErr *APIError) {
+	if errors.As(err, &apiErr) {
+		return
+	}
+	return &APIError{Code: APIErrInternal, Message: err.Error()}
+}
+
+// parseAPIError unmarshal received error frame data
+func parseAPIError(data []byte) (apiErr *APIError) {
+	apiErr = new(APIError)
+	if err := apiErr.UnmarshalBinary(data); err != nil {
+		apiErr = &APIError{Code: APIErrInternal, Message: "wrong error frame"}
+	}
+	return
+}
+
+// SendError send error answer to command packet received from channel. The
+// error sends to peers which support api errors only, returns false if error
+// was not sent. Errors which are not *APIError sends with APIErrInternal code
+func (c *Channel) SendError(p *Packet, err error) (sent bool) {
+	if !c.capabilities().has(capAPIError) || p.Packet == nil || len(p.Packet.Data()) == 0 {
+		return
+	}
+	body, _ := toAPIError(err).MarshalBinary()
+
+	// Request received from Request function
+	if p.rpc {
+		frame := rpcFrame(p.rpcID, len(body))
+		_, e := c.send(frameRPCError, append(frame, body...))
+		return e == nil
+	}
+
+	// Command: frame contains command number and packet id to match waiters
+	frame := apiErrorData(p.Packet.Data()[0], uint32(p.ID()), body)
+	_, e := c.send(frameAPIError, frame)
+	return e == nil
+}
+
+// apiErrorData make command error frame
+func apiErrorData(cmd byte, id uint32, body []byte) (frame []byte) {
+	frame = make([]byte, apiErrorHeaderLen, apiErrorHeaderLen+len(body))
+	frame[0] = cmd
+	binary.LittleEndian.PutUint32(frame[1:], id)
+	return append(frame, body...)
+}
+
+// SendError send error answer to command packet
+func (a *API) SendError(c *Channel, p *Packet, err error) bool {
+	return c.SendError(p, err)
+}
+
+// SendError2 send error answer to command received by Reader2. The answer is
+// command error frame with prefix, use APIErrorAnswer to get error from it
+func (a *API) SendError2(cmd APInterface, err error, answer func(data []byte)) {
+	body, _ := toAPIError(err).MarshalBinary()
+	answer(append([]byte(apiErrorPrefix), apiErrorData(cmd.Cmd(), 0, body)...))
+}
+
+// APIErrorAnswer get error from Reader2 answer sent by SendError2. The ok is
+// false if answer is not error
+func APIErrorAnswer(data []byte) (apiErr *APIError, ok bool) {
+	if !bytes.HasPrefix(data, []byte(apiErrorPrefix)) {
+		return
+	}
+	_, _, apiErr, ok = apiErrorFrame(data[len(apiErrorPrefix):])
+	return
+}
+
+// apiErrorFrame parse command error frame, returns command, packet id and
+// error. The ok is false if data is too short
+func apiErrorFrame(data []byte) (cmd byte, id uint32, apiErr *APIError, ok bool) {
+	if len(data) < apiErrorHeaderLen {
+		return
+	}
+	cmd = data[0]
+	id = binary.LittleEndian.Uint32(data[1:])
+	apiErr = parseAPIError(data[apiErrorHeaderLen:])
+	ok = true
+	return
+}
@@ -0,0 +1,106 @@
+// Test of api error answers
+package teonet
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/teonet-go/tru"
+	"github.com/teonet-go/tru/teolog"
+)
+
+func TestAPIError(t *testing.T) {
+
+	if log == nil {
+		log = teolog.New()
+	}
+	teo := new(Teonet)
+
+	t.Run("Marshal", func(t *testing.T) {
+		data, _ := NewAPIError(APIErrBadArgument, "wrong name", []byte("name")).
+			MarshalBinary()
+		apiErr := parseAPIError(data)
+		if apiErr.Code != APIErrBadArgument || apiErr.Message != "wrong name" ||
+			string(apiErr.Details) != "name" {
+			t.Errorf("wrong unmarshalled error: %v", apiErr)
+		}
+	})
+
+	t.Run("ErrorsIsAs", func(t *testing.T) {
+		var err error = NewAPIError(APIErrNotAuthorized, "login first")
+		if !errors.Is(err, ErrAPINotAuthorized) || errors.Is(err, ErrAPIFailed) {
+			t.Error("wrong errors.Is result")
+		}
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || apiErr.Message != "login first" {
+			t.Error("wrong errors.As result")
+		}
+		if toAPIError(errors.New("oops")).Code != APIErrInternal {
+			t.Error("wrong converted error code")
+		}
+	})
+
+	t.Run("WaitReader", func(t *testing.T) {
+		const cmd, id = 129, 11
+		body, _ := ErrAPIBadArgument.MarshalBinary()
+		wr := teo.MakeWaitReader(byte(cmd), uint32(id), 50*time.Millisecond, true)
+		e := &Event{Event: EventAPIError}
+
+		// Error to other command should be skipped
+		p := &Packet{Packet: new(tru.Packet).SetData(apiErrorData(cmd+1, id, body))}
+		if wr.Reader()(nil, p, e) {
+			t.Error("error to other command processed")
+		}
+
+		p = &Packet{Packet: new(tru.Packet).SetData(apiErrorData(cmd, id, body))}
+		if !wr.Reader()(nil, p, e) {
+			t.Error("error does not processed")
+		}
+		if _, err := wr.answer(); !errors.Is(err, ErrAPIBadArgument) {
+			t.Errorf("wrong wait error: %v", err)
+		}
+	})
+}
+
+// Commands not processed by api reader answer errors to requests and
+// commands, unknown commands go to next readers
+func TestAPIReaderError(t *testing.T) {
+	cli, srv, addr := newLocalPeers(t)
+	api := srv.NewAPI("test", "test", "test api", "0.0.1")
+	api.Add(MakeAPI2().SetName("fail").SetCmd(129).
+		SetReader(func(c *Channel, p *Packet, data []byte) bool {
+			return false
+		}))
+	srv.AddReader(api.Reader())
+	next := make(chan []byte, 1)
+	srv.AddReader(func(c *Channel, p *Packet, e *Event) bool {
+		if e.Event == EventData {
+			next <- p.Data()
+			return true
+		}
+		return false
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := cli.Request(ctx, addr, 129, nil); !errors.Is(err, ErrAPIFailed) {
+		t.Errorf("wrong request error: %v", err)
+	}
+
+	cli.Command(129, []byte("data")).SendTo(addr)
+	if _, err := cli.WaitFrom(addr, byte(129), time.Second); !errors.Is(err, ErrAPIFailed) {
+		t.Errorf("wrong command error: %v", err)
+	}
+
+	cli.Command(130, []byte("data")).SendTo(addr)
+	select {
+	case data := <-next:
+		if string(data[1:]) != "data" {
+			t.Errorf("wrong data received by next reader: %q", data)
+		}
+	case <-time.After(time.Second):
+		t.Error("command does not sent to next reader")
+	}
+}
@@ -0,0 +1,252 @@
+// Copyright 2023 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet api export module: export server API or received client APIDataAr
+// to OpenAPI style JSON document and Markdown reference
+
+package teonet
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// String return connect mode name
+func (m APIconnectMode) String() string {
+	switch m {
+	case AnyMode:
+		return "any"
+	case ServerMode:
+		return "server"
+	case ClientMode:
+		return "client"
+	}
+	return "server|client"
+}
+
+// String return answer mode names joined by comma
+func (m APIanswerMode) String() string {
+	var modes []string
+	if m&CmdAnswer > 0 {
+		modes = append(modes, "cmd")
+	}
+	if m&PacketIDAnswer > 0 {
+		modes = append(modes, "packet_id")
+	}
+	if m&DataAnswer > 0 {
+		modes = append(modes, "data")
+	}
+	if len(modes) == 0 {
+		return "none"
+	}
+	return strings.Join(modes, ", ")
+}
+
+// dataAr return server API commands in APIDataAr
+func (a API) dataAr() (ar APIDataAr) {
+	ar.name, ar.short, ar.long, ar.version = a.name, a.short, a.long, a.version
+	for i := range a.cmds {
+		ar.Apis = append(ar.Apis, *a.makeAPIData(a.cmds[i]))
+	}
+	return
+}
+
+// ExportJSON export API to OpenAPI style JSON document
+func (a API) ExportJSON() ([]byte, error) { return a.dataAr().ExportJSON() }
+
+// ExportMarkdown export API to Markdown reference
+func (a API) ExportMarkdown() string { return a.dataAr().ExportMarkdown() }
+
+// ExportJSON export APIDataAr to OpenAPI style JSON document. Every command
+// is 'POST /{command name}' path, teonet specific command data is in 'x-teonet'
+// fields
+func (a APIDataAr) ExportJSON() (data []byte, err error) {
+	paths := make(map[string]interface{})
+	for _, api := range a.Apis {
+		op := map[string]interface{}{
+			"operationId":           api.name,
+			"summary":               api.short,
+			"description":           api.long,
+			"x-teonet-cmd":          api.cmd,
+			"x-teonet-connect-mode": api.connectMode.String(),
+			"x-teonet-answer-mode":  api.answerMode.String(),
+			"x-teonet-usage":        api.usage,
+			"x-teonet-return":       api.ret,
+		}
+		if api.cmd == CmdExtended {
+			op["x-teonet-ext-cmd"] = api.ext
+		}
+		if api.params != nil {
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  schemaContent(api.params),
+			}
+		}
+		response := map[string]interface{}{"description": api.ret}
+		if api.returns != nil {
+			response["content"] = schemaContent(api.returns)
+		}
+		responses := map[string]interface{}{"200": response}
+		if api.answerMode == NoAnswer {
+			responses = map[string]interface{}{
+				"204": map[string]interface{}{"description": "no answer"},
+			}
+		}
+		op["responses"] = responses
+		paths["/"+api.name] = map[string]interface{}{"post": op}
+	}
+
+	doc := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":          a.name,
+			"description":    a.long,
+			"version":        a.version,
+			"x-teonet-short": a.short,
+		},
+		"paths": paths,
+	}
+	buf := new(bytes.Buffer)
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(doc)
+	data = buf.Bytes()
+	return
+}
+
+// ExportMarkdown export APIDataAr to Markdown reference
+func (a APIDataAr) ExportMarkdown() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# %s\n\n", a.name)
+	fmt.Fprintf(&b, "Version: %s, short name: %s\n\n", a.version, a.short)
+	if a.long != "" {
+		fmt.Fprintf(&b, "%s\n\n", a.long)
+	}
+
+	// Commands table
+	b.WriteString("## Commands\n\n")
+	b.WriteString("| Command | Name | Description | Connect mode | Answer mode |\n")
+	b.WriteString("|---|---|---|---|---|\n")
+	for _, api := range a.Apis {
+		fmt.Fprintf(&b, "| %s | [%s](#%s) | %s | %s | %s |\n",
+			cmdString(api.cmd, api.ext), api.name, mdAnchor(api.name),
+			mdEscape(api.short), api.connectMode, api.answerMode)
+	}
+
+	// Commands description
+	for _, api := range a.Apis {
+		fmt.Fprintf(&b, "\n### %s\n\n", api.name)
+		if api.short != "" {
+			fmt.Fprintf(&b, "%s\n\n", api.short)
+		}
+		if api.long != "" {
+			fmt.Fprintf(&b, "%s\n\n", api.long)
+		}
+		fmt.Fprintf(&b, "- Command: %s\n", cmdString(api.cmd, api.ext))
+		fmt.Fprintf(&b, "- Connect mode: %s\n", api.connectMode)
+		fmt.Fprintf(&b, "- Answer mode: %s\n", api.answerMode)
+		fmt.Fprintf(&b, "- Usage: `%s`\n", strings.TrimSpace(api.name+" "+api.usage))
+		if api.ret != "" {
+			fmt.Fprintf(&b, "- Return: `%s`\n", api.ret)
+		}
+		mdSchema(&b, "Parameters", api.params)
+		mdSchema(&b, "Return data", api.returns)
+	}
+	return b.String()
+}
+
+// schemaContent return OpenAPI content object of schema
+func schemaContent(s *Schema) map[string]interface{} {
+	mime := "application/octet-stream"
+	switch {
+	case s.Encoding == EncodingJSON:
+		mime = "application/json"
+	case s.Encoding == EncodingRaw && len(s.Fields) > 0 &&
+		s.Fields[0].Type == SchemaString:
+		mime = "text/plain"
+	}
+	return map[string]interface{}{
+		mime: map[string]interface{}{
+			"schema":            jsonSchema(s.Fields),
+			"x-teonet-encoding": s.Encoding.String(),
+		},
+	}
+}
+
+// jsonSchema return JSON Schema object of fields
+func jsonSchema(fields []SchemaField) map[string]interface{} {
+	props := make(map[string]interface{})
+	var required []string
+	for _, f := range fields {
+		props[f.Name] = jsonSchemaField(f)
+		if !f.Optional {
+			required = append(required, f.Name)
+		}
+	}
+	obj := map[string]interface{}{"type": "object", "properties": props}
+	if len(required) > 0 {
+		obj["required"] = required
+	}
+	return obj
+}
+
+// jsonSchemaField return JSON Schema of field
+func jsonSchemaField(f SchemaField) (s map[string]interface{}) {
+	switch f.Type {
+	case SchemaString:
+		s = map[string]interface{}{"type": "string"}
+	case SchemaBytes:
+		s = map[string]interface{}{"type": "string", "format": "byte"}
+	case SchemaBool:
+		s = map[string]interface{}{"type": "boolean"}
+	case SchemaInt:
+		s = map[string]interface{}{"type": "integer", "format": "int64"}
+	case SchemaUint:
+		s = map[string]interface{}{"type": "integer", "format": "int64",
+			"minimum": 0}
+	case SchemaFloat:
+		s = map[string]interface{}{"type": "number", "format": "double"}
+	case SchemaList:
+		s = map[string]interface{}{"type": "array"}
+		if f.Elem != nil {
+			s["items"] = jsonSchemaField(*f.Elem)
+		}
+	case SchemaStruct:
+		s = jsonSchema(f.Fields)
+	default:
+		s = map[string]interface{}{}
+	}
+	return
+}
+
+// mdSchema write schema fields table
+func mdSchema(b *strings.Builder, title string, s *Schema) {
+	if s == nil {
+		return
+	}
+	fmt.Fprintf(b, "\n%s (%s encoding):\n\n", title, s.Encoding)
+	b.WriteString("| Field | Type | Required |\n")
+	b.WriteString("|---|---|---|\n")
+	for _, f := range s.Fields {
+		required := "yes"
+		if f.Optional {
+			required = "no"
+		}
+		fmt.Fprintf(b, "| %s | `%s` | %s |\n", f.Name, f.typeString(), required)
+	}
+}
+
+// mdAnchor return Markdown header anchor
+func mdAnchor(name string) string {
+	return strings.ReplaceAll(strings.ToLower(name), " ", "-")
+}
+
+// mdEscape escape Markdown table cell text
+func mdEscape(s string) string {
+	return strings.ReplaceAll(s, "|", "\\|")
+}
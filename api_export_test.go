@@ -0,0 +1,96 @@
+// Test of api export to JSON and Markdown
+package teonet
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update golden files in testdata")
+
+// golden compare got with golden file content or update golden file
+func golden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	file := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(file, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("%s does not match golden file:\n%s", name, got)
+	}
+}
+
+func TestAPIExport(t *testing.T) {
+	api := new(Teonet).NewAPI("Test API", "teotest", "Test api | export",
+		"1.2.3")
+	api.Add(
+		MakeAPI2().SetName("hello").SetCmd(129).SetShort("say hello").
+			SetLong("Send hello to peer").SetUsage("<name>").
+			SetReturn("<greeting>").SetConnectMode(AnyMode).
+			SetAnswerMode(CmdAnswer),
+		MakeAPI2().SetName("user").SetCmd(130).SetShort("get user").
+			SetAnswerMode(CmdAnswer|PacketIDAnswer).
+			SetParams(Schema{Encoding: EncodingJSON, Fields: []SchemaField{
+				{Name: "id", Type: SchemaUint},
+				{Name: "fields", Type: SchemaList, Optional: true,
+					Elem: &SchemaField{Type: SchemaString}},
+			}}).
+			SetReturns(Schema{Encoding: EncodingJSON, Fields: []SchemaField{
+				{Name: "name", Type: SchemaString},
+				{Name: "address", Type: SchemaStruct, Fields: []SchemaField{
+					{Name: "city", Type: SchemaString},
+					{Name: "geo", Type: SchemaList,
+						Elem: &SchemaField{Type: SchemaFloat}},
+				}},
+			}}),
+		MakeAPI2().SetName("point").SetExtCmd(1000).SetShort("save point").
+			SetConnectMode(ClientMode).SetAnswerMode(DataAnswer).
+			SetParams(Schema{Encoding: EncodingBinary, Fields: []SchemaField{
+				{Name: "x", Type: SchemaInt},
+				{Name: "ok", Type: SchemaBool},
+				{Name: "raw", Type: SchemaBytes},
+			}}).
+			SetReturns(Schema{Fields: []SchemaField{
+				{Name: "text", Type: SchemaString},
+			}}),
+		MakeAPI2().SetName("log").SetCmd(131).SetShort("write log").
+			SetAnswerMode(NoAnswer),
+	)
+
+	t.Run("JSON", func(t *testing.T) {
+		data, err := api.ExportJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		golden(t, "api_export.json", data)
+	})
+
+	t.Run("Markdown", func(t *testing.T) {
+		golden(t, "api_export.md", []byte(api.ExportMarkdown()))
+	})
+
+	// Client exports the same document from received api description
+	t.Run("Client", func(t *testing.T) {
+		data, _ := api.MarshalBinary()
+		var ar APIDataAr
+		if err := ar.UnmarshalBinary(data); err != nil {
+			t.Fatal(err)
+		}
+		got, _ := ar.ExportJSON()
+		want, _ := api.ExportJSON()
+		if string(got) != string(want) {
+			t.Errorf("wrong client export:\n%s", got)
+		}
+		if ar.ExportMarkdown() != api.ExportMarkdown() {
+			t.Errorf("wrong client markdown:\n%s", ar.ExportMarkdown())
+		}
+	})
+}
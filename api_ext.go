@@ -0,0 +1,174 @@
+// Copyright 2023 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet api extensions module: commands data which does not exists in
+// first API version sends in extensions block after all APIData in
+// APIDataAr. Old clients does not read this block.
+
+package teonet
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/kirill-scherba/bslice"
+)
+
+// API extensions tags
+const (
+	apiExtCmdTag     byte = iota + 1 // Extended command number
+	apiExtParamsTag                  // Parameters schema
+	apiExtReturnsTag                 // Return data schema
+)
+
+// apiExtCmd is optional APInterface method which return extended command
+// number
+type apiExtCmd interface {
+	ExtCmd() ExtCmd
+}
+
+// apiCmdExt return api extended command number if api implements ExtCmd
+func apiCmdExt(api APInterface) (ext ExtCmd) {
+	if e, ok := api.(apiExtCmd); ok {
+		ext = e.ExtCmd()
+	}
+	return
+}
+
+// apiSchemas is optional APInterface methods which return parameters and
+// return data schemas
+type apiSchemas interface {
+	Params() *Schema
+	Returns() *Schema
+}
+
+// apiParams return api parameters schema if api implements Params
+func apiParams(api APInterface) *Schema {
+	if s, ok := api.(apiSchemas); ok {
+		return s.Params()
+	}
+	return nil
+}
+
+// apiReturns return api return data schema if api implements Returns
+func apiReturns(api APInterface) *Schema {
+	if s, ok := api.(apiSchemas); ok {
+		return s.Returns()
+	}
+	return nil
+}
+
+// apiCmdMatch return true if api command number equal to cmd
+func apiCmdMatch(api APInterface, cmd byte, ext ExtCmd) bool {
+	if api.Cmd() != cmd {
+		return false
+	}
+	return cmd != CmdExtended || apiCmdExt(api) == ext
+}
+
+// apiExt is one command extension
+type apiExt struct {
+	idx   uint16 // Command index in APIDataAr
+	tag   byte   // Extension tag
+	value []byte // Extension value
+}
+
+// apiExts is list of commands extensions
+type apiExts struct {
+	list []apiExt
+	bslice.ByteSlice
+}
+
+// add extension
+func (e *apiExts) add(idx int, tag byte, value []byte) {
+	e.list = append(e.list, apiExt{uint16(idx), tag, value})
+}
+
+// write extensions block to buffer
+func (e apiExts) write(buf *bytes.Buffer) {
+	if len(e.list) == 0 {
+		return
+	}
+	binary.Write(buf, binary.LittleEndian, uint16(len(e.list)))
+	for _, ext := range e.list {
+		binary.Write(buf, binary.LittleEndian, ext.idx)
+		binary.Write(buf, binary.LittleEndian, ext.tag)
+		e.WriteSlice(buf, ext.value)
+	}
+}
+
+// read extensions block from buffer
+func (e *apiExts) read(buf *bytes.Buffer) (err error) {
+	if buf.Len() == 0 {
+		return
+	}
+	var num uint16
+	if err = binary.Read(buf, binary.LittleEndian, &num); err != nil {
+		return
+	}
+	for i := 0; i < int(num); i++ {
+		var ext apiExt
+		if err = binary.Read(buf, binary.LittleEndian, &ext.idx); err != nil {
+			return
+		}
+		if err = binary.Read(buf, binary.LittleEndian, &ext.tag); err != nil {
+			return
+		}
+		if ext.value, err = e.ReadSlice(buf); err != nil {
+			return
+		}
+		e.list = append(e.list, ext)
+	}
+	return
+}
+
+// makeAPIExts make extensions of api commands
+func makeAPIExts(cmds []APInterface) (exts apiExts) {
+	for i := range cmds {
+		if cmds[i].Cmd() == CmdExtended {
+			exts.add(i, apiExtCmdTag, binary.LittleEndian.AppendUint16(nil,
+				uint16(apiCmdExt(cmds[i]))))
+		}
+		if s := apiParams(cmds[i]); s != nil {
+			data, _ := s.MarshalBinary()
+			exts.add(i, apiExtParamsTag, data)
+		}
+		if s := apiReturns(cmds[i]); s != nil {
+			data, _ := s.MarshalBinary()
+			exts.add(i, apiExtReturnsTag, data)
+		}
+	}
+	return
+}
+
+// apply extensions to api commands data. Unknown extensions are skipped
+func (e apiExts) apply(apis []APIData) {
+	for _, ext := range e.list {
+		if int(ext.idx) >= len(apis) {
+			continue
+		}
+		a := &apis[ext.idx]
+		switch ext.tag {
+		case apiExtCmdTag:
+			if len(ext.value) >= 2 {
+				a.ext = ExtCmd(binary.LittleEndian.Uint16(ext.value))
+			}
+		case apiExtParamsTag, apiExtReturnsTag:
+			s := new(Schema)
+			err := s.UnmarshalBinary(ext.value)
+			if err == nil {
+				err = s.Check()
+			}
+			if err != nil {
+				log.Debugv.Println("skip wrong api schema of", a.name, err)
+				continue
+			}
+			if ext.tag == apiExtParamsTag {
+				a.params = s
+			} else {
+				a.returns = s
+			}
+		}
+	}
+}
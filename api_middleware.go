@@ -0,0 +1,356 @@
+// Copyright 2023 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet api middleware module: middleware chain which wraps api commands
+// execution, and built in middleware: panic recovery, timeouts, concurrency
+// limits, metrics and logging.
+
+package teonet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+)
+
+// APICall contains api command call data. It passes to middleware and
+// command handler
+type APICall struct {
+	Channel *Channel          // Peer channel, nil when executed by Reader2
+	Packet  *Packet           // Received packet, nil when executed by Reader2
+	Cmd     APInterface       // Command metadata
+	Data    []byte            // Command data
+	Answer  func(data []byte) // Reader2 answer function, nil when executed by Reader
+	Err     error             // Error set by middleware and answered to peer
+
+	// Call context, canceled when call finished, timed out by
+	// TimeoutMiddleware or peer disconnected
+	Ctx context.Context
+
+	// Closed when handler continued in background after TimeoutMiddleware
+	// answered timeout returns, nil if handler finished
+	detached <-chan struct{}
+}
+
+// APIHandler execute api command and return true if command processed
+type APIHandler func(call *APICall) (processed bool)
+
+// Middleware wraps api command handler. Middleware may execute code before
+// and after next handler, answer error or skip next handler execution
+type Middleware func(next APIHandler) APIHandler
+
+// apiMiddleware contains api middleware chain
+type apiMiddleware struct {
+	list []Middleware
+	sync.RWMutex
+}
+
+// Use add middleware to api. Middleware executes in order of adding, the
+// first added middleware is outermost
+func (a *API) Use(middleware ...Middleware) {
+	if a.middleware == nil {
+		a.middleware = new(apiMiddleware)
+	}
+	a.middleware.Lock()
+	defer a.middleware.Unlock()
+	a.middleware.list = append(a.middleware.list, middleware...)
+}
+
+// SendError answer error to peer and set call Err. The error does not send
+// to peer when command executed by Reader2
+func (call *APICall) SendError(err error) {
+	call.Err = err
+	if call.Channel != nil && call.Packet != nil {
+		call.Channel.SendError(call.Packet, err)
+	}
+}
+
+// exec execute command by middleware chain
+func (a API) exec(call *APICall) bool {
+	if call.Ctx == nil {
+		var cancel context.CancelFunc
+		call.Ctx, cancel = context.WithCancel(call.Channel.context())
+		defer cancel()
+	}
+	handler := func(call *APICall) bool {
+		if cmd, ok := call.Cmd.(*APIData); ok && cmd.handler != nil {
+			return cmd.handler(call)
+		}
+		if call.Channel == nil {
+			return call.Cmd.Reader2(call.Data, call.Answer)
+		}
+		return call.Cmd.Reader(call.Channel, call.Packet, call.Data)
+	}
+	if a.middleware != nil {
+		a.middleware.RLock()
+		for i := len(a.middleware.list) - 1; i >= 0; i-- {
+			handler = a.middleware.list[i](handler)
+		}
+		a.middleware.RUnlock()
+	}
+	return handler(call)
+}
+
+// forCommands check middleware applies to the command. It applies to all
+// commands if commands list is empty
+func forCommands(call *APICall, commands []string) bool {
+	if len(commands) == 0 {
+		return true
+	}
+	for _, name := range commands {
+		if call.Cmd.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// RecoverMiddleware recover command handler panic, log it and answer with
+// ErrAPIInternal error
+func RecoverMiddleware() Middleware {
+	return func(next APIHandler) APIHandler {
+		return func(call *APICall) (processed bool) {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Error.Printf("api command %s panic: %v\n%s",
+						call.Cmd.Name(), r, debug.Stack())
+					call.SendError(ErrAPIInternal)
+					processed = true
+				}
+			}()
+			return next(call)
+		}
+	}
+}
+
+// TimeoutMiddleware answer ErrAPITimeout error if command does not processed
+// during timeout. It applies to listed commands or to all commands if list
+// is empty. The call context canceled on timeout, but the command handler
+// continue execution in background and its late answer dropped by peer.
+// ConcurrencyMiddleware added before it holds the call slot until the
+// handler returns
+func TimeoutMiddleware(timeout time.Duration, commands ...string) Middleware {
+	type result struct {
+		call      APICall
+		processed bool
+		panic     interface{}
+	}
+	return func(next APIHandler) APIHandler {
+		return func(call *APICall) (processed bool) {
+			if !forCommands(call, commands) {
+				return next(call)
+			}
+
+			// Execute handler with copy of call, panics returns to this
+			// goroutine
+			ctx, cancel := context.WithTimeout(call.Ctx, timeout)
+			defer cancel()
+			c := *call
+			c.Ctx = ctx
+			done := make(chan result, 1)
+			finished := make(chan struct{})
+			go func(c APICall) {
+				defer close(finished)
+				defer func() {
+					if r := recover(); r != nil {
+						done <- result{call: c, panic: r}
+					}
+				}()
+				processed := next(&c)
+				done <- result{call: c, processed: processed}
+
+				// Wait handler detached by inner timeout middleware
+				if c.detached != nil {
+					<-c.detached
+				}
+			}(c)
+
+			select {
+			case r := <-done:
+				if r.panic != nil {
+					panic(r.panic)
+				}
+				r.call.Ctx = call.Ctx
+				*call = r.call
+
+				// Handler stopped by timeout without answer
+				if ctx.Err() == context.DeadlineExceeded &&
+					errors.Is(call.Err, context.DeadlineExceeded) {
+					log.Debugv.Println("api command", call.Cmd.Name(), "timeout")
+					call.SendError(ErrAPITimeout)
+				}
+				return r.processed
+			case <-ctx.Done():
+				log.Debugv.Println("api command", call.Cmd.Name(), "timeout")
+				call.SendError(ErrAPITimeout)
+				call.detached = finished
+				return true
+			}
+		}
+	}
+}
+
+// ConcurrencyMiddleware limit number of simultaneously executed calls of
+// each command. Calls over limit answered with ErrAPIBusy error. It applies
+// to listed commands or to all commands if list is empty. The call slot
+// holds until command handler returns, also when TimeoutMiddleware answered
+// timeout before it
+func ConcurrencyMiddleware(limit int, commands ...string) Middleware {
+	var m sync.Mutex
+	running := make(map[string]int)
+	return func(next APIHandler) APIHandler {
+		return func(call *APICall) bool {
+			if !forCommands(call, commands) {
+				return next(call)
+			}
+			name := call.Cmd.Name()
+
+			m.Lock()
+			if running[name] >= limit {
+				m.Unlock()
+				call.SendError(ErrAPIBusy)
+				return true
+			}
+			running[name]++
+			m.Unlock()
+
+			release := func() {
+				m.Lock()
+				running[name]--
+				m.Unlock()
+			}
+			defer func() {
+				if call.detached == nil {
+					release()
+					return
+				}
+				go func(detached <-chan struct{}) {
+					<-detached
+					release()
+				}(call.detached)
+			}()
+			return next(call)
+		}
+	}
+}
+
+// LogMiddleware log api commands calls to debug log
+func LogMiddleware() Middleware {
+	return func(next APIHandler) APIHandler {
+		return func(call *APICall) (processed bool) {
+			start := time.Now()
+			defer func() {
+				log.Debug.Printf("api command %s from %s, processed: %v, "+
+					"err: %v, time: %v\n", call.Cmd.Name(), call.Channel,
+					processed, call.Err, time.Since(start))
+			}()
+			return next(call)
+		}
+	}
+}
+
+// APICommandStats contains api command counters
+type APICommandStats struct {
+	Calls      uint64        // Number of calls
+	Errors     uint64        // Number of not processed calls and calls with errors
+	Latency    time.Duration // Total execution time
+	MaxLatency time.Duration // Max execution time
+}
+
+// AvgLatency return average execution time
+func (s APICommandStats) AvgLatency() time.Duration {
+	if s.Calls == 0 {
+		return 0
+	}
+	return s.Latency / time.Duration(s.Calls)
+}
+
+// String return stats string
+func (s APICommandStats) String() string {
+	return fmt.Sprintf("calls: %d, errors: %d, avg: %v, max: %v", s.Calls,
+		s.Errors, s.AvgLatency(), s.MaxLatency)
+}
+
+// APIMetrics collects api commands latency and error counters. Add it to
+// api by api.Use(metrics.Middleware())
+type APIMetrics struct {
+	stats map[string]*APICommandStats
+	sync.RWMutex
+}
+
+// NewAPIMetrics create new APIMetrics object
+func NewAPIMetrics() *APIMetrics {
+	return &APIMetrics{stats: make(map[string]*APICommandStats)}
+}
+
+// Middleware return metrics middleware
+func (m *APIMetrics) Middleware() Middleware {
+	return func(next APIHandler) APIHandler {
+		return func(call *APICall) (processed bool) {
+			start := time.Now()
+			defer func() {
+				m.add(call.Cmd.Name(), time.Since(start), !processed || call.Err != nil)
+			}()
+			return next(call)
+		}
+	}
+}
+
+// add call to command stats
+func (m *APIMetrics) add(name string, latency time.Duration, failed bool) {
+	m.Lock()
+	defer m.Unlock()
+	s, ok := m.stats[name]
+	if !ok {
+		s = new(APICommandStats)
+		m.stats[name] = s
+	}
+	s.Calls++
+	if failed {
+		s.Errors++
+	}
+	s.Latency += latency
+	if latency > s.MaxLatency {
+		s.MaxLatency = latency
+	}
+}
+
+// Get return command stats
+func (m *APIMetrics) Get(name string) (s APICommandStats, ok bool) {
+	m.RLock()
+	defer m.RUnlock()
+	if st, ok := m.stats[name]; ok {
+		return *st, true
+	}
+	return
+}
+
+// Stats return all commands stats
+func (m *APIMetrics) Stats() (stats map[string]APICommandStats) {
+	m.RLock()
+	defer m.RUnlock()
+	stats = make(map[string]APICommandStats, len(m.stats))
+	for name, s := range m.stats {
+		stats[name] = *s
+	}
+	return
+}
+
+// String return all commands stats sorted by command name
+func (m *APIMetrics) String() (str string) {
+	stats := m.Stats()
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		str += fmt.Sprintf("%s: %s\n", name, stats[name])
+	}
+	return
+}
@@ -0,0 +1,126 @@
+// Test of api middleware
+package teonet
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAPIMiddleware(t *testing.T) {
+	cli, srv, addr := newLocalPeers(t)
+
+	release := make(chan struct{})
+	api := srv.NewAPI("test", "test", "test api", "0.0.1")
+	api.Add(
+		MakeAPI2().SetName("hello").SetCmd(129).
+			SetReader(func(c *Channel, p *Packet, data []byte) bool {
+				c.Reply(p, []byte("hello"))
+				return true
+			}),
+		MakeAPI2().SetName("panic").SetCmd(130).
+			SetReader(func(c *Channel, p *Packet, data []byte) bool {
+				panic("test panic")
+			}),
+		MakeAPI2().SetName("slow").SetCmd(131).
+			SetReader(func(c *Channel, p *Packet, data []byte) bool {
+				<-release
+				return true
+			}),
+	)
+	metrics := NewAPIMetrics()
+	var order []string
+	var m sync.Mutex
+	trace := func(name string) Middleware {
+		return func(next APIHandler) APIHandler {
+			return func(call *APICall) bool {
+				if call.Cmd.Name() == "hello" {
+					m.Lock()
+					order = append(order, name)
+					m.Unlock()
+				}
+				return next(call)
+			}
+		}
+	}
+	api.Use(trace("first"), metrics.Middleware(), RecoverMiddleware(),
+		TimeoutMiddleware(50*time.Millisecond, "slow"),
+		ConcurrencyMiddleware(1), trace("last"))
+	srv.AddReader(api.Reader())
+	defer close(release)
+
+	for _, test := range []struct {
+		cmd  byte
+		want error
+	}{
+		{129, nil},
+		{130, ErrAPIInternal},
+		{131, ErrAPITimeout},
+		{131, ErrAPIBusy}, // Previous slow call still running
+	} {
+		_, err := cli.Request(context.Background(), addr, test.cmd, nil)
+		if !errors.Is(err, test.want) {
+			t.Errorf("wrong cmd %d error: %v, want: %v", test.cmd, err, test.want)
+		}
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	if len(order) != 2 || order[0] != "first" || order[1] != "last" {
+		t.Errorf("wrong middleware order: %v", order)
+	}
+	for name, want := range map[string]APICommandStats{
+		"hello": {Calls: 1},
+		"panic": {Calls: 1, Errors: 1},
+		"slow":  {Calls: 2, Errors: 2},
+	} {
+		s, ok := metrics.Get(name)
+		if !ok || s.Calls != want.Calls || s.Errors != want.Errors {
+			t.Errorf("wrong %s stats: %v", name, s)
+		}
+	}
+}
+
+// Concurrency middleware added before timeout middleware holds the call slot
+// until timed out handler returns
+func TestAPIMiddlewareDetached(t *testing.T) {
+	cli, srv, addr := newLocalPeers(t)
+
+	release := make(chan struct{})
+	returned := make(chan struct{})
+	api := srv.NewAPI("test", "test", "test api", "0.0.1")
+	api.Add(MakeAPI2().SetName("slow").SetCmd(129).
+		SetReader(func(c *Channel, p *Packet, data []byte) bool {
+			<-release
+			defer func() { returned <- struct{}{} }()
+			c.Reply(p, []byte("done"))
+			return true
+		}))
+	api.Use(ConcurrencyMiddleware(1), TimeoutMiddleware(50*time.Millisecond))
+	srv.AddReader(api.Reader())
+
+	request := func() error {
+		_, err := cli.Request(context.Background(), addr, 129, nil)
+		return err
+	}
+	if err := request(); !errors.Is(err, ErrAPITimeout) {
+		t.Errorf("wrong first call error: %v", err)
+	}
+	if err := request(); !errors.Is(err, ErrAPIBusy) {
+		t.Errorf("wrong call error while handler running: %v", err)
+	}
+
+	// Slot released after handler returned
+	release <- struct{}{}
+	<-returned
+	time.Sleep(10 * time.Millisecond)
+	go func() {
+		release <- struct{}{}
+		<-returned
+	}()
+	if err := request(); err != nil {
+		t.Errorf("wrong call error after handler returned: %v", err)
+	}
+}
@@ -0,0 +1,539 @@
+// Copyright 2023 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet api pool module: api client of multiple instances of the same
+// service. The pool selects instance by round-robin, least-latency or
+// consistent-hash policy, retries idempotent commands on other instance,
+// breaks circuit to failed instances and may hedge slow requests.
+
+package teonet
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PoolPolicy is pool instance selection policy
+type PoolPolicy byte
+
+// Pool instance selection policies
+const (
+	// RoundRobin - select instances in turn
+	RoundRobin PoolPolicy = iota
+
+	// LeastLatency - select instance with the least channel triptime
+	LeastLatency
+
+	// ConsistentHash - select instance by request key, the same key goes to
+	// the same instance while it available
+	ConsistentHash
+)
+
+// Idempotent used in NewAPIPool parameter to set names of commands which
+// may be executed more than once: retried on other instance and hedged
+type Idempotent []string
+
+// PoolRetries used in NewAPIPool parameter to set max number of retries on
+// other instances, number of instances - 1 by default
+type PoolRetries int
+
+// PoolHedge used in NewAPIPool parameter to set delay after which idempotent
+// request sends to other instance if first instance does not answer yet.
+// Hedging is disabled by default
+type PoolHedge time.Duration
+
+// CircuitBreaker used in NewAPIPool parameter to set instances circuit
+// breaker: instance does not used during OpenTimeout after Failures
+// consecutive failures, then one trial request sends to it
+type CircuitBreaker struct {
+	Failures    int
+	OpenTimeout time.Duration
+}
+
+// Default circuit breaker
+const (
+	DefaultBreakerFailures    = 5
+	DefaultBreakerOpenTimeout = 10 * time.Second
+)
+
+// ErrNoInstances returns by pool when there is not available instances
+var ErrNoInstances = errors.New("no available service instances")
+
+// APIPool is api client of multiple service instances
+type APIPool struct {
+	teo        *Teonet
+	members    []*poolMember
+	policy     PoolPolicy
+	idempotent map[string]bool
+	retries    int // Max retries, -1 means number of instances - 1
+	hedge      time.Duration
+	breaker    CircuitBreaker
+	clientAttr []interface{} // NewAPIClient attributes
+	next       uint32        // Round-robin counter
+	sync.RWMutex
+}
+
+// poolMember is pool instance
+type poolMember struct {
+	address  string
+	client   *APIClient
+	creating *poolCreate // Api client creating, nil if does not creating
+	removed  bool        // Instance removed from pool
+	failures int         // Consecutive failures
+	openTill time.Time   // Circuit open till, zero if closed
+	trial    bool        // Half open circuit trial request sent
+	sync.Mutex
+}
+
+// poolCreate contains result of instance api client creating shared by
+// concurrent getClient calls
+type poolCreate struct {
+	done   chan struct{}
+	client *APIClient
+	err    error
+}
+
+// PoolInstance contains pool instance state
+type PoolInstance struct {
+	Address   string
+	Connected bool          // Api client created
+	Open      bool          // Circuit open, instance does not used
+	Failures  int           // Consecutive failures
+	Triptime  time.Duration // Channel triptime
+}
+
+// NewAPIPool create api pool of service instances addresses. The attr
+// parameters:
+//
+//	PoolPolicy       instance selection policy, RoundRobin by default
+//	Idempotent       names of commands which may be retried and hedged
+//	PoolRetries      max number of retries on other instances
+//	PoolHedge        hedge idempotent requests after delay
+//	CircuitBreaker   instances circuit breaker parameters
+//	APIVersionRange  required api version range, used in NewAPIClient
+//	APICache         cache api description, used in NewAPIClient
+//
+// The api clients of instances create when the pool created or when
+// instance selected first time, instances which api does not received are
+// skipped
+func (teo *Teonet) NewAPIPool(addresses []string, attr ...interface{}) (
+	pool *APIPool) {
+
+	pool = &APIPool{
+		teo:        teo,
+		idempotent: make(map[string]bool),
+		retries:    -1,
+		breaker:    CircuitBreaker{DefaultBreakerFailures, DefaultBreakerOpenTimeout},
+	}
+	for i := range attr {
+		switch v := attr[i].(type) {
+		case PoolPolicy:
+			pool.policy = v
+		case Idempotent:
+			for _, name := range v {
+				pool.idempotent[name] = true
+			}
+		case PoolRetries:
+			pool.retries = int(v)
+		case PoolHedge:
+			pool.hedge = time.Duration(v)
+		case CircuitBreaker:
+			pool.breaker = v
+		case APIVersionRange, APICache:
+			pool.clientAttr = append(pool.clientAttr, v)
+		}
+	}
+	for _, address := range addresses {
+		pool.Add(address)
+	}
+	return
+}
+
+// Add add instance address to pool
+func (pool *APIPool) Add(address string) {
+	pool.Lock()
+	defer pool.Unlock()
+	for _, m := range pool.members {
+		if m.address == address {
+			return
+		}
+	}
+	m := &poolMember{address: address}
+	pool.members = append(pool.members, m)
+	go m.getClient(pool)
+}
+
+// Del remove instance address from pool
+func (pool *APIPool) Del(address string) {
+	pool.Lock()
+	defer pool.Unlock()
+	for i, m := range pool.members {
+		if m.address == address {
+			pool.members = append(pool.members[:i], pool.members[i+1:]...)
+			m.Lock()
+			m.removed = true
+			if m.client != nil {
+				m.client.Close()
+			}
+			m.Unlock()
+			return
+		}
+	}
+}
+
+// Close close instances api clients
+func (pool *APIPool) Close() {
+	for _, address := range pool.Addresses() {
+		pool.Del(address)
+	}
+}
+
+// Addresses return pool instances addresses
+func (pool *APIPool) Addresses() (addresses []string) {
+	pool.RLock()
+	defer pool.RUnlock()
+	for _, m := range pool.members {
+		addresses = append(addresses, m.address)
+	}
+	return
+}
+
+// Instances return pool instances state
+func (pool *APIPool) Instances() (instances []PoolInstance) {
+	pool.RLock()
+	members := append([]*poolMember(nil), pool.members...)
+	pool.RUnlock()
+	for _, m := range members {
+		m.Lock()
+		instances = append(instances, PoolInstance{
+			Address:   m.address,
+			Connected: m.client != nil,
+			Open:      time.Now().Before(m.openTill),
+			Failures:  m.failures,
+			Triptime:  pool.triptime(m.address),
+		})
+		m.Unlock()
+	}
+	return
+}
+
+// Request sends api command to selected instance and wait answer. The
+// consistent hash policy uses command name as request key, use RequestKey
+// to set key
+func (pool *APIPool) Request(ctx context.Context, command interface{},
+	data []byte) (reply []byte, err error) {
+	return pool.RequestKey(ctx, "", command, data)
+}
+
+// RequestKey sends api command to instance selected by key and wait
+// answer. The key used by ConsistentHash policy only
+func (pool *APIPool) RequestKey(ctx context.Context, key string,
+	command interface{}, data []byte) (reply []byte, err error) {
+
+	name := pool.commandName(command)
+	if key == "" {
+		key = name
+	}
+	idempotent := pool.idempotent[name]
+
+	retries := pool.retries
+	if retries < 0 {
+		pool.RLock()
+		retries = len(pool.members) - 1
+		pool.RUnlock()
+	}
+	if retries < 0 {
+		retries = 0
+	}
+
+	tried := make(map[*poolMember]bool)
+	for attempt := 0; attempt <= retries; attempt++ {
+		var sent bool
+		if idempotent && pool.hedge > 0 {
+			reply, sent, err = pool.hedgeRequest(ctx, key, command, data, tried)
+		} else {
+			reply, sent, err = pool.request(ctx, key, command, data, tried)
+		}
+
+		// Retry idempotent commands and commands which was not sent
+		switch {
+		case err == nil, !instanceFailure(err), ctx.Err() != nil:
+			return
+		case sent && !idempotent:
+			return
+		case errors.Is(err, ErrNoInstances):
+			return
+		}
+		log.Debugv.Println("pool request", name, "retry, err:", err)
+	}
+	return
+}
+
+// request send request to one selected instance
+func (pool *APIPool) request(ctx context.Context, key string,
+	command interface{}, data []byte, tried map[*poolMember]bool) (
+	reply []byte, sent bool, err error) {
+
+	m, client := pool.selectMember(key, tried)
+	if m == nil {
+		err = ErrNoInstances
+		return
+	}
+	tried[m] = true
+	return pool.call(ctx, m, client, command, data)
+}
+
+// call send request to instance and update instance circuit breaker
+func (pool *APIPool) call(ctx context.Context, m *poolMember, client *APIClient,
+	command interface{}, data []byte) (reply []byte, sent bool, err error) {
+
+	reply, err = client.Request(ctx, command, data)
+	sent = !errors.Is(err, ErrPeerNotConnected)
+	pool.result(m, err)
+	return
+}
+
+// hedgeRequest send request to selected instance and to next instance if
+// first does not answer during hedge delay. Returns the first successful
+// answer or the last error
+func (pool *APIPool) hedgeRequest(ctx context.Context, key string,
+	command interface{}, data []byte, tried map[*poolMember]bool) (
+	reply []byte, sent bool, err error) {
+
+	type result struct {
+		reply []byte
+		sent  bool
+		err   error
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan result, 2)
+	start := func(m *poolMember, client *APIClient) {
+		tried[m] = true
+		go func() {
+			var r result
+			r.reply, r.sent, r.err = pool.call(ctx, m, client, command, data)
+			results <- r
+		}()
+	}
+
+	m, client := pool.selectMember(key, tried)
+	if m == nil {
+		err = ErrNoInstances
+		return
+	}
+	start(m, client)
+	running := 1
+
+	timer := time.NewTimer(pool.hedge)
+	defer timer.Stop()
+	hedge := timer.C
+	for running > 0 {
+		select {
+		case <-hedge:
+			hedge = nil
+			if m, client := pool.selectMember(key, tried); m != nil {
+				log.Debugv.Println("pool hedge request to", m.address)
+				start(m, client)
+				running++
+			}
+		case r := <-results:
+			running--
+			reply, sent, err = r.reply, sent || r.sent, r.err
+			if err == nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// selectMember select instance by pool policy from available instances
+// which was not tried
+func (pool *APIPool) selectMember(key string, tried map[*poolMember]bool) (
+	m *poolMember, client *APIClient) {
+
+	pool.RLock()
+	var candidates []*poolMember
+	for _, m := range pool.members {
+		if !tried[m] && m.available() {
+			candidates = append(candidates, m)
+		}
+	}
+	pool.RUnlock()
+
+	for len(candidates) > 0 {
+		var idx int
+		switch pool.policy {
+		case LeastLatency:
+			for i := range candidates {
+				if pool.triptime(candidates[i].address) <
+					pool.triptime(candidates[idx].address) {
+					idx = i
+				}
+			}
+		case ConsistentHash:
+			var max uint64
+			for i := range candidates {
+				if w := hashWeight(key, candidates[i].address); w > max {
+					idx, max = i, w
+				}
+			}
+		default:
+			idx = int(atomic.AddUint32(&pool.next, 1)-1) % len(candidates)
+		}
+
+		// Skip instances which api client can't be created
+		m = candidates[idx]
+		var err error
+		if client, err = m.getClient(pool); err == nil && m.take() {
+			return
+		}
+		if err != nil {
+			pool.result(m, err)
+		}
+		tried[m] = true
+		candidates = append(candidates[:idx], candidates[idx+1:]...)
+	}
+	return nil, nil
+}
+
+// result update instance circuit breaker by request result
+func (pool *APIPool) result(m *poolMember, err error) {
+	m.Lock()
+	defer m.Unlock()
+	m.trial = false
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	if err == nil || !instanceFailure(err) {
+		m.failures, m.openTill = 0, time.Time{}
+		return
+	}
+	m.failures++
+	if m.failures >= pool.breaker.Failures {
+		m.openTill = time.Now().Add(pool.breaker.OpenTimeout)
+		log.Debug.Println("pool instance", m.address, "circuit open, err:", err)
+	}
+}
+
+// triptime return instance channel triptime or max duration if instance
+// does not connected
+func (pool *APIPool) triptime(address string) time.Duration {
+	c, ok := pool.teo.channels.get(address)
+	if !ok {
+		return time.Duration(1<<63 - 1)
+	}
+	return c.Triptime()
+}
+
+// commandName return command name by command number or name
+func (pool *APIPool) commandName(command interface{}) string {
+	if name, ok := command.(string); ok {
+		return name
+	}
+	pool.RLock()
+	defer pool.RUnlock()
+	for _, m := range pool.members {
+		m.Lock()
+		client := m.client
+		m.Unlock()
+		if client == nil {
+			continue
+		}
+		if a, ok := client.apiData(command); ok {
+			return a.name
+		}
+	}
+	return ""
+}
+
+// getClient return instance api client, create it if it does not exists.
+// The client creates once by first caller, other callers wait its result
+func (m *poolMember) getClient(pool *APIPool) (client *APIClient, err error) {
+	m.Lock()
+	if m.client != nil {
+		client = m.client
+		m.Unlock()
+		return
+	}
+	if c := m.creating; c != nil {
+		m.Unlock()
+		<-c.done
+		return c.client, c.err
+	}
+	c := &poolCreate{done: make(chan struct{})}
+	m.creating = c
+	m.Unlock()
+
+	// Api client requests peer api, so it creates without lock
+	c.client, c.err = pool.teo.NewAPIClient(m.address, pool.clientAttr...)
+	if c.err != nil {
+		log.Debugv.Println("pool can't get api of", m.address, "err:", c.err)
+	}
+
+	m.Lock()
+	m.creating = nil
+	switch {
+	case c.err != nil:
+	case m.removed:
+		c.client.Close()
+	default:
+		m.client = c.client
+	}
+	m.Unlock()
+	close(c.done)
+	return c.client, c.err
+}
+
+// available check instance circuit allows request
+func (m *poolMember) available() bool {
+	m.Lock()
+	defer m.Unlock()
+	return m.openTill.IsZero() || time.Now().After(m.openTill) && !m.trial
+}
+
+// take check instance circuit allows request and mark trial request if
+// circuit is half open
+func (m *poolMember) take() bool {
+	m.Lock()
+	defer m.Unlock()
+	switch {
+	case m.openTill.IsZero():
+		return true
+	case time.Now().Before(m.openTill), m.trial:
+		return false
+	}
+	m.trial = true
+	return true
+}
+
+// instanceFailure check error is instance failure: instance is not
+// available, does not answer or can't execute command now. Other api errors
+// are command errors
+func instanceFailure(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.Code {
+		case APIErrInternal, APIErrTimeout, APIErrBusy:
+			return true
+		}
+		return false
+	}
+	return !errors.Is(err, context.Canceled)
+}
+
+// hashWeight return rendezvous hash weight of key and address
+func hashWeight(key, address string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	h.Write([]byte{0})
+	h.Write([]byte(address))
+	return h.Sum64()
+}
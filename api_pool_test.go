@@ -0,0 +1,215 @@
+// Test of api pool
+package teonet
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestAPIPool(t *testing.T) {
+	cli, srv, addr := newLocalPeers(t)
+
+	// Service instances, the first instance fails and answers slowly
+	servers := []*Teonet{srv}
+	addresses := []string{addr}
+	for i := 0; i < 2; i++ {
+		s, err := New("test-server", OsConfigDir(t.TempDir()), DirectConnect(true))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(s.Close)
+		a, err := cli.ConnectDirect(fmt.Sprintf("127.0.0.1:%d", s.Port()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		servers = append(servers, s)
+		addresses = append(addresses, a)
+	}
+	for i, s := range servers {
+		s, first := s, i == 0
+		api := s.NewAPI("pool", "pooltest", "pool test api", "0.0.1")
+		api.Add(
+			MakeAPI2().SetName("who").SetCmd(129).
+				SetReader(func(c *Channel, p *Packet, data []byte) bool {
+					c.Reply(p, []byte(s.Address()))
+					return true
+				}),
+			MakeAPI2().SetName("read").SetCmd(130).
+				SetReader(func(c *Channel, p *Packet, data []byte) bool {
+					if first {
+						c.SendError(p, ErrAPIInternal)
+						return true
+					}
+					c.Reply(p, []byte(s.Address()))
+					return true
+				}),
+			MakeAPI2().SetName("write").SetCmd(131).
+				SetReader(func(c *Channel, p *Packet, data []byte) bool {
+					if first {
+						c.SendError(p, ErrAPIInternal)
+						return true
+					}
+					c.Reply(p, []byte(s.Address()))
+					return true
+				}),
+			MakeAPI2().SetName("slow").SetCmd(132).
+				SetReader(func(c *Channel, p *Packet, data []byte) bool {
+					go func() {
+						if first {
+							time.Sleep(500 * time.Millisecond)
+						}
+						c.Reply(p, []byte(s.Address()))
+					}()
+					return true
+				}),
+		)
+		s.AddReader(api.Reader())
+	}
+
+	request := func(pool *APIPool, key, command string) (string, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		reply, err := pool.RequestKey(ctx, key, command, nil)
+		return string(reply), err
+	}
+
+	t.Run("RoundRobin", func(t *testing.T) {
+		pool := cli.NewAPIPool(addresses)
+		defer pool.Close()
+		got := make(map[string]int)
+		for i := 0; i < 6; i++ {
+			reply, err := request(pool, "", "who")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got[reply]++
+		}
+		for _, a := range addresses {
+			if got[a] != 2 {
+				t.Errorf("wrong requests distribution: %v", got)
+			}
+		}
+	})
+
+	t.Run("ConsistentHash", func(t *testing.T) {
+		pool := cli.NewAPIPool(addresses, ConsistentHash)
+		defer pool.Close()
+		for _, key := range []string{"user-1", "user-2", "user-3"} {
+			first, err := request(pool, key, "who")
+			for i := 0; i < 3; i++ {
+				if reply, _ := request(pool, key, "who"); err != nil || reply != first {
+					t.Errorf("key %s moved from %s to %s, err: %v", key, first,
+						reply, err)
+				}
+			}
+		}
+	})
+
+	t.Run("LeastLatency", func(t *testing.T) {
+		pool := cli.NewAPIPool(addresses, LeastLatency)
+		defer pool.Close()
+		if _, err := request(pool, "", "who"); err != nil {
+			t.Error(err)
+		}
+	})
+
+	// Idempotent commands retried on other instance, other commands does not
+	t.Run("Retry", func(t *testing.T) {
+		pool := cli.NewAPIPool(addresses, Idempotent{"read"})
+		defer pool.Close()
+		if reply, err := request(pool, "", "read"); err != nil ||
+			reply == addresses[0] {
+			t.Errorf("wrong retried reply: %s, err: %v", reply, err)
+		}
+		pool.next = 0
+		if _, err := request(pool, "", "write"); !isAPIError(err, APIErrInternal) {
+			t.Errorf("not idempotent command retried, err: %v", err)
+		}
+	})
+
+	// Not connected instance skipped and its circuit opened
+	t.Run("CircuitBreaker", func(t *testing.T) {
+		pool := cli.NewAPIPool(append([]string{"not-connected-address"},
+			addresses[1:]...), CircuitBreaker{1, time.Minute})
+		defer pool.Close()
+		for i := 0; i < 3; i++ {
+			if _, err := request(pool, "", "write"); err != nil {
+				t.Error(err)
+			}
+		}
+		instances := pool.Instances()
+		if !instances[0].Open || instances[1].Open || instances[2].Open {
+			t.Errorf("wrong instances state: %+v", instances)
+		}
+	})
+
+	t.Run("Hedge", func(t *testing.T) {
+		pool := cli.NewAPIPool(addresses, Idempotent{"slow"},
+			PoolHedge(50*time.Millisecond))
+		defer pool.Close()
+		pool.next = 0
+		start := time.Now()
+		reply, err := request(pool, "", "slow")
+		if err != nil || reply == addresses[0] ||
+			time.Since(start) > 400*time.Millisecond {
+			t.Errorf("wrong hedged reply: %s, err: %v, time: %v", reply, err,
+				time.Since(start))
+		}
+	})
+
+	// Api client of slow instance creates once, pool does not blocked while
+	// it creates
+	t.Run("SlowInstance", func(t *testing.T) {
+		s, err := New("test-server", OsConfigDir(t.TempDir()), DirectConnect(true))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		s.AddReader(func(c *Channel, p *Packet, e *Event) bool {
+			if e.Event == EventData && len(p.Data()) > 0 &&
+				p.Data()[0] == CmdServerAPI {
+				time.Sleep(200 * time.Millisecond)
+			}
+			return false
+		})
+		s.AddReader(s.NewAPI("slow", "slow", "slow api", "0.0.1").Reader())
+		a, err := cli.ConnectDirect(fmt.Sprintf("127.0.0.1:%d", s.Port()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pool := cli.NewAPIPool([]string{a})
+		defer pool.Close()
+		clients := make(chan *APIClient, 5)
+		for i := 0; i < cap(clients); i++ {
+			go func() {
+				client, _ := pool.members[0].getClient(pool)
+				clients <- client
+			}()
+		}
+		start := time.Now()
+		if instances := pool.Instances(); instances[0].Connected ||
+			time.Since(start) > 100*time.Millisecond {
+			t.Errorf("instances blocked by api client creating: %v",
+				time.Since(start))
+		}
+		first := <-clients
+		for i := 1; i < cap(clients); i++ {
+			if client := <-clients; client == nil || client != first {
+				t.Error("api client created more than once")
+			}
+		}
+		if !pool.Instances()[0].Connected {
+			t.Error("api client does not saved")
+		}
+	})
+
+	t.Run("NoInstances", func(t *testing.T) {
+		pool := cli.NewAPIPool(nil)
+		if _, err := request(pool, "", "who"); err != ErrNoInstances {
+			t.Errorf("wrong error: %v", err)
+		}
+	})
+}
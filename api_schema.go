@@ -0,0 +1,606 @@
+// Copyright 2023 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet api schemas module: machine-readable commands parameters and return
+// values description. Schemas sends in APIDataAr extensions block, so old
+// clients does not see them
+
+package teonet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+
+	"github.com/kirill-scherba/bslice"
+)
+
+// SchemaType is type of schema field value
+type SchemaType byte
+
+// Schema field types. Values of this types in go are: string, []byte, bool,
+// int64, uint64, float64, []interface{} and map[string]interface{}
+const (
+	SchemaString SchemaType = iota + 1
+	SchemaBytes
+	SchemaBool
+	SchemaInt
+	SchemaUint
+	SchemaFloat
+	SchemaList
+	SchemaStruct
+)
+
+// SchemaEncoding is encoding of command parameters or return data
+type SchemaEncoding byte
+
+const (
+	// EncodingRaw - data is one string or bytes field value as is
+	EncodingRaw SchemaEncoding = iota
+
+	// EncodingJSON - data is JSON object with schema fields
+	EncodingJSON
+
+	// EncodingBinary - data is schema fields values in binary form: strings
+	// and bytes with uint16 length, numbers in 8 bytes little endian, bool in
+	// one byte, lists with uint16 number of elements, structs fields in order
+	EncodingBinary
+)
+
+// Schema describes command parameters or return data
+type Schema struct {
+	Encoding SchemaEncoding
+	Fields   []SchemaField
+}
+
+// SchemaField describes one field of schema
+type SchemaField struct {
+	Name     string
+	Type     SchemaType
+	Optional bool          // Field may be omitted (JSON encoding only)
+	Elem     *SchemaField  // List element
+	Fields   []SchemaField // Struct fields
+}
+
+// String return schema type name
+func (t SchemaType) String() string {
+	switch t {
+	case SchemaString:
+		return "string"
+	case SchemaBytes:
+		return "bytes"
+	case SchemaBool:
+		return "bool"
+	case SchemaInt:
+		return "int"
+	case SchemaUint:
+		return "uint"
+	case SchemaFloat:
+		return "float"
+	case SchemaList:
+		return "list"
+	case SchemaStruct:
+		return "struct"
+	}
+	return "unknown"
+}
+
+// String return schema encoding name
+func (e SchemaEncoding) String() string {
+	switch e {
+	case EncodingRaw:
+		return "raw"
+	case EncodingJSON:
+		return "json"
+	case EncodingBinary:
+		return "binary"
+	}
+	return "unknown"
+}
+
+// String return field description in '<name type>' format
+func (f SchemaField) String() string {
+	return "<" + f.Name + " " + f.typeString() + ">"
+}
+
+// typeString return field type with list elements and struct fields types
+func (f SchemaField) typeString() (str string) {
+	switch f.Type {
+	case SchemaList:
+		if f.Elem != nil {
+			return "[]" + f.Elem.typeString()
+		}
+	case SchemaStruct:
+		str = "{"
+		for i := range f.Fields {
+			if i > 0 {
+				str += ", "
+			}
+			str += f.Fields[i].Name + " " + f.Fields[i].typeString()
+		}
+		return str + "}"
+	}
+	str = f.Type.String()
+	if f.Optional {
+		str += "?"
+	}
+	return
+}
+
+// String return schema description
+func (s Schema) String() (str string) {
+	for i := range s.Fields {
+		if i > 0 {
+			str += " "
+		}
+		str += s.Fields[i].String()
+	}
+	return
+}
+
+// Check schema definition
+func (s Schema) Check() error {
+	if s.Encoding == EncodingRaw {
+		if len(s.Fields) != 1 || (s.Fields[0].Type != SchemaString &&
+			s.Fields[0].Type != SchemaBytes) {
+			return errors.New("raw schema should contain one string or bytes field")
+		}
+	}
+	return checkFields(s.Fields)
+}
+
+// checkFields check fields definition
+func checkFields(fields []SchemaField) (err error) {
+	for _, f := range fields {
+		switch {
+		case f.Type < SchemaString || f.Type > SchemaStruct:
+			err = fmt.Errorf("wrong type of schema field '%s'", f.Name)
+		case f.Type == SchemaList:
+			if f.Elem == nil {
+				err = fmt.Errorf("list schema field '%s' has no element", f.Name)
+				break
+			}
+			err = checkFields([]SchemaField{*f.Elem})
+		case f.Type == SchemaStruct:
+			err = checkFields(f.Fields)
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Encode validate values and encode it to command data. The values may be
+// set in go types or in strings (f.e. received from command line), lists
+// and structs in strings should be in JSON
+func (s Schema) Encode(values map[string]interface{}) (data []byte, err error) {
+	if err = s.Check(); err != nil {
+		return
+	}
+
+	// Check and convert values
+	for name := range values {
+		if _, ok := s.field(name); !ok {
+			err = schemaError("unknown field '%s'", name)
+			return
+		}
+	}
+	vals := make(map[string]interface{})
+	for _, f := range s.Fields {
+		v, ok := values[f.Name]
+		if !ok {
+			if f.Optional && s.Encoding == EncodingJSON {
+				continue
+			}
+			err = schemaError("field '%s' is required", f.Name)
+			return
+		}
+		if vals[f.Name], err = f.convert(v); err != nil {
+			return
+		}
+	}
+
+	// Encode values
+	switch s.Encoding {
+	case EncodingRaw:
+		switch v := vals[s.Fields[0].Name].(type) {
+		case string:
+			data = []byte(v)
+		case []byte:
+			data = v
+		}
+	case EncodingJSON:
+		data, err = json.Marshal(vals)
+	case EncodingBinary:
+		buf := new(bytes.Buffer)
+		for _, f := range s.Fields {
+			if err = f.write(buf, vals[f.Name]); err != nil {
+				return
+			}
+		}
+		data = buf.Bytes()
+	}
+	return
+}
+
+// Decode command data to values map
+func (s Schema) Decode(data []byte) (values map[string]interface{}, err error) {
+	if err = s.Check(); err != nil {
+		return
+	}
+	values = make(map[string]interface{})
+	switch s.Encoding {
+	case EncodingRaw:
+		f := s.Fields[0]
+		if f.Type == SchemaString {
+			values[f.Name] = string(data)
+		} else {
+			values[f.Name] = data
+		}
+
+	case EncodingJSON:
+		var m map[string]interface{}
+		if err = json.Unmarshal(data, &m); err != nil {
+			err = schemaError("wrong JSON: %s", err)
+			return
+		}
+		for _, f := range s.Fields {
+			v, ok := m[f.Name]
+			if !ok {
+				if !f.Optional {
+					err = schemaError("field '%s' is required", f.Name)
+					return
+				}
+				continue
+			}
+			if values[f.Name], err = f.convert(v); err != nil {
+				return
+			}
+		}
+
+	case EncodingBinary:
+		buf := bytes.NewBuffer(data)
+		for _, f := range s.Fields {
+			if values[f.Name], err = f.read(buf); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// field get field by name
+func (s Schema) field(name string) (f SchemaField, ok bool) {
+	for i := range s.Fields {
+		if s.Fields[i].Name == name {
+			return s.Fields[i], true
+		}
+	}
+	return
+}
+
+// schemaError create bad argument error
+func schemaError(format string, a ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrAPIBadArgument, fmt.Sprintf(format, a...))
+}
+
+// convert check value type and convert it to field go type
+func (f SchemaField) convert(v interface{}) (ret interface{}, err error) {
+	wrong := func() error {
+		return schemaError("wrong value %v of field '%s', type %s expected", v,
+			f.Name, f.typeString())
+	}
+	s, isString := v.(string)
+	rv := reflect.ValueOf(v)
+
+	switch f.Type {
+	case SchemaString:
+		switch v := v.(type) {
+		case string:
+			ret = v
+		case []byte:
+			ret = string(v)
+		default:
+			err = wrong()
+		}
+
+	case SchemaBytes:
+		switch v := v.(type) {
+		case []byte:
+			ret = v
+		case string:
+			ret = []byte(v)
+		default:
+			err = wrong()
+		}
+
+	case SchemaBool:
+		switch v := v.(type) {
+		case bool:
+			ret = v
+		case string:
+			if ret, err = strconv.ParseBool(v); err != nil {
+				err = wrong()
+			}
+		default:
+			err = wrong()
+		}
+
+	case SchemaInt, SchemaUint, SchemaFloat:
+		var n float64
+		switch {
+		case isString:
+			if n, err = strconv.ParseFloat(s, 64); err != nil {
+				return nil, wrong()
+			}
+		case rv.CanInt():
+			n = float64(rv.Int())
+		case rv.CanUint():
+			n = float64(rv.Uint())
+		case rv.CanFloat():
+			n = rv.Float()
+		default:
+			return nil, wrong()
+		}
+		switch f.Type {
+		case SchemaInt:
+			if n != math.Trunc(n) {
+				return nil, wrong()
+			}
+			if isString {
+				// Parse again to keep precision of large numbers
+				var i int64
+				if i, err = strconv.ParseInt(s, 10, 64); err != nil {
+					return nil, wrong()
+				}
+				return i, nil
+			}
+			if rv.CanInt() {
+				return rv.Int(), nil
+			}
+			ret = int64(n)
+		case SchemaUint:
+			if n != math.Trunc(n) || n < 0 {
+				return nil, wrong()
+			}
+			if isString {
+				var u uint64
+				if u, err = strconv.ParseUint(s, 10, 64); err != nil {
+					return nil, wrong()
+				}
+				return u, nil
+			}
+			if rv.CanUint() {
+				return rv.Uint(), nil
+			}
+			ret = uint64(n)
+		default:
+			ret = n
+		}
+
+	case SchemaList:
+		if isString {
+			var l []interface{}
+			if json.Unmarshal([]byte(s), &l) != nil {
+				return nil, wrong()
+			}
+			rv = reflect.ValueOf(l)
+		}
+		if rv.Kind() != reflect.Slice || f.Elem == nil {
+			return nil, wrong()
+		}
+		l := make([]interface{}, rv.Len())
+		for i := range l {
+			if l[i], err = f.Elem.convert(rv.Index(i).Interface()); err != nil {
+				return
+			}
+		}
+		ret = l
+
+	case SchemaStruct:
+		m, ok := v.(map[string]interface{})
+		if isString {
+			ok = json.Unmarshal([]byte(s), &m) == nil
+		}
+		if !ok {
+			return nil, wrong()
+		}
+		st := make(map[string]interface{})
+		for _, sf := range f.Fields {
+			fv, ok := m[sf.Name]
+			if !ok {
+				if sf.Optional {
+					continue
+				}
+				return nil, schemaError("field '%s.%s' is required", f.Name,
+					sf.Name)
+			}
+			if st[sf.Name], err = sf.convert(fv); err != nil {
+				return
+			}
+		}
+		ret = st
+
+	default:
+		err = wrong()
+	}
+	return
+}
+
+// write converted value in binary encoding
+func (f SchemaField) write(buf *bytes.Buffer, v interface{}) (err error) {
+	var bs bslice.ByteSlice
+	switch f.Type {
+	case SchemaString:
+		err = bs.WriteSlice(buf, []byte(v.(string)))
+	case SchemaBytes:
+		err = bs.WriteSlice(buf, v.([]byte))
+	case SchemaList:
+		l := v.([]interface{})
+		binary.Write(buf, binary.LittleEndian, uint16(len(l)))
+		for i := range l {
+			if err = f.Elem.write(buf, l[i]); err != nil {
+				return
+			}
+		}
+	case SchemaStruct:
+		m := v.(map[string]interface{})
+		for _, sf := range f.Fields {
+			fv, ok := m[sf.Name]
+			if !ok {
+				return schemaError("field '%s.%s' is required", f.Name, sf.Name)
+			}
+			if err = sf.write(buf, fv); err != nil {
+				return
+			}
+		}
+	default:
+		// bool, int64, uint64 and float64
+		err = binary.Write(buf, binary.LittleEndian, v)
+	}
+	return
+}
+
+// read value in binary encoding
+func (f SchemaField) read(buf *bytes.Buffer) (v interface{}, err error) {
+	var bs bslice.ByteSlice
+	fail := func(err error) (interface{}, error) {
+		return nil, schemaError("can't read field '%s': %s", f.Name, err)
+	}
+	switch f.Type {
+	case SchemaString:
+		v, err = bs.ReadString(buf)
+	case SchemaBytes:
+		v, err = bs.ReadSlice(buf)
+	case SchemaBool:
+		var b bool
+		err = binary.Read(buf, binary.LittleEndian, &b)
+		v = b
+	case SchemaInt:
+		var i int64
+		err = binary.Read(buf, binary.LittleEndian, &i)
+		v = i
+	case SchemaUint:
+		var u uint64
+		err = binary.Read(buf, binary.LittleEndian, &u)
+		v = u
+	case SchemaFloat:
+		var n float64
+		err = binary.Read(buf, binary.LittleEndian, &n)
+		v = n
+	case SchemaList:
+		var n uint16
+		if err = binary.Read(buf, binary.LittleEndian, &n); err != nil {
+			return fail(err)
+		}
+		l := make([]interface{}, n)
+		for i := range l {
+			if l[i], err = f.Elem.read(buf); err != nil {
+				return
+			}
+		}
+		v = l
+	case SchemaStruct:
+		m := make(map[string]interface{})
+		for _, sf := range f.Fields {
+			if m[sf.Name], err = sf.read(buf); err != nil {
+				return
+			}
+		}
+		v = m
+	default:
+		err = errors.New("unknown type")
+	}
+	if err != nil {
+		return fail(err)
+	}
+	return
+}
+
+// MarshalBinary binary marshal schema
+func (s Schema) MarshalBinary() (data []byte, err error) {
+	buf := new(bytes.Buffer)
+	buf.WriteByte(byte(s.Encoding))
+	writeSchemaFields(buf, s.Fields)
+	data = buf.Bytes()
+	return
+}
+
+// UnmarshalBinary binary unmarshal schema
+func (s *Schema) UnmarshalBinary(data []byte) (err error) {
+	buf := bytes.NewBuffer(data)
+	enc, err := buf.ReadByte()
+	if err != nil {
+		return
+	}
+	s.Encoding = SchemaEncoding(enc)
+	s.Fields, err = readSchemaFields(buf)
+	return
+}
+
+// writeSchemaFields write schema fields definition
+func writeSchemaFields(buf *bytes.Buffer, fields []SchemaField) {
+	var bs bslice.ByteSlice
+	binary.Write(buf, binary.LittleEndian, uint16(len(fields)))
+	for _, f := range fields {
+		bs.WriteSlice(buf, []byte(f.Name))
+		buf.WriteByte(byte(f.Type))
+		binary.Write(buf, binary.LittleEndian, f.Optional)
+		switch {
+		case f.Type == SchemaList && f.Elem == nil:
+			writeSchemaFields(buf, nil)
+		case f.Type == SchemaList:
+			writeSchemaFields(buf, []SchemaField{*f.Elem})
+		case f.Type == SchemaStruct:
+			writeSchemaFields(buf, f.Fields)
+		}
+	}
+}
+
+// readSchemaFields read schema fields definition
+func readSchemaFields(buf *bytes.Buffer) (fields []SchemaField, err error) {
+	var bs bslice.ByteSlice
+	var num uint16
+	if err = binary.Read(buf, binary.LittleEndian, &num); err != nil {
+		return
+	}
+	for i := 0; i < int(num); i++ {
+		var f SchemaField
+		if f.Name, err = bs.ReadString(buf); err != nil {
+			return
+		}
+		var typ byte
+		if typ, err = buf.ReadByte(); err != nil {
+			return
+		}
+		f.Type = SchemaType(typ)
+		if err = binary.Read(buf, binary.LittleEndian, &f.Optional); err != nil {
+			return
+		}
+		switch f.Type {
+		case SchemaList:
+			var elem []SchemaField
+			if elem, err = readSchemaFields(buf); err != nil {
+				return
+			}
+			if len(elem) != 1 {
+				err = errors.New("wrong list element schema")
+				return
+			}
+			f.Elem = &elem[0]
+		case SchemaStruct:
+			if f.Fields, err = readSchemaFields(buf); err != nil {
+				return
+			}
+		}
+		fields = append(fields, f)
+	}
+	return
+}
@@ -0,0 +1,138 @@
+// Test of api parameters and return data schemas
+package teonet
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestSchema(t *testing.T) {
+
+	params := Schema{Encoding: EncodingBinary, Fields: []SchemaField{
+		{Name: "name", Type: SchemaString},
+		{Name: "count", Type: SchemaInt},
+		{Name: "tags", Type: SchemaList, Elem: &SchemaField{Type: SchemaString}},
+		{Name: "point", Type: SchemaStruct, Fields: []SchemaField{
+			{Name: "x", Type: SchemaFloat},
+			{Name: "ok", Type: SchemaBool},
+		}},
+	}}
+	if err := params.Check(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	t.Run("Binary", func(t *testing.T) {
+		data, err := params.Encode(map[string]interface{}{
+			"name":  "test",
+			"count": "12", // Strings converts to field type
+			"tags":  []string{"a", "b"},
+			"point": `{"x": 1.5, "ok": true}`,
+		})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		values, err := params.Decode(data)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		want := map[string]interface{}{
+			"name":  "test",
+			"count": int64(12),
+			"tags":  []interface{}{"a", "b"},
+			"point": map[string]interface{}{"x": 1.5, "ok": true},
+		}
+		if !reflect.DeepEqual(values, want) {
+			t.Errorf("wrong decoded values: %v", values)
+		}
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		s := Schema{Encoding: EncodingJSON, Fields: []SchemaField{
+			{Name: "id", Type: SchemaUint},
+			{Name: "comment", Type: SchemaString, Optional: true},
+		}}
+		data, err := s.Encode(map[string]interface{}{"id": 7})
+		if err != nil || string(data) != `{"id":7}` {
+			t.Errorf("wrong encoded data: %s %v", data, err)
+		}
+		values, err := s.Decode(data)
+		if err != nil || values["id"] != uint64(7) {
+			t.Errorf("wrong decoded values: %v %v", values, err)
+		}
+	})
+
+	t.Run("Validate", func(t *testing.T) {
+		for _, args := range []map[string]interface{}{
+			{"name": "test"}, // Required fields omitted
+			{"name": "test", "count": 1.5, "tags": []string{}, "point": map[string]interface{}{"x": 1, "ok": true}},
+			{"name": "test", "count": 1, "tags": []string{}, "point": map[string]interface{}{"x": 1, "ok": true}, "unknown": 1},
+		} {
+			if _, err := params.Encode(args); !errors.Is(err, ErrAPIBadArgument) {
+				t.Errorf("wrong validation error: %v, args: %v", err, args)
+			}
+		}
+	})
+
+	// Wrong schemas does not set to commands and does not encode data
+	t.Run("WrongSchema", func(t *testing.T) {
+		for _, s := range []Schema{
+			{},
+			{Fields: []SchemaField{{Name: "a", Type: SchemaInt}}},
+			{Encoding: EncodingJSON, Fields: []SchemaField{{Name: "l", Type: SchemaList}}},
+			{Encoding: EncodingBinary, Fields: []SchemaField{{Name: "s",
+				Type: SchemaStruct, Fields: []SchemaField{{Name: "l", Type: SchemaList}}}}},
+		} {
+			if _, err := s.Encode(map[string]interface{}{"a": 1}); err == nil {
+				t.Errorf("wrong schema encoded: %v", s)
+			}
+			if _, err := s.Decode([]byte{0, 0}); err == nil {
+				t.Errorf("wrong schema decoded: %v", s)
+			}
+			if _, err := s.MarshalBinary(); err != nil {
+				t.Errorf("wrong schema does not marshalled: %v", err)
+			}
+			for _, set := range []func(*APIData, Schema) *APIData{
+				(*APIData).SetParams, (*APIData).SetReturns,
+			} {
+				func() {
+					defer func() {
+						if recover() == nil {
+							t.Errorf("wrong schema set: %v", s)
+						}
+					}()
+					set(MakeAPI2().SetName("cmd"), s)
+				}()
+			}
+		}
+	})
+
+	t.Run("APIDataAr", func(t *testing.T) {
+		api := new(Teonet).NewAPI("test", "test", "test api", "0.0.1")
+		api.Add(MakeAPI2().SetName("cmd").SetCmd(129).SetParams(params).
+			SetReturns(Schema{Fields: []SchemaField{{Name: "out", Type: SchemaString}}}))
+		data, _ := api.MarshalBinary()
+		var ar APIDataAr
+		if err := ar.UnmarshalBinary(data); err != nil {
+			t.Error(err)
+			return
+		}
+		apicli := &APIClient{ar: &ar, cmdAPI: CmdServerAPI,
+			m: new(sync.RWMutex)}
+		s, ok := apicli.Params("cmd")
+		if !ok || !reflect.DeepEqual(*s, params) {
+			t.Errorf("wrong received params schema: %v", s)
+		}
+		values, err := apicli.DecodeReturn("cmd", []byte("hello"))
+		if err != nil || values["out"] != "hello" {
+			t.Errorf("wrong decoded return: %v %v", values, err)
+		}
+		if usage := ar.Apis[1].Usage(); usage != params.String() {
+			t.Errorf("wrong usage: %s", usage)
+		}
+	})
+}
@@ -0,0 +1,236 @@
+// Copyright 2023 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet api typed commands module: generic command handlers and callers
+// which decode requests and encode answers by codec, so commands does not
+// parse and marshal raw data:
+//
+//	teonet.Handle(api, teonet.MakeAPI2().SetName("user").SetCmd(129),
+//		func(ctx context.Context, c *teonet.Channel, req UserRequest) (User, error) {
+//			return db.User(req.ID)
+//		})
+//
+//	user, err := teonet.Call[UserRequest, User](ctx, apiClient, "user",
+//		UserRequest{ID: 10})
+
+package teonet
+
+import (
+	"bytes"
+	"context"
+	"encoding"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// Codec encodes and decodes typed commands requests and answers
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// Typed commands codecs
+var (
+	// DefaultCodec - BinaryCodec for types which it supports, JSONCodec for
+	// other types
+	DefaultCodec Codec = defaultCodec{}
+
+	// BinaryCodec - types implemented encoding.BinaryMarshaler and
+	// encoding.BinaryUnmarshaler, []byte and string as is
+	BinaryCodec Codec = binaryCodec{}
+
+	// JSONCodec - JSON encoding
+	JSONCodec Codec = jsonCodec{}
+
+	// GobCodec - gob encoding
+	GobCodec Codec = gobCodec{}
+)
+
+// Handle add command to api. The command reader decodes request to Req
+// type, executes handler and answers encoded Resp or handler error. Request
+// decode errors answer with ErrAPIBadArgument code. The attr parameters:
+//
+//	Codec   requests and answers codec, DefaultCodec by default
+//
+// The handler context canceled when peer disconnected or command timed out
+// by TimeoutMiddleware. The handler channel parameter is nil when command
+// executed by Reader2
+func Handle[Req, Resp any](api *API, cmd *APIData,
+	handler func(ctx context.Context, c *Channel, req Req) (Resp, error),
+	attr ...interface{}) {
+
+	codec := typedCodec(attr)
+	exec := func(ctx context.Context, c *Channel, data []byte) (answer []byte,
+		err error) {
+
+		req, err := typedDecode[Req](codec, data)
+		if err != nil {
+			err = NewAPIError(APIErrBadArgument, err.Error())
+			return
+		}
+		resp, err := handler(ctx, c, req)
+		if err != nil {
+			return
+		}
+		if answer, err = codec.Marshal(resp); err != nil {
+			err = NewAPIError(APIErrInternal, err.Error())
+		}
+		return
+	}
+
+	cmd.handler = func(call *APICall) bool {
+		ctx := call.Ctx
+		if ctx == nil {
+			ctx = call.Channel.context()
+		}
+		answer, err := exec(ctx, call.Channel, call.Data)
+		switch {
+		case ctx.Err() != nil:
+			// Call timed out or peer disconnected, TimeoutMiddleware answers
+			// timeout error
+			call.Err = ctx.Err()
+		case err != nil && call.Channel == nil:
+			log.Debugv.Println("api command", cmd.Name(), "error:", err)
+			call.Err = err
+			api.SendError2(cmd, err, call.Answer)
+		case err != nil:
+			call.SendError(err)
+		case call.Channel == nil:
+			api.SendAnswer2(answer, call.Answer)
+		default:
+			api.SendAnswer(cmd, call.Channel, answer, call.Packet)
+		}
+		return true
+	}
+	cmd.SetReader(func(c *Channel, p *Packet, data []byte) bool {
+		return cmd.handler(&APICall{Channel: c, Packet: p, Cmd: cmd, Data: data})
+	}).SetReader2(func(data []byte, answer func(data []byte)) bool {
+		return cmd.handler(&APICall{Cmd: cmd, Data: data, Answer: answer})
+	})
+	api.Add(cmd)
+}
+
+// Call execute api command with typed request and answer. The request
+// encodes and answer decodes by codec. Command errors returns as *APIError.
+// The attr parameters:
+//
+//	Codec   requests and answers codec, DefaultCodec by default
+func Call[Req, Resp any](ctx context.Context, api *APIClient,
+	command interface{}, req Req, attr ...interface{}) (resp Resp, err error) {
+
+	codec := typedCodec(attr)
+	data, err := codec.Marshal(req)
+	if err != nil {
+		return
+	}
+	data, err = api.Request(ctx, command, data)
+	if err != nil {
+		return
+	}
+	return typedDecode[Resp](codec, data)
+}
+
+// typedCodec get codec from attr parameters
+func typedCodec(attr []interface{}) Codec {
+	for i := range attr {
+		if codec, ok := attr[i].(Codec); ok {
+			return codec
+		}
+	}
+	return DefaultCodec
+}
+
+// typedDecode decode data to T type value. Pointer types values allocated
+func typedDecode[T any](codec Codec, data []byte) (v T, err error) {
+	rv := reflect.ValueOf(&v).Elem()
+	if rv.Kind() == reflect.Pointer {
+		rv.Set(reflect.New(rv.Type().Elem()))
+		err = codec.Unmarshal(data, rv.Interface())
+		return
+	}
+	err = codec.Unmarshal(data, &v)
+	return
+}
+
+// defaultCodec is BinaryCodec for supported types and JSONCodec for others
+type defaultCodec struct{}
+
+func (defaultCodec) Marshal(v interface{}) ([]byte, error) {
+	if binarySupported(v) {
+		return BinaryCodec.Marshal(v)
+	}
+	return JSONCodec.Marshal(v)
+}
+
+func (defaultCodec) Unmarshal(data []byte, v interface{}) error {
+	if binarySupported(v) {
+		return BinaryCodec.Unmarshal(data, v)
+	}
+	return JSONCodec.Unmarshal(data, v)
+}
+
+// binarySupported check BinaryCodec supports value type
+func binarySupported(v interface{}) bool {
+	switch v.(type) {
+	case encoding.BinaryMarshaler, encoding.BinaryUnmarshaler,
+		[]byte, *[]byte, string, *string:
+		return true
+	}
+	return false
+}
+
+// binaryCodec is encoding.BinaryMarshaler codec
+type binaryCodec struct{}
+
+func (binaryCodec) Marshal(v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case encoding.BinaryMarshaler:
+		return v.MarshalBinary()
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	}
+	return nil, fmt.Errorf("binary codec does not support type %T", v)
+}
+
+func (binaryCodec) Unmarshal(data []byte, v interface{}) error {
+	switch v := v.(type) {
+	case encoding.BinaryUnmarshaler:
+		return v.UnmarshalBinary(data)
+	case *[]byte:
+		*v = append([]byte{}, data...)
+		return nil
+	case *string:
+		*v = string(data)
+		return nil
+	}
+	return fmt.Errorf("binary codec does not support type %T", v)
+}
+
+// jsonCodec is JSON codec
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// gobCodec is gob codec
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	err := gob.NewEncoder(buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
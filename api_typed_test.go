@@ -0,0 +1,138 @@
+// Test of api typed commands
+package teonet
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type typedUser struct {
+	ID   int
+	Name string
+}
+
+// typedName is binary marshalled name
+type typedName struct{ name string }
+
+func (n typedName) MarshalBinary() ([]byte, error) {
+	return []byte(strings.ToUpper(n.name)), nil
+}
+
+func (n *typedName) UnmarshalBinary(data []byte) error {
+	n.name = strings.ToLower(string(data))
+	return nil
+}
+
+func TestAPITyped(t *testing.T) {
+	cli, srv, addr := newLocalPeers(t)
+
+	getUser := func(ctx context.Context, c *Channel, id int) (u typedUser, err error) {
+		switch {
+		case id == 0:
+			err = ErrAPINotAuthorized
+		case id < 0:
+			err = errors.New("negative id")
+		default:
+			u = typedUser{id, "user" + strings.Repeat("!", id)}
+		}
+		return
+	}
+	api := srv.NewAPI("test", "test", "test api", "0.0.1")
+	Handle(api, MakeAPI2().SetName("user").SetCmd(129), getUser)
+	Handle(api, MakeAPI2().SetName("user-gob").SetCmd(130), getUser, GobCodec)
+	Handle(api, MakeAPI2().SetName("name").SetCmd(131),
+		func(ctx context.Context, c *Channel, n typedName) (*typedName, error) {
+			return &typedName{n.name + " from " + c.Address()}, nil
+		})
+	canceled := make(chan error, 1)
+	Handle(api, MakeAPI2().SetName("wait").SetCmd(132),
+		func(ctx context.Context, c *Channel, req []byte) ([]byte, error) {
+			<-ctx.Done()
+			canceled <- ctx.Err()
+			return nil, ctx.Err()
+		})
+	api.Use(TimeoutMiddleware(50*time.Millisecond, "wait"))
+	srv.AddReader(api.Reader())
+
+	apicli, err := cli.NewAPIClient(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer apicli.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	t.Run("JSON", func(t *testing.T) {
+		u, err := Call[int, typedUser](ctx, apicli, "user", 2)
+		if err != nil || u != (typedUser{2, "user!!"}) {
+			t.Errorf("wrong answer: %v, err: %v", u, err)
+		}
+	})
+
+	t.Run("Gob", func(t *testing.T) {
+		u, err := Call[int, *typedUser](ctx, apicli, "user-gob", 1, GobCodec)
+		if err != nil || *u != (typedUser{1, "user!"}) {
+			t.Errorf("wrong answer: %v, err: %v", u, err)
+		}
+	})
+
+	t.Run("Binary", func(t *testing.T) {
+		n, err := Call[typedName, typedName](ctx, apicli, "name",
+			typedName{"John"})
+		if err != nil || n.name != "john from "+strings.ToLower(cli.Address()) {
+			t.Errorf("wrong answer: %v, err: %v", n, err)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		if _, err := Call[int, typedUser](ctx, apicli, "user", 0); !errors.Is(err,
+			ErrAPINotAuthorized) {
+			t.Errorf("wrong handler api error: %v", err)
+		}
+		_, err := Call[int, typedUser](ctx, apicli, "user", -1)
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || apiErr.Code != APIErrInternal ||
+			apiErr.Message != "negative id" {
+			t.Errorf("wrong handler error: %v", err)
+		}
+		if _, err := Call[string, typedUser](ctx, apicli, "user", "{"); !errors.Is(err,
+			ErrAPIBadArgument) {
+			t.Errorf("wrong bad argument error: %v", err)
+		}
+	})
+
+	// Handler context canceled by timeout middleware
+	t.Run("Cancel", func(t *testing.T) {
+		if _, err := Call[[]byte, []byte](ctx, apicli, "wait", nil); !errors.Is(err,
+			ErrAPITimeout) {
+			t.Errorf("wrong timeout error: %v", err)
+		}
+		select {
+		case err := <-canceled:
+			if err != context.DeadlineExceeded {
+				t.Errorf("wrong context error: %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Error("handler context does not canceled")
+		}
+	})
+
+	// Handler errors answered by Reader2 answer
+	t.Run("Reader2", func(t *testing.T) {
+		var answer []byte
+		if !api.Reader2()([]byte{129, '{'}, func(data []byte) { answer = data }) {
+			t.Fatal("command does not processed")
+		}
+		if apiErr, ok := APIErrorAnswer(answer); !ok ||
+			!errors.Is(apiErr, ErrAPIBadArgument) {
+			t.Errorf("wrong error answer: %q", answer)
+		}
+		api.Reader2()([]byte{129, '3'}, func(data []byte) { answer = data })
+		if string(answer) != `{"ID":3,"Name":"user!!!"}` {
+			t.Errorf("wrong answer: %q", answer)
+		}
+	})
+}
@@ -0,0 +1,292 @@
+// Copyright 2023 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet api version module: semantic versions, version ranges and api
+// versions negotiation.
+//
+// Client sends required version range in the api command (CmdServerAPI)
+// request data. Server selects the highest API version matching the range,
+// returns this API and executes next commands of this channel by selected
+// API version. Old servers ignore request data and return its API, the
+// client checks received version itself.
+
+package teonet
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrAPIVersion returns by NewAPIClient when peer api version does not match
+// required version range
+var ErrAPIVersion = errors.New("api version does not match")
+
+// ErrWrongVersion returns when version or version range has wrong format
+var ErrWrongVersion = errors.New("wrong version format")
+
+// APIVersionRange used in NewAPIClient parameter to require peer api
+// version. Range contains space or comma separated constraints which all
+// should match, and may contain alternatives separated by '||'. Constraint
+// operators: =, >, >=, <, <=, ^ (compatible: same major version), ~ (same
+// minor version). Version without operator means exact version, '*' means
+// any version. Examples: "^1.2", ">=1.2.0 <2", "~1.4.1 || ^2"
+type APIVersionRange string
+
+// SemVer is semantic version: major.minor.patch[-prerelease][+build]
+type SemVer struct {
+	Major, Minor, Patch int
+	Pre                 string
+}
+
+// ParseSemVer parse semantic version. Leading 'v', omitted minor and patch
+// numbers and build metadata are allowed
+func ParseSemVer(s string) (v SemVer, err error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	s, _, _ = strings.Cut(s, "+")
+	s, v.Pre, _ = strings.Cut(s, "-")
+
+	nums := strings.Split(s, ".")
+	if len(nums) > 3 {
+		err = fmt.Errorf("%w: %q", ErrWrongVersion, s)
+		return
+	}
+	parts := []*int{&v.Major, &v.Minor, &v.Patch}
+	for i := range nums {
+		*parts[i], err = strconv.Atoi(nums[i])
+		if err != nil || *parts[i] < 0 {
+			err = fmt.Errorf("%w: %q", ErrWrongVersion, s)
+			return
+		}
+	}
+	return
+}
+
+// String return version string
+func (v SemVer) String() (s string) {
+	s = fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Pre != "" {
+		s += "-" + v.Pre
+	}
+	return
+}
+
+// Compare compare versions and return -1, 0 or +1. Prerelease version is
+// less than release version
+func (v SemVer) Compare(o SemVer) int {
+	for _, d := range []int{v.Major - o.Major, v.Minor - o.Minor,
+		v.Patch - o.Patch} {
+		switch {
+		case d < 0:
+			return -1
+		case d > 0:
+			return 1
+		}
+	}
+	switch {
+	case v.Pre == o.Pre:
+		return 0
+	case v.Pre == "":
+		return 1
+	case o.Pre == "":
+		return -1
+	}
+	return strings.Compare(v.Pre, o.Pre)
+}
+
+// Match check version string matches range
+func (r APIVersionRange) Match(version string) (ok bool, err error) {
+	v, err := ParseSemVer(version)
+	if err != nil {
+		return
+	}
+	for _, alt := range strings.Split(string(r), "||") {
+		ok = true
+		constraints := strings.FieldsFunc(alt, func(r rune) bool {
+			return r == ' ' || r == ','
+		})
+		for _, c := range constraints {
+			var match bool
+			if match, err = matchConstraint(v, c); err != nil {
+				return
+			}
+			ok = ok && match
+		}
+		if ok {
+			return
+		}
+	}
+	return
+}
+
+// matchConstraint check version matches one constraint
+func matchConstraint(v SemVer, c string) (ok bool, err error) {
+	if c == "*" || c == "x" {
+		return true, nil
+	}
+	i := strings.IndexAny(c, "0123456789v")
+	if i < 0 {
+		err = fmt.Errorf("%w: %q", ErrWrongVersion, c)
+		return
+	}
+	op := c[:i]
+	cv, err := ParseSemVer(c[i:])
+	if err != nil {
+		return
+	}
+	cmp := v.Compare(cv)
+	switch op {
+	case "", "=":
+		ok = cmp == 0
+	case ">":
+		ok = cmp > 0
+	case ">=":
+		ok = cmp >= 0
+	case "<":
+		ok = cmp < 0
+	case "<=":
+		ok = cmp <= 0
+	case "^":
+		next := SemVer{Major: cv.Major + 1}
+		if cv.Major == 0 {
+			next = SemVer{Minor: cv.Minor + 1}
+		}
+		ok = cmp >= 0 && v.Compare(next) < 0
+	case "~":
+		ok = cmp >= 0 && v.Compare(SemVer{Major: cv.Major, Minor: cv.Minor + 1}) < 0
+	default:
+		err = fmt.Errorf("%w: %q", ErrWrongVersion, c)
+	}
+	return
+}
+
+// apiVersions contains other versions of API and versions negotiated by
+// channels
+type apiVersions struct {
+	list     []*API          // Other API versions
+	channels map[string]*API // Negotiated API versions by channel address
+	sync.RWMutex
+}
+
+// NewVersion create other version of API commands set with the same api
+// command. Add commands to returned API. Clients select API version by
+// APIVersionRange parameter of NewAPIClient, the API created by NewAPI is
+// default version
+func (a *API) NewVersion(version string) (v *API) {
+	v = &API{
+		Teonet:  a.Teonet,
+		name:    a.name,
+		short:   a.short,
+		long:    a.long,
+		version: version,
+		access:  a.accessData(),
+		client:  a.client,
+		builtin: a.builtin,
+	}
+	if a.middleware == nil {
+		a.middleware = new(apiMiddleware)
+	}
+	v.middleware = a.middleware
+	if len(a.cmds) > 0 {
+		v.Add(a.cmds[0])
+	}
+	if a.builtin != nil {
+		v.Add(a.builtin.cmds...)
+	}
+	if a.versions == nil {
+		a.versions = &apiVersions{channels: make(map[string]*API)}
+	}
+
+	a.versions.Lock()
+	defer a.versions.Unlock()
+	a.versions.list = append(a.versions.list, v)
+	return
+}
+
+// Versions return all API versions
+func (a *API) Versions() (versions []string) {
+	versions = append(versions, a.version)
+	if a.versions == nil {
+		return
+	}
+	a.versions.RLock()
+	defer a.versions.RUnlock()
+	for _, v := range a.versions.list {
+		versions = append(versions, v.version)
+	}
+	return
+}
+
+// negotiate select the highest API version matching range and save it for
+// channel. The default API returns if range is empty or there is not
+// matching versions
+func (a *API) negotiate(c *Channel, r string) (v *API) {
+	v = a
+	if a.versions == nil {
+		return
+	}
+	a.versions.Lock()
+	defer a.versions.Unlock()
+	delete(a.versions.channels, c.Address())
+	if r == "" {
+		return
+	}
+
+	var best *SemVer
+	for _, api := range append([]*API{a}, a.versions.list...) {
+		ver, err := ParseSemVer(api.version)
+		if err != nil {
+			continue
+		}
+		if ok, _ := APIVersionRange(r).Match(api.version); !ok {
+			continue
+		}
+		if best == nil || ver.Compare(*best) > 0 {
+			best, v = &ver, api
+		}
+	}
+	if v != a {
+		a.versions.channels[c.Address()] = v
+	}
+	log.Debugv.Println("api version", v.version, "negotiated by", c, "range:", r)
+	return
+}
+
+// channelVersion return API version negotiated by channel
+func (a API) channelVersion(c *Channel) *API {
+	if a.versions == nil {
+		return &a
+	}
+	a.versions.RLock()
+	defer a.versions.RUnlock()
+	if v, ok := a.versions.channels[c.Address()]; ok {
+		return v
+	}
+	return &a
+}
+
+// forget remove channel negotiated version
+func (a API) forget(c *Channel) {
+	if a.versions == nil {
+		return
+	}
+	a.versions.Lock()
+	defer a.versions.Unlock()
+	delete(a.versions.channels, c.Address())
+}
+
+// checkVersion check received api version matches required range
+func (api *APIClient) checkVersion(version string) (err error) {
+	if api.versionRange == "" {
+		return
+	}
+	ok, err := api.versionRange.Match(version)
+	if err != nil || !ok {
+		err = fmt.Errorf("%w: peer %s api version %q, required %q", ErrAPIVersion,
+			api.address, version, api.versionRange)
+	}
+	return
+}
@@ -0,0 +1,80 @@
+// Test of api versions
+package teonet
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestAPIVersion(t *testing.T) {
+
+	t.Run("Range", func(t *testing.T) {
+		for _, test := range []struct {
+			r       APIVersionRange
+			version string
+			want    bool
+		}{
+			{"^1.2", "1.4.0", true},
+			{"^1.2", "2.0.0", false},
+			{"^1.2", "1.1.9", false},
+			{"^0.2.1", "0.2.5", true},
+			{"^0.2.1", "0.3.0", false},
+			{"~1.4.1", "1.4.9", true},
+			{"~1.4.1", "1.5.0", false},
+			{">=1.2.0 <2", "1.9.9", true},
+			{">=1.2.0, <2", "2.0.0", false},
+			{"1.2.3", "v1.2.3", true},
+			{"<1.0.0", "1.0.0-beta", true},
+			{"^1 || ^3", "3.1.0", true},
+			{"*", "0.0.1", true},
+		} {
+			if ok, err := test.r.Match(test.version); err != nil || ok != test.want {
+				t.Errorf("wrong match %q of %q: %v %v", test.version, test.r, ok, err)
+			}
+		}
+		if _, err := APIVersionRange("!1.0").Match("1.0.0"); !errors.Is(err, ErrWrongVersion) {
+			t.Errorf("wrong range error: %v", err)
+		}
+	})
+
+	t.Run("Negotiate", func(t *testing.T) {
+		cli, srv, addr := newLocalPeers(t)
+
+		reader := func(answer string) func(c *Channel, p *Packet, data []byte) bool {
+			return func(c *Channel, p *Packet, data []byte) bool {
+				c.Reply(p, []byte(answer))
+				return true
+			}
+		}
+		api := srv.NewAPI("test", "test", "test api", "2.1.0")
+		api.Add(MakeAPI2().SetName("cmd").SetCmd(129).SetReader(reader("v2")))
+		v1 := api.NewVersion("1.4.0")
+		v1.Add(MakeAPI2().SetName("cmd").SetCmd(129).SetReader(reader("v1")))
+		srv.AddReader(api.Reader())
+
+		for _, test := range []struct {
+			r       APIVersionRange
+			version string
+			answer  string
+		}{
+			{"^1.0", "1.4.0", "v1"},
+			{">=2", "2.1.0", "v2"},
+			{"", "2.1.0", "v2"},
+		} {
+			apicli, err := cli.NewAPIClient(addr, test.r)
+			if err != nil || apicli.AppVersion() != test.version {
+				t.Errorf("wrong api version for %q: %v", test.r, err)
+				continue
+			}
+			data, err := cli.Request(context.Background(), addr, 129, nil)
+			if err != nil || string(data) != test.answer {
+				t.Errorf("wrong answer for %q: %s %v", test.r, data, err)
+			}
+		}
+
+		if _, err := cli.NewAPIClient(addr, APIVersionRange("^3")); !errors.Is(err, ErrAPIVersion) {
+			t.Errorf("wrong version error: %v", err)
+		}
+	})
+}
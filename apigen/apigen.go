@@ -0,0 +1,499 @@
+// Copyright 2023 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet api generator package: generates typed Go client package from peer
+// API description.
+//
+// The generated client has one method per API command. Methods send
+// commands with its command numbers (one byte or extended), wait answers
+// depending of command answer mode and remove command header and packet id
+// from answers (by teo.RequestAPI). Commands with parameters or return data
+// schemas get typed arguments and results structs encoded and decoded by
+// the schemas.
+//
+// The API description is APIDataAr received from peer (APIClient.Snapshot) or
+// loaded from binary description file (api cache or 'api -export bin'):
+//
+//	data, _ := os.ReadFile("teoapi.bin")
+//	var ar teonet.APIDataAr
+//	ar.UnmarshalBinary(data)
+//	code, err := apigen.Generate(ar, "teoapi")
+package apigen
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/format"
+	"strings"
+	"unicode"
+
+	"github.com/teonet-go/teonet"
+)
+
+// ErrEmptyAPI returns by Generate when api has not commands
+var ErrEmptyAPI = errors.New("api has not commands")
+
+// Generate Go client package source code from api description
+func Generate(ar teonet.APIDataAr, pkg string) (code []byte, err error) {
+	if len(ar.Apis) == 0 {
+		err = ErrEmptyAPI
+		return
+	}
+	if pkg == "" {
+		pkg = PackageName(ar.AppShort())
+	}
+
+	for i := range ar.Apis {
+		if err = checkSchemas(ar.Apis[i]); err != nil {
+			return
+		}
+	}
+
+	g := &generator{names: map[string]bool{"Address": true,
+		"CheckVersion": true}}
+	g.header(ar, pkg)
+	for i := range ar.Apis {
+		g.command(ar.Apis[i])
+	}
+	g.buf.Write(g.types.Bytes())
+
+	code, err = format.Source(g.buf.Bytes())
+	if err != nil {
+		err = fmt.Errorf("can't format generated code: %w", err)
+	}
+	return
+}
+
+// checkSchemas check command parameters and return data schemas
+func checkSchemas(api teonet.APIData) error {
+	for _, s := range []*teonet.Schema{api.Params(), api.Returns()} {
+		if s == nil {
+			continue
+		}
+		if err := s.Check(); err != nil {
+			return fmt.Errorf("wrong schema of command '%s': %w", api.Name(), err)
+		}
+	}
+	return nil
+}
+
+// PackageName return valid package name from application name
+func PackageName(name string) (pkg string) {
+	for _, r := range strings.ToLower(name) {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			pkg += string(r)
+		}
+	}
+	if pkg == "" || unicode.IsDigit(rune(pkg[0])) {
+		pkg = "api" + pkg
+	}
+	return
+}
+
+// generator contains generated code
+type generator struct {
+	buf   bytes.Buffer    // Package header and methods
+	types bytes.Buffer    // Arguments and results types
+	names map[string]bool // Used names
+}
+
+// printf print to generated code
+func (g *generator) printf(format string, a ...interface{}) {
+	fmt.Fprintf(&g.buf, format, a...)
+}
+
+// header generate package header and Client type
+func (g *generator) header(ar teonet.APIDataAr, pkg string) {
+	g.printf("// Code generated by teonet apigen from %q api version %q. DO NOT EDIT.\n\n",
+		ar.AppShort(), ar.AppVersion())
+	g.printf("// Package %s is teonet %q api client", pkg, ar.AppShort())
+	if ar.AppName() != "" {
+		g.printf(": %s", oneLine(ar.AppName()))
+	}
+	g.printf("\npackage %s\n\n", pkg)
+	g.printf("import (\n\"context\"\n\n\"github.com/teonet-go/teonet\"\n)\n\n")
+
+	g.printf("// API name and version the client generated from\n")
+	g.printf("const (\nAPIName = %q\nAPIVersion = %q\n)\n\n", ar.AppShort(),
+		ar.AppVersion())
+
+	g.printf("// Client is %q api client\n", ar.AppShort())
+	g.printf("type Client struct {\nteo *teonet.Teonet\naddress string\n}\n\n")
+	g.printf("// New create new api client. The teonet should be connected to " +
+		"peer address\n")
+	g.printf("func New(teo *teonet.Teonet, address string) *Client {\n" +
+		"return &Client{teo: teo, address: address}\n}\n\n")
+	g.printf("// Address return peer address\n")
+	g.printf("func (c *Client) Address() string { return c.address }\n\n")
+
+	// Version check
+	if _, err := teonet.ParseSemVer(ar.AppVersion()); err == nil {
+		g.printf("// CheckVersion check peer api version is compatible with " +
+			"APIVersion\n")
+		g.printf("func (c *Client) CheckVersion() error {\n" +
+			"api, err := c.teo.NewAPIClient(c.address, " +
+			"teonet.APIVersionRange(\"^\"+APIVersion))\n" +
+			"if err != nil {\nreturn err\n}\napi.Close()\nreturn nil\n}\n\n")
+	}
+}
+
+// command generate command method
+func (g *generator) command(api teonet.APIData) {
+	name := g.uniqueName(GoName(api.Name()), api)
+	_, answerMode := api.ExecMode()
+	params, returns := api.Params(), api.Returns()
+	if answerMode == teonet.NoAnswer {
+		returns = nil
+	}
+
+	// Command description
+	g.printf("// %s %s\n", name, commandDoc(api))
+	if api.Long() != "" && api.Long() != api.Short() {
+		g.printf("//\n// %s\n", comment(api.Long()))
+	}
+	if api.Usage() != "" {
+		g.printf("//\n// Usage: %s\n", oneLine(api.Usage()))
+	}
+
+	// Arguments
+	var args, encode string
+	switch {
+	case params == nil:
+		args, encode = "data []byte", ""
+	case params.Encoding == teonet.EncodingRaw:
+		arg := argName(params.Fields[0].Name)
+		args = arg + " " + g.goType(params.Fields[0], "")
+		encode = fmt.Sprintf("data := []byte(%s)\n", arg)
+	default:
+		typeName := name + "Params"
+		schema := lowerName(typeName)
+		g.schema(schema, *params)
+		g.structType(typeName, fmt.Sprintf("%s command parameters", name),
+			params.Fields)
+		args = "params " + typeName
+		encode = fmt.Sprintf("data, err := %s.Encode(params.values())\n"+
+			"if err != nil {\nreturn\n}\n", schema)
+	}
+
+	// Results
+	var results, decode string
+	switch {
+	case answerMode == teonet.NoAnswer:
+		results = "(err error)"
+	case returns == nil:
+		results, decode = "(res []byte, err error)", "res = data\n"
+	case returns.Encoding == teonet.EncodingRaw:
+		results = "(res " + g.goType(returns.Fields[0], "") + ", err error)"
+		decode = fmt.Sprintf("res = %s(data)\n", g.goType(returns.Fields[0], ""))
+	default:
+		typeName := name + "Result"
+		schema := lowerName(typeName)
+		g.schema(schema, *returns)
+		g.structType(typeName, fmt.Sprintf("%s command result", name),
+			returns.Fields)
+		results = "(res " + typeName + ", err error)"
+		decode = fmt.Sprintf("values, err := %s.Decode(data)\n"+
+			"if err != nil {\nreturn\n}\nres.set(values)\n", schema)
+	}
+
+	// Method
+	command := fmt.Sprintf("teonet.Command{Cmd: %d, Data: data}", api.Cmd())
+	if api.Cmd() == teonet.CmdExtended {
+		command = fmt.Sprintf("teonet.Command{Cmd: teonet.CmdExtended, "+
+			"Ext: %d, Data: data}", api.ExtCmd())
+	}
+	g.printf("func (c *Client) %s(ctx context.Context, %s) %s {\n", name, args,
+		results)
+	g.printf("%s", encode)
+	if answerMode == teonet.NoAnswer {
+		g.printf("_, err = c.teo.RequestAPI(ctx, c.address, %s, %s)\nreturn\n}\n\n",
+			command, answerModeString(answerMode))
+		return
+	}
+	g.printf("data, err = c.teo.RequestAPI(ctx, c.address, %s, %s)\n"+
+		"if err != nil {\nreturn\n}\n", command,
+		answerModeString(answerMode))
+	g.printf("%sreturn\n}\n\n", decode)
+}
+
+// uniqueName return unique method name
+func (g *generator) uniqueName(name string, api teonet.APIData) string {
+	if g.names[name] {
+		name = fmt.Sprintf("%sCmd%d", name, api.Cmd())
+		if api.Cmd() == teonet.CmdExtended {
+			name = fmt.Sprintf("%sExt%d", name, api.ExtCmd())
+		}
+	}
+	g.names[name] = true
+	return name
+}
+
+// schema generate schema variable
+func (g *generator) schema(name string, s teonet.Schema) {
+	fmt.Fprintf(&g.types, "// %s is %s schema\nvar %s = teonet.Schema{"+
+		"Encoding: %s, Fields: %s}\n\n", name, s, name, encodingString(s.Encoding),
+		fieldsLit(s.Fields))
+}
+
+// structType generate struct type with fields and conversion methods
+func (g *generator) structType(name, doc string, fields []teonet.SchemaField) {
+	if g.names[name] {
+		return
+	}
+	g.names[name] = true
+
+	// Type
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// %s is %s\ntype %s struct {\n", name, doc, name)
+	for _, f := range fields {
+		fmt.Fprintf(&b, "%s %s `json:\"%s\"`\n", GoName(f.Name),
+			g.goType(f, name+GoName(f.Name)), f.Name)
+	}
+	fmt.Fprintf(&b, "}\n\n")
+
+	// Values map
+	fmt.Fprintf(&b, "// values return %s fields values map\n", name)
+	fmt.Fprintf(&b, "func (s %s) values() map[string]interface{} {\n"+
+		"return map[string]interface{}{\n", name)
+	for _, f := range fields {
+		fmt.Fprintf(&b, "%q: %s,\n", f.Name, valueExpr(f, "s."+GoName(f.Name), 0))
+	}
+	fmt.Fprintf(&b, "}\n}\n\n")
+
+	// Set from values map
+	fmt.Fprintf(&b, "// set %s fields from values map\n", name)
+	fmt.Fprintf(&b, "func (s *%s) set(values map[string]interface{}) {\n", name)
+	for _, f := range fields {
+		b.WriteString(g.setStmt(f, fmt.Sprintf("values[%q]", f.Name),
+			"s."+GoName(f.Name), name+GoName(f.Name), 0))
+	}
+	fmt.Fprintf(&b, "}\n\n")
+
+	g.types.Write(b.Bytes())
+}
+
+// goType return Go type of schema field. Struct types are generated with
+// typeName
+func (g *generator) goType(f teonet.SchemaField, typeName string) string {
+	switch f.Type {
+	case teonet.SchemaString:
+		return "string"
+	case teonet.SchemaBytes:
+		return "[]byte"
+	case teonet.SchemaBool:
+		return "bool"
+	case teonet.SchemaInt:
+		return "int64"
+	case teonet.SchemaUint:
+		return "uint64"
+	case teonet.SchemaFloat:
+		return "float64"
+	case teonet.SchemaList:
+		if f.Elem == nil {
+			return "[]interface{}"
+		}
+		elem := *f.Elem
+		if elem.Name == "" {
+			elem.Name = f.Name
+		}
+		return "[]" + g.goType(elem, typeName)
+	case teonet.SchemaStruct:
+		g.structType(typeName, fmt.Sprintf("%q field value", f.Name), f.Fields)
+		return typeName
+	}
+	return "interface{}"
+}
+
+// valueExpr return expression which converts field value to schema value
+func valueExpr(f teonet.SchemaField, expr string, depth int) string {
+	switch f.Type {
+	case teonet.SchemaStruct:
+		return expr + ".values()"
+	case teonet.SchemaList:
+		if !needsConversion(f) {
+			return expr
+		}
+		i, v := fmt.Sprintf("i%d", depth), fmt.Sprintf("v%d", depth)
+		return fmt.Sprintf("func() []interface{} {\nl := make([]interface{}, "+
+			"len(%s))\nfor %s, %s := range %s {\nl[%s] = %s\n}\nreturn l\n}()",
+			expr, i, v, expr, i, valueExpr(*f.Elem, v, depth+1))
+	}
+	return expr
+}
+
+// needsConversion check field value contains structs which should be
+// converted to values maps
+func needsConversion(f teonet.SchemaField) bool {
+	switch f.Type {
+	case teonet.SchemaStruct:
+		return true
+	case teonet.SchemaList:
+		return f.Elem != nil && needsConversion(*f.Elem)
+	}
+	return false
+}
+
+// setStmt return statement which sets field from schema value
+func (g *generator) setStmt(f teonet.SchemaField, src, dst, typeName string,
+	depth int) string {
+
+	switch f.Type {
+	case teonet.SchemaStruct:
+		m := fmt.Sprintf("m%d", depth)
+		return fmt.Sprintf("if %s, ok := %s.(map[string]interface{}); ok {\n"+
+			"%s.set(%s)\n}\n", m, src, dst, m)
+	case teonet.SchemaList:
+		if f.Elem == nil {
+			return fmt.Sprintf("%s, _ = %s.([]interface{})\n", dst, src)
+		}
+		l, i := fmt.Sprintf("l%d", depth), fmt.Sprintf("i%d", depth)
+		return fmt.Sprintf("if %s, ok := %s.([]interface{}); ok {\n"+
+			"%s = make(%s, len(%s))\nfor %s := range %s {\n%s}\n}\n",
+			l, src, dst, g.goType(f, typeName), l, i, l,
+			g.setStmt(*f.Elem, l+"["+i+"]", dst+"["+i+"]", typeName, depth+1))
+	}
+	return fmt.Sprintf("%s, _ = %s.(%s)\n", dst, src, g.goType(f, typeName))
+}
+
+// fieldsLit return schema fields Go literal
+func fieldsLit(fields []teonet.SchemaField) string {
+	var lits []string
+	for _, f := range fields {
+		lits = append(lits, fieldLit(f))
+	}
+	return "[]teonet.SchemaField{" + strings.Join(lits, ", ") + "}"
+}
+
+// fieldLit return schema field Go literal
+func fieldLit(f teonet.SchemaField) string {
+	lit := fmt.Sprintf("{Type: %s", typeString(f.Type))
+	if f.Name != "" {
+		lit = fmt.Sprintf("{Name: %q, Type: %s", f.Name, typeString(f.Type))
+	}
+	if f.Optional {
+		lit += ", Optional: true"
+	}
+	if f.Elem != nil {
+		lit += ", Elem: &teonet.SchemaField" + fieldLit(*f.Elem)
+	}
+	if len(f.Fields) > 0 {
+		lit += ", Fields: " + fieldsLit(f.Fields)
+	}
+	return lit + "}"
+}
+
+// typeString return schema type constant name
+func typeString(t teonet.SchemaType) string {
+	switch t {
+	case teonet.SchemaString:
+		return "teonet.SchemaString"
+	case teonet.SchemaBytes:
+		return "teonet.SchemaBytes"
+	case teonet.SchemaBool:
+		return "teonet.SchemaBool"
+	case teonet.SchemaInt:
+		return "teonet.SchemaInt"
+	case teonet.SchemaUint:
+		return "teonet.SchemaUint"
+	case teonet.SchemaFloat:
+		return "teonet.SchemaFloat"
+	case teonet.SchemaList:
+		return "teonet.SchemaList"
+	case teonet.SchemaStruct:
+		return "teonet.SchemaStruct"
+	}
+	return fmt.Sprintf("teonet.SchemaType(%d)", t)
+}
+
+// encodingString return schema encoding constant name
+func encodingString(e teonet.SchemaEncoding) string {
+	switch e {
+	case teonet.EncodingJSON:
+		return "teonet.EncodingJSON"
+	case teonet.EncodingBinary:
+		return "teonet.EncodingBinary"
+	}
+	return "teonet.EncodingRaw"
+}
+
+// answerModeString return answer mode constants expression
+func answerModeString(m teonet.APIanswerMode) string {
+	var modes []string
+	if m&teonet.DataAnswer > 0 {
+		modes = append(modes, "teonet.DataAnswer")
+	}
+	if m&teonet.CmdAnswer > 0 {
+		modes = append(modes, "teonet.CmdAnswer")
+	}
+	if m&teonet.PacketIDAnswer > 0 {
+		modes = append(modes, "teonet.PacketIDAnswer")
+	}
+	if len(modes) == 0 {
+		return "teonet.NoAnswer"
+	}
+	return strings.Join(modes, "|")
+}
+
+// GoName return exported Go name from command or field name: 'get-user_id'
+// converts to 'GetUserID'
+func GoName(name string) (goName string) {
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return r > unicode.MaxASCII || !(unicode.IsLetter(r) || unicode.IsDigit(r))
+	})
+	for _, w := range words {
+		if upper := strings.ToUpper(w); initialisms[upper] {
+			goName += upper
+			continue
+		}
+		goName += strings.ToUpper(w[:1]) + w[1:]
+	}
+	if goName == "" || unicode.IsDigit(rune(goName[0])) {
+		goName = "X" + goName
+	}
+	return
+}
+
+// initialisms are words which written in upper case in Go names
+var initialisms = map[string]bool{"API": true, "ID": true, "IP": true,
+	"JSON": true, "URL": true, "HTTP": true, "UID": true}
+
+// lowerName return name with lower first letter or first initialism
+func lowerName(name string) string {
+	for w := range initialisms {
+		if strings.HasPrefix(name, w) {
+			return strings.ToLower(w) + name[len(w):]
+		}
+	}
+	return strings.ToLower(name[:1]) + name[1:]
+}
+
+// argName return method argument name which does not conflict with method
+// variables
+func argName(name string) (arg string) {
+	arg = lowerName(GoName(name))
+	switch arg {
+	case "c", "ctx", "data", "err", "res", "values":
+		arg += "Arg"
+	}
+	return
+}
+
+// commandDoc return command short description as method comment
+func commandDoc(api teonet.APIData) string {
+	if api.Short() == "" {
+		return fmt.Sprintf("execute %q command", api.Name())
+	}
+	return oneLine(api.Short())
+}
+
+// oneLine return string without line breaks
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// comment return multi line string as comment text
+func comment(s string) string {
+	return strings.ReplaceAll(strings.TrimSpace(s), "\n", "\n// ")
+}
@@ -0,0 +1,110 @@
+// Test of api client generator
+package apigen
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strings"
+	"testing"
+
+	"github.com/teonet-go/teonet"
+)
+
+// typeCheck parse and type check generated package
+func typeCheck(t *testing.T, code []byte) {
+	t.Helper()
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "client.go", code, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	if _, err = conf.Check(f.Name.Name, fset, []*ast.File{f}, nil); err != nil {
+		t.Fatalf("generated code does not compile: %v\n%s", err, code)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+
+	// Api description
+	var teo *teonet.Teonet
+	api := teo.NewAPI("Test API", "teotest", "test api", "1.2.3")
+	api.Add(
+		teonet.MakeAPI2().SetName("hello").SetCmd(129).SetShort("say hello").
+			SetAnswerMode(teonet.DataAnswer),
+		teonet.MakeAPI2().SetName("get-user_id").SetCmd(130).
+			SetAnswerMode(teonet.CmdAnswer|teonet.PacketIDAnswer).
+			SetParams(teonet.Schema{Encoding: teonet.EncodingBinary,
+				Fields: []teonet.SchemaField{
+					{Name: "name", Type: teonet.SchemaString},
+					{Name: "tags", Type: teonet.SchemaList, Elem: &teonet.SchemaField{
+						Type: teonet.SchemaStruct, Fields: []teonet.SchemaField{
+							{Name: "k", Type: teonet.SchemaString},
+						}}},
+				}}).
+			SetReturns(teonet.Schema{Encoding: teonet.EncodingJSON,
+				Fields: []teonet.SchemaField{{Name: "id", Type: teonet.SchemaUint}}}),
+		teonet.MakeAPI2().SetName("echo").SetExtCmd(1000).
+			SetParams(teonet.Schema{Fields: []teonet.SchemaField{
+				{Name: "data", Type: teonet.SchemaString}}}).
+			SetReturns(teonet.Schema{Fields: []teonet.SchemaField{
+				{Name: "data", Type: teonet.SchemaBytes}}}),
+		teonet.MakeAPI2().SetName("ping").SetCmd(131).
+			SetAnswerMode(teonet.NoAnswer),
+	)
+	data, _ := api.MarshalBinary()
+	var ar teonet.APIDataAr
+	if err := ar.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := Generate(ar, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	typeCheck(t, code)
+
+	for _, want := range []string{
+		"package teotest",
+		`APIVersion = "1.2.3"`,
+		"func (c *Client) Hello(ctx context.Context, data []byte) (res []byte, err error)",
+		"teonet.Command{Cmd: 129, Data: data}, teonet.DataAnswer)",
+		"func (c *Client) GetUserID(ctx context.Context, params GetUserIDParams) (res GetUserIDResult, err error)",
+		"teonet.CmdAnswer|teonet.PacketIDAnswer)",
+		"Tags []GetUserIDParamsTags `json:\"tags\"`",
+		"func (c *Client) Echo(ctx context.Context, dataArg string) (res []byte, err error)",
+		"teonet.Command{Cmd: teonet.CmdExtended, Ext: 1000, Data: data}",
+		"func (c *Client) Ping(ctx context.Context, data []byte) (err error)",
+	} {
+		if !strings.Contains(string(code), want) {
+			t.Errorf("generated code does not contain: %s", want)
+		}
+	}
+
+	if _, err = Generate(teonet.APIDataAr{}, ""); err != ErrEmptyAPI {
+		t.Errorf("wrong empty api error: %v", err)
+	}
+
+	// Command with wrong schema
+	ar.Apis[2].Params().Fields = nil
+	ar.Apis[2].Params().Encoding = teonet.EncodingRaw
+	if _, err = Generate(ar, ""); err == nil {
+		t.Error("api with wrong schema generated")
+	}
+}
+
+func TestGoName(t *testing.T) {
+	for name, want := range map[string]string{
+		"hello":       "Hello",
+		"get-user_id": "GetUserID",
+		"api":         "API",
+		"2fa":         "X2fa",
+	} {
+		if got := GoName(name); got != want {
+			t.Errorf("wrong name of %s: %s", name, got)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+// Copyright 2023 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet api mock package: mock API server to test code which uses
+// APIClient without real service.
+//
+// The mock server creates from API description recorded from live peer
+// (teonet CLI 'api -export bin -o <file> <address>' command or api cache)
+// or declared in code with teo.NewAPI. It answers API command the same as
+// recorded service, executes commands stubs and records received calls:
+//
+//	srv, _ := teonet.New("mock", teonet.DirectConnect(true))
+//	m, _ := apimock.Load(srv, "testdata/teoapi.bin")
+//	m.Stub("hello", "Hello John")
+//	m.Stub("secret", teonet.ErrAPINotAuthorized, 100*time.Millisecond)
+//	addr, _ := cli.ConnectDirect(fmt.Sprintf("127.0.0.1:%d", srv.Port()))
+//	// ... test code which uses cli.NewAPIClient(addr)
+//	m.AssertCalled(t, "hello", 1)
+package apimock
+
+import (
+	"bytes"
+	"encoding"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/teonet-go/teonet"
+)
+
+// ErrNotStubbed answers to commands without stubs
+var ErrNotStubbed = teonet.NewAPIError(teonet.APIErrInternal,
+	"command does not stubbed")
+
+// ErrUnknownCommand returns by Stub when command does not exists in api
+// description
+var ErrUnknownCommand = errors.New("unknown command")
+
+// Handler is stub function which returns command answer or error
+type Handler func(data []byte) ([]byte, error)
+
+// Call is command call received by mock
+type Call struct {
+	Command string    // Command name
+	From    string    // Peer address
+	Data    []byte    // Command data
+	Time    time.Time // Receive time
+}
+
+// TB is part of testing.TB interface used by asserts
+type TB interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// Mock is mock API server
+type Mock struct {
+	*teonet.API
+	names map[string]bool // Description commands names
+	stubs map[string]*stub
+	calls []Call
+	sync.RWMutex
+}
+
+// stub is command stub
+type stub struct {
+	reply   []byte
+	err     error
+	delay   time.Duration
+	handler Handler
+}
+
+// New create mock API server from API description and add its reader to
+// teonet. The description may be teonet.APIDataAr or *teonet.API. The
+// teonet should not have other api readers
+func New(teo *teonet.Teonet, description encoding.BinaryMarshaler) (
+	m *Mock, err error) {
+
+	data, err := description.MarshalBinary()
+	if err != nil {
+		return
+	}
+	var ar teonet.APIDataAr
+	if err = ar.UnmarshalBinary(data); err != nil {
+		return
+	}
+	return newMock(teo, ar), nil
+}
+
+// Load create mock API server from binary API description file
+func Load(teo *teonet.Teonet, file string) (m *Mock, err error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return
+	}
+	var ar teonet.APIDataAr
+	if err = ar.UnmarshalBinary(data); err != nil {
+		return
+	}
+	return newMock(teo, ar), nil
+}
+
+// newMock create mock API server from APIDataAr. The first description
+// command is api command, it creates by teo.NewAPI
+func newMock(teo *teonet.Teonet, ar teonet.APIDataAr) (m *Mock) {
+	cmdAPI, apis := byte(teonet.CmdServerAPI), ar.Apis
+	if len(apis) > 0 && apis[0].Name() == "api" {
+		cmdAPI, apis = apis[0].Cmd(), apis[1:]
+	}
+	m = &Mock{names: make(map[string]bool), stubs: make(map[string]*stub)}
+	m.API = teo.NewAPI(ar.AppName(), ar.AppShort(), ar.AppLong(),
+		ar.AppVersion(), cmdAPI)
+	for i := range apis {
+		cmd := apis[i]
+		cmd.SetReader(func(c *teonet.Channel, p *teonet.Packet,
+			data []byte) bool {
+			m.exec(&cmd, c, p, data)
+			return true
+		})
+		m.Add(&cmd)
+		m.names[cmd.Name()] = true
+	}
+	teo.AddReader(m.Reader())
+	return
+}
+
+// Stub set command stub. The attr parameters:
+//
+//	[]byte or string   command answer
+//	error              error answered to peer
+//	time.Duration      answer delay
+//	Handler or func(data []byte) ([]byte, error)
+//	                   function which returns answer or error
+//
+// Commands without stubs answers ErrNotStubbed
+func (m *Mock) Stub(command string, attr ...interface{}) (err error) {
+	if !m.names[command] {
+		err = fmt.Errorf("%w: %s", ErrUnknownCommand, command)
+		return
+	}
+	s := new(stub)
+	for i := range attr {
+		switch v := attr[i].(type) {
+		case []byte:
+			s.reply = v
+		case string:
+			s.reply = []byte(v)
+		case error:
+			s.err = v
+		case time.Duration:
+			s.delay = v
+		case Handler:
+			s.handler = v
+		case func(data []byte) ([]byte, error):
+			s.handler = v
+		}
+	}
+	m.Lock()
+	defer m.Unlock()
+	m.stubs[command] = s
+	return
+}
+
+// Calls return received calls of commands, or all received calls if
+// commands does not set
+func (m *Mock) Calls(commands ...string) (calls []Call) {
+	m.RLock()
+	defer m.RUnlock()
+	for _, call := range m.calls {
+		if len(commands) == 0 || contains(commands, call.Command) {
+			calls = append(calls, call)
+		}
+	}
+	return
+}
+
+// Called return number of command calls
+func (m *Mock) Called(command string) int {
+	return len(m.Calls(command))
+}
+
+// Reset remove stubs and received calls
+func (m *Mock) Reset() {
+	m.Lock()
+	defer m.Unlock()
+	m.stubs = make(map[string]*stub)
+	m.calls = nil
+}
+
+// AssertCalled check command was called times times
+func (m *Mock) AssertCalled(t TB, command string, times int) bool {
+	t.Helper()
+	if n := m.Called(command); n != times {
+		t.Errorf("command %s called %d times, expected %d", command, n, times)
+		return false
+	}
+	return true
+}
+
+// AssertCalledWith check command was called with data
+func (m *Mock) AssertCalledWith(t TB, command string, data []byte) bool {
+	t.Helper()
+	for _, call := range m.Calls(command) {
+		if bytes.Equal(call.Data, data) {
+			return true
+		}
+	}
+	t.Errorf("command %s does not called with data %q", command, data)
+	return false
+}
+
+// exec record command call and answer by command stub
+func (m *Mock) exec(cmd *teonet.APIData, c *teonet.Channel, p *teonet.Packet,
+	data []byte) {
+
+	m.Lock()
+	m.calls = append(m.calls, Call{
+		Command: cmd.Name(),
+		From:    c.Address(),
+		Data:    append([]byte{}, data...),
+		Time:    time.Now(),
+	})
+	s, ok := m.stubs[cmd.Name()]
+	m.Unlock()
+
+	answer := func() {
+		if !ok {
+			c.SendError(p, ErrNotStubbed)
+			return
+		}
+		reply, err := s.reply, s.err
+		if s.handler != nil {
+			reply, err = s.handler(data)
+		}
+		if err != nil {
+			c.SendError(p, err)
+			return
+		}
+		if _, answerMode := cmd.ExecMode(); answerMode == teonet.NoAnswer {
+			if _, ok := p.RequestID(); !ok {
+				return
+			}
+		}
+		m.SendAnswer(cmd, c, reply, p)
+	}
+	if !ok || s.delay == 0 {
+		answer()
+		return
+	}
+	data = append([]byte{}, data...)
+	go func() {
+		time.Sleep(s.delay)
+		answer()
+	}()
+}
+
+// contains check string slice contains string
+func contains(list []string, s string) bool {
+	for i := range list {
+		if list[i] == s {
+			return true
+		}
+	}
+	return false
+}
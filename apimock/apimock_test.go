@@ -0,0 +1,139 @@
+// Test of api mock server
+package apimock
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/teonet-go/teonet"
+)
+
+func TestMock(t *testing.T) {
+
+	// Api description declared in code and saved to file
+	var teo *teonet.Teonet
+	api := teo.NewAPI("Test API", "teotest", "test api", "1.2.3")
+	api.Add(
+		teonet.MakeAPI2().SetName("hello").SetCmd(129).SetShort("say hello"),
+		teonet.MakeAPI2().SetName("user").SetCmd(130).
+			SetAnswerMode(teonet.CmdAnswer|teonet.PacketIDAnswer).
+			SetReturns(teonet.Schema{Encoding: teonet.EncodingJSON,
+				Fields: []teonet.SchemaField{{Name: "id", Type: teonet.SchemaUint}}}),
+		teonet.MakeAPI2().SetName("echo").SetExtCmd(1000),
+	)
+	description, _ := api.MarshalBinary()
+	file := filepath.Join(t.TempDir(), "teotest.bin")
+	if err := os.WriteFile(file, description, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Mock server and client peers
+	srv, err := teonet.New("test-mock", teonet.OsConfigDir(t.TempDir()),
+		teonet.DirectConnect(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	m, err := Load(srv, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cli, err := teonet.New("test-client", teonet.OsConfigDir(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	addr, err := cli.ConnectDirect(fmt.Sprintf("127.0.0.1:%d", srv.Port()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apicli, err := cli.NewAPIClient(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer apicli.Close()
+
+	request := func(command interface{}, data []byte) ([]byte, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		return apicli.Request(ctx, command, data)
+	}
+
+	t.Run("Description", func(t *testing.T) {
+		data, _ := apicli.Snapshot().MarshalBinary()
+		if !bytes.Equal(data, description) {
+			t.Errorf("wrong api description:\n%v\n%v", data, description)
+		}
+	})
+
+	t.Run("Stubs", func(t *testing.T) {
+		m.Stub("hello", "Hello John")
+		m.Stub("user", []byte(`{"id":10}`))
+		m.Stub("echo", func(data []byte) ([]byte, error) { return data, nil })
+		for command, want := range map[string]string{
+			"hello": "Hello John", "user": `{"id":10}`, "echo": "ping",
+		} {
+			if reply, err := request(command, []byte("ping")); err != nil ||
+				string(reply) != want {
+				t.Errorf("wrong %s answer: %s, err: %v", command, reply, err)
+			}
+		}
+		if err := m.Stub("unknown", "data"); !errors.Is(err, ErrUnknownCommand) {
+			t.Errorf("unknown command stubbed, err: %v", err)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		m.Stub("hello", teonet.ErrAPIBusy)
+		if _, err := request("hello", nil); !errors.Is(err, teonet.ErrAPIBusy) {
+			t.Errorf("wrong stub error: %v", err)
+		}
+		m.Reset()
+		if _, err := request("hello", nil); !errors.Is(err, ErrNotStubbed) {
+			t.Errorf("wrong not stubbed error: %v", err)
+		}
+	})
+
+	t.Run("Delay", func(t *testing.T) {
+		m.Stub("hello", "Hello", 200*time.Millisecond)
+		start := time.Now()
+		if _, err := request("hello", nil); err != nil ||
+			time.Since(start) < 200*time.Millisecond {
+			t.Errorf("answer does not delayed, err: %v", err)
+		}
+	})
+
+	t.Run("Calls", func(t *testing.T) {
+		m.Reset()
+		m.Stub("echo", "")
+		request("echo", []byte("one"))
+		request("echo", []byte("two"))
+		request("hello", nil)
+		m.AssertCalled(t, "echo", 2)
+		m.AssertCalledWith(t, "echo", []byte("two"))
+		if calls := m.Calls(); len(calls) != 3 || calls[2].Command != "hello" ||
+			calls[0].From != cli.Address() {
+			t.Errorf("wrong calls: %v", calls)
+		}
+
+		// Failed asserts
+		var tb fakeTB
+		m.AssertCalled(&tb, "hello", 2)
+		m.AssertCalledWith(&tb, "echo", []byte("three"))
+		if tb.errors != 2 {
+			t.Errorf("wrong number of failed asserts: %d", tb.errors)
+		}
+	})
+}
+
+// fakeTB counts asserts errors
+type fakeTB struct{ errors int }
+
+func (tb *fakeTB) Helper()                                   {}
+func (tb *fakeTB) Errorf(format string, args ...interface{}) { tb.errors++ }
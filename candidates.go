@@ -0,0 +1,218 @@
+// Copyright 2023 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet local candidates policy module
+
+package teonet
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// AddrFamily is IP address family used in CandidatePolicy
+type AddrFamily byte
+
+const (
+	// AnyFamily - IPv4 and IPv6 addresses
+	AnyFamily AddrFamily = iota
+
+	// IPv4Family - IPv4 addresses
+	IPv4Family
+
+	// IPv6Family - IPv6 addresses
+	IPv6Family
+)
+
+// CandidatePolicy used in teonet.New parameter or in SetCandidatePolicy
+// function to select local addresses advertised to peers in
+// ConnectToData.LocalIPs and addresses punched during connection. The zero
+// value policy advertise all interfaces addresses.
+type CandidatePolicy struct {
+	// Interfaces names or patterns (path.Match syntax, f.e. "eth*") to take
+	// local addresses from. All interfaces used if empty
+	Interfaces []string
+
+	// Interfaces names or patterns (f.e. "docker*", "veth*") to skip
+	ExcludeInterfaces []string
+
+	// Networks in CIDR notation (f.e. "192.168.0.0/16") to take local
+	// addresses from. All networks used if empty
+	Networks []string
+
+	// Networks in CIDR notation (f.e. "172.17.0.0/16") which local addresses
+	// does not advertised and remote addresses does not punched
+	ExcludeNetworks []string
+
+	// Family allows IPv4 or IPv6 addresses only, AnyFamily allows both
+	Family AddrFamily
+
+	// Prefer moves addresses of this family to the top of advertised list
+	Prefer AddrFamily
+
+	// ExcludeLoopback skips loopback addresses
+	ExcludeLoopback bool
+
+	// ExcludeLinkLocal skips link-local addresses (f.e. fe80::/10)
+	ExcludeLinkLocal bool
+
+	// Endpoints is custom addresses advertised to peers, f.e. port-forwarded
+	// public IP. The endpoint is "ip" which used with teonet local port or
+	// "ip:port". Endpoints sends to peer through teonet auth server, so the
+	// auth server should be upgraded to version which relays them. Old auth
+	// server drops endpoints, and peers connect by local and external
+	// addresses only
+	Endpoints []string
+}
+
+// candidates contains candidate policy with parsed networks
+type candidates struct {
+	policy   CandidatePolicy
+	networks []*net.IPNet
+	exclude  []*net.IPNet
+	sync.RWMutex
+}
+
+// SetCandidatePolicy set local candidates policy
+func (teo *Teonet) SetCandidatePolicy(policy CandidatePolicy) (err error) {
+	networks, err := parseNetworks(policy.Networks)
+	if err != nil {
+		return
+	}
+	exclude, err := parseNetworks(policy.ExcludeNetworks)
+	if err != nil {
+		return
+	}
+
+	teo.candidates.Lock()
+	defer teo.candidates.Unlock()
+	teo.candidates.policy = policy
+	teo.candidates.networks = networks
+	teo.candidates.exclude = exclude
+	return
+}
+
+// CandidatePolicy return current local candidates policy
+func (teo *Teonet) CandidatePolicy() CandidatePolicy {
+	teo.candidates.RLock()
+	defer teo.candidates.RUnlock()
+	return teo.candidates.policy
+}
+
+// parseNetworks parse slice of networks in CIDR notation
+func parseNetworks(cidrs []string) (networks []*net.IPNet, err error) {
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("wrong network '%s' in candidate policy: %w",
+				cidr, err)
+		}
+		networks = append(networks, n)
+	}
+	return
+}
+
+// allowInterface return true if local interface allowed by policy
+func (c *candidates) allowInterface(name string) bool {
+	c.RLock()
+	defer c.RUnlock()
+	if len(c.policy.Interfaces) > 0 && !matchName(c.policy.Interfaces, name) {
+		return false
+	}
+	return !matchName(c.policy.ExcludeInterfaces, name)
+}
+
+// allowLocal return true if local ip allowed by policy
+func (c *candidates) allowLocal(ip net.IP) bool {
+	c.RLock()
+	defer c.RUnlock()
+	switch {
+	case c.policy.ExcludeLoopback && ip.IsLoopback():
+		return false
+	case c.policy.ExcludeLinkLocal &&
+		(ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast()):
+		return false
+	case len(c.networks) > 0 && !matchNetwork(c.networks, ip):
+		return false
+	}
+	return c.allowIP(ip)
+}
+
+// allowRemote return true if remote ip (received from peer) may be punched
+func (c *candidates) allowRemote(ipstr string) bool {
+	ip := net.ParseIP(strings.Trim(ipstr, "[]"))
+	if ip == nil {
+		return true
+	}
+	c.RLock()
+	defer c.RUnlock()
+	return c.allowIP(ip)
+}
+
+// allowIP check ip family and excluded networks, should be called under lock
+func (c *candidates) allowIP(ip net.IP) bool {
+	switch {
+	case c.policy.Family == IPv4Family && ip.To4() == nil:
+		return false
+	case c.policy.Family == IPv6Family && ip.To4() != nil:
+		return false
+	}
+	return !matchNetwork(c.exclude, ip)
+}
+
+// sort local ips by preferred family
+func (c *candidates) sort(ips []string) {
+	c.RLock()
+	prefer := c.policy.Prefer
+	c.RUnlock()
+	if prefer == AnyFamily {
+		return
+	}
+	preferred := func(ip string) bool {
+		ipv6 := strings.IndexByte(ip, ':') >= 0
+		return ipv6 == (prefer == IPv6Family)
+	}
+	sort.SliceStable(ips, func(i, j int) bool {
+		return preferred(ips[i]) && !preferred(ips[j])
+	})
+}
+
+// endpoints return policy custom endpoints: ips without port and ip:port
+// endpoints
+func (c *candidates) endpoints() (ips, endpoints []string) {
+	c.RLock()
+	defer c.RUnlock()
+	for _, e := range c.policy.Endpoints {
+		if _, _, err := net.SplitHostPort(e); err == nil {
+			endpoints = append(endpoints, e)
+			continue
+		}
+		ips = append(ips, e)
+	}
+	return
+}
+
+// matchName return true if name match any of patterns
+func matchName(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchNetwork return true if ip contains in any of networks
+func matchNetwork(networks []*net.IPNet, ip net.IP) bool {
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+// Test of local candidates policy
+package teonet
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newCandidates create candidates with policy
+func newCandidates(t *testing.T, policy CandidatePolicy) *candidates {
+	teo := &Teonet{candidates: new(candidates)}
+	if err := teo.SetCandidatePolicy(policy); err != nil {
+		t.Fatal(err)
+	}
+	return teo.candidates
+}
+
+func TestCandidates(t *testing.T) {
+
+	t.Run("Interface", func(t *testing.T) {
+		for _, test := range []struct {
+			policy CandidatePolicy
+			name   string
+			want   bool
+		}{
+			{CandidatePolicy{}, "eth0", true},
+			{CandidatePolicy{Interfaces: []string{"eth*"}}, "eth1", true},
+			{CandidatePolicy{Interfaces: []string{"eth*"}}, "wlan0", false},
+			{CandidatePolicy{ExcludeInterfaces: []string{"docker*", "veth*"}}, "docker0", false},
+			{CandidatePolicy{ExcludeInterfaces: []string{"docker*", "veth*"}}, "vethab12", false},
+			{CandidatePolicy{ExcludeInterfaces: []string{"docker*"}}, "eth0", true},
+			{CandidatePolicy{Interfaces: []string{"*"},
+				ExcludeInterfaces: []string{"lo"}}, "lo", false},
+		} {
+			c := newCandidates(t, test.policy)
+			if got := c.allowInterface(test.name); got != test.want {
+				t.Errorf("allowInterface(%s) with %+v: %v", test.name,
+					test.policy, got)
+			}
+		}
+	})
+
+	t.Run("Local", func(t *testing.T) {
+		for _, test := range []struct {
+			policy CandidatePolicy
+			ip     string
+			want   bool
+		}{
+			{CandidatePolicy{}, "127.0.0.1", true},
+			{CandidatePolicy{}, "fe80::1", true},
+			{CandidatePolicy{ExcludeLoopback: true}, "127.0.0.1", false},
+			{CandidatePolicy{ExcludeLoopback: true}, "::1", false},
+			{CandidatePolicy{ExcludeLoopback: true}, "192.168.1.10", true},
+			{CandidatePolicy{ExcludeLinkLocal: true}, "fe80::1", false},
+			{CandidatePolicy{ExcludeLinkLocal: true}, "169.254.0.5", false},
+			{CandidatePolicy{Networks: []string{"192.168.0.0/16"}}, "192.168.1.10", true},
+			{CandidatePolicy{Networks: []string{"192.168.0.0/16"}}, "10.0.0.1", false},
+			{CandidatePolicy{ExcludeNetworks: []string{"172.17.0.0/16"}}, "172.17.0.1", false},
+			{CandidatePolicy{Networks: []string{"172.16.0.0/12"},
+				ExcludeNetworks: []string{"172.17.0.0/16"}}, "172.18.0.1", true},
+			{CandidatePolicy{Family: IPv4Family}, "2001:db8::1", false},
+			{CandidatePolicy{Family: IPv4Family}, "10.0.0.1", true},
+			{CandidatePolicy{Family: IPv6Family}, "10.0.0.1", false},
+			{CandidatePolicy{Family: IPv6Family}, "2001:db8::1", true},
+		} {
+			c := newCandidates(t, test.policy)
+			if got := c.allowLocal(net.ParseIP(test.ip)); got != test.want {
+				t.Errorf("allowLocal(%s) with %+v: %v", test.ip, test.policy, got)
+			}
+		}
+	})
+
+	// Remote addresses does not checked by loopback, link-local and networks
+	// options
+	t.Run("Remote", func(t *testing.T) {
+		for _, test := range []struct {
+			policy CandidatePolicy
+			ip     string
+			want   bool
+		}{
+			{CandidatePolicy{}, "10.0.0.1", true},
+			{CandidatePolicy{ExcludeLoopback: true}, "127.0.0.1", true},
+			{CandidatePolicy{Networks: []string{"192.168.0.0/16"}}, "10.0.0.1", true},
+			{CandidatePolicy{ExcludeNetworks: []string{"172.17.0.0/16"}}, "172.17.0.2", false},
+			{CandidatePolicy{Family: IPv4Family}, "[2001:db8::1]", false},
+			{CandidatePolicy{Family: IPv6Family}, "[2001:db8::1]", true},
+			{CandidatePolicy{Family: IPv6Family}, "host.local", true},
+		} {
+			c := newCandidates(t, test.policy)
+			if got := c.allowRemote(test.ip); got != test.want {
+				t.Errorf("allowRemote(%s) with %+v: %v", test.ip, test.policy, got)
+			}
+		}
+	})
+
+	// Preferred family moves to the top, order inside families does not
+	// changed
+	t.Run("Order", func(t *testing.T) {
+		ips := "10.0.0.1 2001:db8::1 192.168.1.1 fe80::1 127.0.0.1"
+		for prefer, want := range map[AddrFamily]string{
+			AnyFamily:  ips,
+			IPv4Family: "10.0.0.1 192.168.1.1 127.0.0.1 2001:db8::1 fe80::1",
+			IPv6Family: "2001:db8::1 fe80::1 10.0.0.1 192.168.1.1 127.0.0.1",
+		} {
+			got := strings.Fields(ips)
+			newCandidates(t, CandidatePolicy{Prefer: prefer}).sort(got)
+			if strings.Join(got, " ") != want {
+				t.Errorf("wrong order with prefer %d: %v", prefer, got)
+			}
+		}
+	})
+
+	t.Run("Endpoints", func(t *testing.T) {
+		c := newCandidates(t, CandidatePolicy{
+			Endpoints: []string{"203.0.113.1", "203.0.113.2:9000", "[2001:db8::1]:9000"},
+		})
+		ips, endpoints := c.endpoints()
+		if !reflect.DeepEqual(ips, []string{"203.0.113.1"}) ||
+			!reflect.DeepEqual(endpoints, []string{"203.0.113.2:9000",
+				"[2001:db8::1]:9000"}) {
+			t.Errorf("wrong endpoints: %v %v", ips, endpoints)
+		}
+	})
+
+	t.Run("WrongNetwork", func(t *testing.T) {
+		teo := &Teonet{candidates: new(candidates)}
+		if err := teo.SetCandidatePolicy(CandidatePolicy{
+			ExcludeNetworks: []string{"10.0.0.0/33"}}); err == nil {
+			t.Error("wrong network accepted")
+		}
+	})
+}
@@ -0,0 +1,59 @@
+// Copyright 2023 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet peer capabilities module
+
+package teonet
+
+// peerCaps is set of peer capabilities flags. Peers exchange capabilities
+// during connection in ConnectToData. Old peers does not send capabilities,
+// so any new protocol feature should be used with peer only if the peer
+// capability flag is set.
+type peerCaps uint32
+
+const (
+	// capHeartbeat - peer answer to application level heartbeats
+	capHeartbeat peerCaps = 1 << iota
+
+	// capFragment - peer reassemble fragmented messages
+	capFragment
+
+	// capStream - peer accept multiplexed streams frames
+	capStream
+
+	// capRPC - peer process requests and replies with correlation id
+	capRPC
+
+	// capAPIError - peer process api error answers
+	capAPIError
+
+	// capRPCStream - peer process streaming requests
+	capRPCStream
+
+	// capFrames - peer sends and receives messages with frame type header
+	capFrames
+
+	// capExtCmd - peer sends and receives extended commands after CmdExtended
+	// escape byte
+	capExtCmd
+)
+
+// localCaps is this host capabilities
+const localCaps = capHeartbeat | capFragment | capStream | capRPC |
+	capAPIError | capRPCStream | capFrames | capExtCmd
+
+// newPeerCaps return capabilities received from peer. Service frames of all
+// protocol features are carried out of band in frames, so peer without
+// capFrames can't use any of them
+func newPeerCaps(caps uint32) peerCaps {
+	if c := peerCaps(caps); c.has(capFrames) {
+		return c
+	}
+	return 0
+}
+
+// has return true if all capabilities flags in f are set
+func (c peerCaps) has(f peerCaps) bool {
+	return c&f == f
+}
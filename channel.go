@@ -8,7 +8,9 @@ package teonet
 
 import (
 	"bytes"
+	"context"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/teonet-go/tru"
@@ -22,17 +24,82 @@ const (
 
 // Channel stract and method receiver
 type Channel struct {
-	a string       // Teonet address
-	c *tru.Channel // TRU channel
+	a    string       // Teonet address
+	conn *channelConn // TRU channel and peer capabilities
 	// Channel closed by CloseTo function, or reconnection set off by 
 	// ReconnectOff function
-	closing bool
+	closing    bool
+	stat       *channelStat // Channel activity and rtt statistic
+	reasm      *reassembler // Received fragments of incomplete messages
+	key        []byte       // Peer public key received in direct connect
+	direct     string       // Peer ip:port connected by ConnectDirect
+	unverified bool         // Peer address does not checked by teonet auth
+	teo        *Teonet      // Pointer to teonet
+
+	ctx    context.Context    // Canceled when channel disconnected
+	cancel context.CancelFunc // Cancel channel context
 }
 
 // new create new teonet channel
 func (c *channels) new(channel *tru.Channel) *Channel {
 	address := newChannelPrefix + tru.RandomString(addressLen-len(newChannelPrefix))
-	return &Channel{address, channel, false}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Channel{a: address, conn: &channelConn{c: channel},
+		stat: newChannelStat(), reasm: newReassembler(), teo: c.teo, ctx: ctx,
+		cancel: cancel}
+}
+
+// channelConn is peer connection of teonet channel. It changes when peer
+// connection migrated, Channel holds pointer to it because Channel methods
+// have value receivers
+type channelConn struct {
+	c    *tru.Channel // TRU channel
+	caps peerCaps     // Peer capabilities
+	sync.RWMutex
+}
+
+// truChannel return tru channel of peer connection
+func (c Channel) truChannel() *tru.Channel {
+	if c.conn == nil {
+		return nil
+	}
+	c.conn.RLock()
+	defer c.conn.RUnlock()
+	return c.conn.c
+}
+
+// capabilities return peer capabilities
+func (c Channel) capabilities() peerCaps {
+	if c.conn == nil {
+		return 0
+	}
+	c.conn.RLock()
+	defer c.conn.RUnlock()
+	return c.conn.caps
+}
+
+// setCaps set peer capabilities
+func (c Channel) setCaps(caps peerCaps) {
+	c.conn.Lock()
+	defer c.conn.Unlock()
+	c.conn.caps = caps
+}
+
+// setConn set tru channel and capabilities of peer connection, returns
+// previous tru channel
+func (c Channel) setConn(tc *tru.Channel, caps peerCaps) (old *tru.Channel) {
+	c.conn.Lock()
+	defer c.conn.Unlock()
+	old, c.conn.c, c.conn.caps = c.conn.c, tc, caps
+	return
+}
+
+// context return channel context which canceled when channel disconnected
+func (c *Channel) context() context.Context {
+	if c == nil || c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
 }
 
 // Channel get teonet channel by address
@@ -47,23 +114,69 @@ func (teo Teonet) ChannelByIP(addr string) (ch *Channel, exists bool) {
 
 // ServerMode return true if channel in server mode
 func (c Channel) ServerMode() bool {
-	return c.c.ServerMode()
+	return c.truChannel().ServerMode()
 }
 
 // ClientMode return true if channel in client mode
 func (c Channel) ClientMode() bool {
-	return !c.c.ServerMode()
+	return !c.truChannel().ServerMode()
 }
 
 // Triptime return channels triptime
 func (c Channel) Triptime() time.Duration {
-	return c.c.Triptime()
+	return c.truChannel().Triptime()
 }
 
-// Send data to channel
+// LastActivity return time of last data packet sent or received by channel
+func (c Channel) LastActivity() time.Time {
+	return c.stat.activity()
+}
+
+// SetIdleTimeout set channel idle timeout which overrides IdleTimeout
+// parameter of teonet.New for this channel. Set 0 to never disconnect this
+// channel by idle timeout, negative value restores teonet.New IdleTimeout.
+// Idle timeouts checked with HeartbeatInterval or every second when
+// heartbeats switched off
+func (c Channel) SetIdleTimeout(timeout time.Duration) {
+	c.stat.setIdle(timeout)
+}
+
+// IdleTimeout return channel idle timeout, 0 - channel never disconnected
+// by idle timeout
+func (c Channel) IdleTimeout() time.Duration {
+	var def time.Duration
+	if c.teo != nil && c.teo.keeper != nil {
+		def = c.teo.keeper.idle
+	}
+	return c.stat.idleTimeout(def)
+}
+
+// RTTHistory return channels round trip time history from oldest to newest
+// value. The values measured by heartbeats or got from tru triptime if peer
+// does not support heartbeats
+func (c Channel) RTTHistory() []time.Duration {
+	return c.stat.history()
+}
+
+// Send data to channel. Data larger than MaxDataLen sends in fragments if peer
+// supports it
 func (c Channel) Send(data []byte, attr ...interface{}) (id int, err error) {
+	return c.send(frameData, data, attr...)
+}
+
+// send frame of type t with data to channel
+func (c Channel) send(t frameType, data []byte, attr ...interface{}) (id int, err error) {
+	if len(data) > c.teo.fragmenter.maxMessageLen {
+		err = ErrMessageTooLarge
+		return
+	}
 	var delivery = c.checkSendAttr(attr...)
-	return c.c.WriteTo(data, delivery)
+	c.stat.touch()
+	data = c.frame(t, data)
+	if c.teo.fragmenter.needFragment(c, data) {
+		return c.sendFragments(data, delivery)
+	}
+	return c.truChannel().WriteTo(data, delivery)
 }
 
 // checkSendAttr check Send function attributes:
@@ -113,7 +226,7 @@ func (c Channel) subscribeToAnswer(teo *Teonet, f func(c *Channel, p *Packet, e
 // String is channel stringify and return string with channel address
 func (c Channel) String() string {
 	if c.a == "" {
-		return c.c.Addr().String()
+		return c.truChannel().Addr().String()
 	}
 	return c.a
 }
@@ -125,7 +238,13 @@ func (c Channel) Address() string {
 
 // Channel return return poiner to tru channel
 func (c Channel) Channel() *tru.Channel {
-	return c.c
+	return c.truChannel()
+}
+
+// Direct return true if channel connected by direct connect. The peer address
+// of such channel does not checked by teonet auth server
+func (c Channel) Direct() bool {
+	return c.unverified
 }
 
 // IsNew return true if channel has 'new' prefix
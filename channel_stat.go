@@ -0,0 +1,103 @@
+// Copyright 2023 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet channel statistic module
+
+package teonet
+
+import (
+	"sync"
+	"time"
+)
+
+// rttHistoryLen is number of round trip time values saved in channel history
+const rttHistoryLen = 32
+
+// channelStat contains channel activity and round trip time history
+type channelStat struct {
+	created      time.Time       // Channel created time
+	lastActivity time.Time       // Last time data was send or received
+	rtt          []time.Duration // Round trip time history (ring buffer)
+	rttIdx       int             // Next rtt index in ring buffer
+	hbMissed     int             // Number of heartbeats sent without answer
+	idle         time.Duration   // Channel idle timeout, negative - default
+	sync.RWMutex
+}
+
+// newChannelStat create new channel statistic
+func newChannelStat() *channelStat {
+	now := time.Now()
+	return &channelStat{created: now, lastActivity: now, idle: -1}
+}
+
+// touch set channel last activity time to now
+func (s *channelStat) touch() {
+	s.Lock()
+	defer s.Unlock()
+	s.lastActivity = time.Now()
+}
+
+// activity return channel last activity time
+func (s *channelStat) activity() time.Time {
+	s.RLock()
+	defer s.RUnlock()
+	return s.lastActivity
+}
+
+// setIdle set channel idle timeout, negative value set default timeout
+func (s *channelStat) setIdle(timeout time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+	s.idle = timeout
+}
+
+// idleTimeout return channel idle timeout or def if it does not set
+func (s *channelStat) idleTimeout(def time.Duration) time.Duration {
+	s.RLock()
+	defer s.RUnlock()
+	if s.idle < 0 {
+		return def
+	}
+	return s.idle
+}
+
+// addRTT add round trip time to history
+func (s *channelStat) addRTT(rtt time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+	if len(s.rtt) < rttHistoryLen {
+		s.rtt = append(s.rtt, rtt)
+		return
+	}
+	s.rtt[s.rttIdx] = rtt
+	s.rttIdx = (s.rttIdx + 1) % rttHistoryLen
+}
+
+// history return round trip time history from oldest to newest value
+func (s *channelStat) history() (h []time.Duration) {
+	s.RLock()
+	defer s.RUnlock()
+	h = make([]time.Duration, 0, len(s.rtt))
+	h = append(h, s.rtt[s.rttIdx:]...)
+	h = append(h, s.rtt[:s.rttIdx]...)
+	return
+}
+
+// heartbeatSent increment number of heartbeats without answer and return
+// number of missed heartbeats before this one
+func (s *channelStat) heartbeatSent() (missed int) {
+	s.Lock()
+	defer s.Unlock()
+	missed = s.hbMissed
+	s.hbMissed++
+	return
+}
+
+// heartbeatReceived reset number of missed heartbeats and add rtt to history
+func (s *channelStat) heartbeatReceived(rtt time.Duration) {
+	s.Lock()
+	s.hbMissed = 0
+	s.Unlock()
+	s.addRTT(rtt)
+}
@@ -50,16 +50,42 @@ func (c *channels) add(channel *Channel) {
 		c.del(ch, false)
 		reader(c.teo, ch, nil, &Event{EventDisconnected, nil})
 	}
+
+	// Free place for new peer if peers limit reached
+	if channel != c.teo.getAuth() {
+		c.teo.evict()
+	}
+
 	c.Lock()
 	defer c.Unlock()
 
 	c.m_addr[channel.a] = channel
-	c.m_chan[channel.c] = channel
+	c.m_chan[channel.truChannel()] = channel
 
 	// Connected - show log message and send Event to main reader
 	log.Connect.Println("peer connected:", channel.a)
 }
 
+// migrate move existing teonet channel to tru channel of new channel. Teonet
+// address and subscribers of existing channel does not changed. The old tru
+// channel closes by peer which created new connection only: disconnect sent
+// by other side may come before connect answer and destroy the channel
+// before it migrated. Other side old tru channel closes by this disconnect
+// or by its keepalive
+func (c *channels) migrate(ch *Channel, newch *Channel) {
+	tc := newch.truChannel()
+	c.Lock()
+	old := ch.setConn(tc, newch.capabilities())
+	delete(c.m_chan, old)
+	c.m_chan[tc] = ch
+	c.Unlock()
+
+	log.Connect.Println("peer migrated:", ch.a, old.Addr(), "->", tc.Addr())
+	if old != tc && !tc.ServerMode() {
+		old.Close()
+	}
+}
+
 // del delete teonet channel if second parameter omitted or true, the tru
 // channel will also deleted
 func (c *channels) del(channel *Channel, delTrudps ...bool) {
@@ -70,13 +96,17 @@ func (c *channels) del(channel *Channel, delTrudps ...bool) {
 	c.Lock()
 	defer c.Unlock()
 
+	tc := channel.truChannel()
 	delete(c.m_addr, channel.a)
-	delete(c.m_chan, channel.c)
-	// TODO: look why channel.c may be nil here
-	if delTrudp && channel.c != nil {
-		channel.c.Close()
+	delete(c.m_chan, tc)
+	// TODO: look why tru channel may be nil here
+	if delTrudp && tc != nil {
+		tc.Close()
 	}
 	c.teo.subscribers.del(channel)
+	if channel.cancel != nil {
+		channel.cancel()
+	}
 	log.Connect.Println("peer disconnected:", channel.a)
 }
 
@@ -98,7 +128,7 @@ func (c *channels) getByIP(ipport string) (ch *Channel, exists bool) {
 	c.RLock()
 	defer c.RUnlock()
 	for _, v := range c.m_addr {
-		if v.c.Addr().String() == ipport {
+		if v.truChannel().Addr().String() == ipport {
 			ch = v
 			exists = true
 			break
@@ -133,6 +163,21 @@ func (c *channels) peers() (p []string) {
 	return
 }
 
+// peersChannels get slice of connected peers channels, the auth channel does
+// not included
+func (c *channels) peersChannels() (chs []*Channel) {
+	c.RLock()
+	defer c.RUnlock()
+
+	for _, ch := range c.m_addr {
+		if ch == c.auth {
+			continue
+		}
+		chs = append(chs, ch)
+	}
+	return
+}
+
 // Peers get slice of channels address
 func (teo Teonet) Peers() (p []string) {
 	return teo.channels.peers()
@@ -0,0 +1,192 @@
+// Copyright 2023 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet channels keeper module: peers limit with eviction, idle timeouts and
+// heartbeats which keep NAT mappings alive and check peers liveness
+
+package teonet
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// Disconnect reasons sent in Err field of EventDisconnected event
+var (
+	ErrHeartbeatLost = errors.New("heartbeat lost")
+	ErrIdleTimeout   = errors.New("idle timeout")
+	ErrEvicted       = errors.New("evicted by peers limit")
+)
+
+const (
+	heartbeatLen             = 8 // Heartbeat frame data: send time
+	heartbeatLostAfter       = 3 // Number of missed heartbeats
+	defaultHeartbeatInterval = 5 * time.Second
+	idleCheckInterval        = 1 * time.Second
+)
+
+// MaxPeers used in teonet.New parameter to set max number of connected peers.
+// When this number reached the peer selected by EvictPolicy disconnected to
+// free place for new peer. Default 0 - unlimited
+type MaxPeers int
+
+// EvictPolicy used in teonet.New parameter to select peer disconnected when
+// MaxPeers limit reached
+type EvictPolicy byte
+
+const (
+	// EvictLRU - disconnect least recently used (longest idle) peer
+	EvictLRU EvictPolicy = iota
+
+	// EvictOldest - disconnect oldest connected peer
+	EvictOldest
+)
+
+// IdleTimeout used in teonet.New parameter to set time after which peer
+// without data packets will be disconnected. Heartbeats does not counted as
+// data packets. Default 0 - peers never disconnected by idle timeout. Use
+// Channel.SetIdleTimeout to set idle timeout of one channel
+type IdleTimeout time.Duration
+
+// HeartbeatInterval used in teonet.New parameter to set interval between
+// heartbeats sent to connected peers. Peer disconnected with ErrHeartbeatLost
+// reason when it does not answer to several heartbeats. Default 5 seconds,
+// set 0 to switch heartbeats off
+type HeartbeatInterval time.Duration
+
+// keeper contains channels keeper parameters
+type keeper struct {
+	maxPeers  int
+	policy    EvictPolicy
+	idle      time.Duration
+	heartbeat time.Duration
+}
+
+// keeperProcess periodically check channels idle timeouts and send heartbeats
+func (teo *Teonet) keeperProcess() {
+	var k = teo.keeper
+	var interval = k.heartbeat
+	if interval <= 0 {
+		interval = idleCheckInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-teo.closing:
+			return
+		case <-ticker.C:
+			teo.keeperCheck()
+		}
+	}
+}
+
+// keeperCheck check channels idle timeouts and send heartbeats
+func (teo *Teonet) keeperCheck() {
+	var k = teo.keeper
+	for _, c := range teo.channels.peersChannels() {
+		switch {
+		case c.IsNew():
+			continue
+
+		case c.idleExpired(k.idle):
+			teo.disconnect(c, ErrIdleTimeout)
+			continue
+
+		case k.heartbeat <= 0:
+			continue
+
+		// Old peers does not answer heartbeats, use tru triptime for history
+		case !c.capabilities().has(capHeartbeat):
+			c.stat.addRTT(c.Triptime())
+			continue
+
+		case c.stat.heartbeatSent() >= heartbeatLostAfter:
+			teo.disconnect(c, ErrHeartbeatLost)
+			continue
+		}
+		teo.sendHeartbeat(c)
+	}
+}
+
+// idleExpired return true if channel idle timeout expired, def is default
+// idle timeout
+func (c *Channel) idleExpired(def time.Duration) bool {
+	idle := c.stat.idleTimeout(def)
+	return idle > 0 && time.Since(c.stat.activity()) > idle
+}
+
+// sendHeartbeat send heartbeat with current time to channel
+func (teo *Teonet) sendHeartbeat(c *Channel) {
+	data := binary.LittleEndian.AppendUint64(nil, uint64(time.Now().UnixNano()))
+	if _, err := c.truChannel().WriteTo(c.frame(frameHeartbeat, data)); err != nil {
+		log.Debugv.Println("can't send heartbeat to", c, "error:", err)
+	}
+}
+
+// processHeartbeat check received message and answer to heartbeat or save
+// heartbeat round trip time. Returns true if message processed
+func (teo *Teonet) processHeartbeat(c *Channel, t frameType, p *Packet) (ok bool) {
+	switch t {
+	// Heartbeat received, send answer with the same time
+	case frameHeartbeat:
+		c.truChannel().WriteTo(c.frame(frameHeartbeatAnswer, p.Data()))
+
+	// Heartbeat answer received
+	case frameHeartbeatAnswer:
+		if data := p.Data(); len(data) == heartbeatLen {
+			sent := int64(binary.LittleEndian.Uint64(data))
+			c.stat.heartbeatReceived(time.Since(time.Unix(0, sent)))
+		}
+
+	default:
+		return
+	}
+	return true
+}
+
+// evict disconnect one peer selected by EvictPolicy if MaxPeers limit reached
+func (teo *Teonet) evict() {
+	var k = teo.keeper
+	if k.maxPeers <= 0 {
+		return
+	}
+	chs := teo.channels.peersChannels()
+	if len(chs) < k.maxPeers {
+		return
+	}
+
+	var victim *Channel
+	for _, c := range chs {
+		if victim == nil {
+			victim = c
+			continue
+		}
+		switch k.policy {
+		case EvictOldest:
+			if c.stat.created.Before(victim.stat.created) {
+				victim = c
+			}
+		default:
+			if c.stat.activity().Before(victim.stat.activity()) {
+				victim = c
+			}
+		}
+	}
+	teo.disconnect(victim, ErrEvicted)
+}
+
+// disconnect send EventDisconnected with reason to readers and close channel.
+// Peers disconnected by idle timeout or peers limit does not reconnected
+func (teo *Teonet) disconnect(c *Channel, reason error) {
+	log.Connect.Println("disconnect peer", c.a, "reason:", reason)
+	if reason != ErrHeartbeatLost {
+		c.closing = true
+	}
+	// Main reader delete channel because event contains error
+	reader(teo, c, nil, &Event{EventDisconnected, reason})
+	c.truChannel().Close()
+}
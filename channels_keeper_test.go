@@ -0,0 +1,219 @@
+// Test of channels keeper
+package teonet
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/teonet-go/tru"
+)
+
+// keeperEvents collect disconnect reasons of server channels by address
+type keeperEvents struct {
+	reasons map[string]error
+	sync.Mutex
+}
+
+// subscribe to server channel disconnect events
+func (k *keeperEvents) subscribe(t *testing.T, srv *Teonet, addr string) {
+	_, err := srv.Subscribe(addr, func(c *Channel, p *Packet, e *Event) bool {
+		if e.Event == EventDisconnected {
+			k.Lock()
+			k.reasons[c.Address()] = e.Err
+			k.Unlock()
+		}
+		return false
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// reason return disconnect reason of channel
+func (k *keeperEvents) reason(addr string) (err error, ok bool) {
+	k.Lock()
+	defer k.Unlock()
+	err, ok = k.reasons[addr]
+	return
+}
+
+// linkPeers connect client to server by tru and set teonet channels
+// connected on both sides without teonet auth server
+func linkPeers(t *testing.T, cli, srv *Teonet) {
+	c, s := truLink(t, cli, srv)
+	cli.SetConnected(c, srv.Address())
+	srv.SetConnected(s, cli.Address())
+}
+
+// truLink connect client to server by tru and return not connected teonet
+// channels of client and server
+func truLink(t *testing.T, cli, srv *Teonet) (c, s *Channel) {
+	tc, err := cli.tru.Connect(fmt.Sprintf("127.0.0.1:%d", srv.Port()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sc *tru.Channel
+	port := fmt.Sprintf(":%d", cli.Port())
+	srv.tru.ForEachChannel(func(ch *tru.Channel) {
+		if strings.HasSuffix(ch.Addr().String(), port) {
+			sc = ch
+		}
+	})
+	if sc == nil {
+		t.Fatal("server tru channel does not found")
+	}
+	c, s = cli.channels.new(tc), srv.channels.new(sc)
+	c.setCaps(localCaps)
+	s.setCaps(localCaps)
+	return
+}
+
+// newKeeperServer create server with keeper parameters and connect clients
+// to it. Keeper ticker does not fire during test, checks executes by test
+func newKeeperServer(t *testing.T, clients int, attr ...interface{}) (
+	srv *Teonet, clis []*Teonet, events *keeperEvents) {
+
+	attr = append(attr, OsConfigDir(t.TempDir()), HeartbeatInterval(time.Hour))
+	srv, err := New("test-server", attr...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Close)
+
+	events = &keeperEvents{reasons: make(map[string]error)}
+	for i := 0; i < clients; i++ {
+		cli := connectKeeperClient(t, srv)
+		events.subscribe(t, srv, cli.Address())
+		clis = append(clis, cli)
+	}
+	return
+}
+
+// connectKeeperClient create client and connect it to server
+func connectKeeperClient(t *testing.T, srv *Teonet) *Teonet {
+	cli, err := New("test-client", OsConfigDir(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cli.Close)
+	linkPeers(t, cli, srv)
+	return cli
+}
+
+// serverChannel get server channel of client
+func serverChannel(t *testing.T, srv, cli *Teonet) *Channel {
+	c, ok := srv.Channel(cli.Address())
+	if !ok {
+		t.Fatal("client does not connected")
+	}
+	return c
+}
+
+func TestChannelsKeeper(t *testing.T) {
+
+	// Peer selected by eviction policy disconnected when peers limit reached
+	policies := map[EvictPolicy]int{EvictLRU: 1, EvictOldest: 0}
+	for policy, victim := range policies {
+		policy, victim := policy, victim
+		t.Run(fmt.Sprint("Evict", policy), func(t *testing.T) {
+			srv, clis, events := newKeeperServer(t, 2, MaxPeers(2), policy)
+
+			// First client is oldest, second is least recently used
+			c0, c1 := serverChannel(t, srv, clis[0]), serverChannel(t, srv, clis[1])
+			c1.stat.Lock()
+			c1.stat.lastActivity = time.Now().Add(-time.Minute)
+			c1.stat.Unlock()
+			c0.stat.Lock()
+			c0.stat.created = c1.stat.created.Add(-time.Minute)
+			c0.stat.Unlock()
+
+			connectKeeperClient(t, srv)
+			addr := clis[victim].Address()
+			if err, _ := events.reason(addr); err != ErrEvicted {
+				t.Errorf("peer %d does not evicted, reason: %v", victim, err)
+			}
+			if _, ok := srv.Channel(addr); ok {
+				t.Error("evicted peer still connected")
+			}
+			if []*Channel{c0, c1}[victim].context().Err() == nil {
+				t.Error("evicted channel context does not canceled")
+			}
+			if _, ok := srv.Channel(clis[1-victim].Address()); !ok {
+				t.Error("wrong peer disconnected")
+			}
+		})
+	}
+
+	// Channel idle timeout overrides teonet idle timeout
+	t.Run("Idle", func(t *testing.T) {
+		srv, clis, events := newKeeperServer(t, 2, IdleTimeout(time.Hour))
+		c0, c1 := serverChannel(t, srv, clis[0]), serverChannel(t, srv, clis[1])
+		c0.SetIdleTimeout(50 * time.Millisecond)
+		if c0.IdleTimeout() != 50*time.Millisecond || c1.IdleTimeout() != time.Hour {
+			t.Errorf("wrong idle timeouts: %v %v", c0.IdleTimeout(), c1.IdleTimeout())
+		}
+
+		time.Sleep(100 * time.Millisecond)
+		srv.keeperCheck()
+		if err, _ := events.reason(clis[0].Address()); err != ErrIdleTimeout {
+			t.Errorf("idle peer does not disconnected, reason: %v", err)
+		}
+		if _, ok := events.reason(clis[1].Address()); ok {
+			t.Error("peer disconnected before idle timeout")
+		}
+
+		// Channel without idle timeout
+		c1.SetIdleTimeout(0)
+		c1.stat.Lock()
+		c1.stat.lastActivity = time.Now().Add(-2 * time.Hour)
+		c1.stat.Unlock()
+		srv.keeperCheck()
+		if _, ok := events.reason(clis[1].Address()); ok {
+			t.Error("peer without idle timeout disconnected")
+		}
+	})
+
+	// Heartbeats measure rtt, peer disconnected when heartbeats lost
+	t.Run("Heartbeat", func(t *testing.T) {
+		srv, clis, events := newKeeperServer(t, 1)
+		c := serverChannel(t, srv, clis[0])
+		for i := 0; i < 3; i++ {
+			srv.keeperCheck()
+		}
+		for start := time.Now(); len(c.RTTHistory()) < 3; {
+			if time.Since(start) > time.Second {
+				t.Fatalf("wrong rtt history: %v", c.RTTHistory())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		for _, rtt := range c.RTTHistory() {
+			if rtt <= 0 || rtt > time.Second {
+				t.Errorf("wrong rtt: %v", rtt)
+			}
+		}
+
+		// Answers lost
+		c.stat.Lock()
+		c.stat.hbMissed = heartbeatLostAfter
+		c.stat.Unlock()
+		srv.keeperCheck()
+		if err, _ := events.reason(clis[0].Address()); err != ErrHeartbeatLost {
+			t.Errorf("wrong heartbeat lost reason: %v", err)
+		}
+	})
+
+	// History ring buffer keeps last values from oldest to newest
+	t.Run("History", func(t *testing.T) {
+		s := newChannelStat()
+		for i := 1; i <= rttHistoryLen+2; i++ {
+			s.addRTT(time.Duration(i))
+		}
+		h := s.history()
+		if len(h) != rttHistoryLen || h[0] != 3 || h[len(h)-1] != rttHistoryLen+2 {
+			t.Errorf("wrong history: %v", h)
+		}
+	})
+}
@@ -0,0 +1,64 @@
+// Copyright 2023 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet client api module: client application publishes api commands which
+// servers it connected to discover by CmdClientAPI command and execute, f.e.
+// server asks connected client to upload its logs.
+//
+// Client creates api with NewClientAPI, adds commands and readers the same
+// way as in server api. Server gets connected clients by Clients function
+// and executes client commands by APIClient returned by ClientAPI function.
+
+package teonet
+
+// NewClientAPI create new teonet client api. The client api commands execute
+// when they received from servers this application connected to. Add the
+// api reader to teonet readers to process commands:
+//
+//	api := teo.NewClientAPI("My client", "myclient", "my client api", "0.0.1")
+//	api.Add(MakeAPI2().SetName("logs").SetCmd(api.Cmd(129)). ...)
+//	teo.AddReader(api.Reader())
+func (teo *Teonet) NewClientAPI(name, short, long, version string) (api *API) {
+	api = teo.NewAPI(name, short, long, version, CmdClientAPI)
+	api.client = true
+	return
+}
+
+// ClientAPI create APIClient of connected client api. The attr parameters
+// are the same as in NewAPIClient function
+func (teo *Teonet) ClientAPI(address string, attr ...interface{}) (
+	apicli *APIClient, err error) {
+	attr = append([]interface{}{byte(CmdClientAPI)}, attr...)
+	return teo.NewAPIClient(address, attr...)
+}
+
+// Clients return addresses of clients connected to this peer
+func (teo Teonet) Clients() (clients []string) {
+	for _, c := range teo.channels.peersChannels() {
+		if c.ServerMode() && !c.IsNew() {
+			clients = append(clients, c.Address())
+		}
+	}
+	return
+}
+
+// ownChannel return true if channel connects api side: server api executes
+// commands received from clients and client api executes commands received
+// from servers
+func (a API) ownChannel(c *Channel) bool {
+	if a.client {
+		return c.ClientMode()
+	}
+	return c.ServerMode()
+}
+
+// clientMode set client connect mode to commands with default server connect
+// mode, the client api commands execute when received from servers
+func clientMode(cmds []APInterface) {
+	for i := range cmds {
+		if a, ok := cmds[i].(*APIData); ok && a.connectMode == ServerMode {
+			a.connectMode = ClientMode
+		}
+	}
+}
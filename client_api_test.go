@@ -0,0 +1,69 @@
+// Test of client api
+package teonet
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestClientAPI(t *testing.T) {
+	cli, srv, addr := newLocalPeers(t)
+
+	// Both peers have server api, client publishes client api
+	for _, teo := range []*Teonet{cli, srv} {
+		api := teo.NewAPI("server", "server", "server api", "0.0.1")
+		api.Add(MakeAPI2().SetName("hello").SetCmd(129).
+			SetReader(func(c *Channel, p *Packet, data []byte) bool {
+				c.Reply(p, []byte("hello"))
+				return true
+			}))
+		teo.AddReader(api.Reader())
+	}
+	api := cli.NewClientAPI("client", "client", "client api", "0.0.1")
+	api.Add(MakeAPI2().SetName("logs").SetCmd(130).
+		SetReader(func(c *Channel, p *Packet, data []byte) bool {
+			c.Reply(p, append([]byte("logs "), data...))
+			return true
+		}))
+	cli.AddReader(api.Reader())
+
+	clients := srv.Clients()
+	if len(clients) != 1 || clients[0] != cli.Address() {
+		t.Fatalf("wrong clients: %v", clients)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	t.Run("Call", func(t *testing.T) {
+		client, err := srv.ClientAPI(clients[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		if client.AppShort() != "client" {
+			t.Errorf("wrong client api: %s", client.AppShort())
+		}
+		reply, err := client.Request(ctx, "logs", []byte("today"))
+		if err != nil || string(reply) != "logs today" {
+			t.Errorf("wrong reply: %s, err: %v", reply, err)
+		}
+	})
+
+	// Client commands does not executed by clients, server api still works
+	t.Run("Direction", func(t *testing.T) {
+		_, err := cli.RequestAPI(ctx, addr, Command{Cmd: 130}, CmdAnswer)
+		if !isAPIError(err, APIErrUnknownCommand) {
+			t.Errorf("client command executed by server, err: %v", err)
+		}
+		reply, err := cli.RequestAPI(ctx, addr, Command{Cmd: 129}, CmdAnswer)
+		if err != nil || string(reply) != "hello" {
+			t.Errorf("wrong server reply: %s, err: %v", reply, err)
+		}
+		_, err = srv.RequestAPI(ctx, clients[0], Command{Cmd: 131}, CmdAnswer)
+		if !isAPIError(err, APIErrUnknownCommand) {
+			t.Errorf("wrong unknown client command error: %v", err)
+		}
+	})
+}
@@ -23,31 +23,50 @@ func (teo Teonet) AddReader(reader TreceivecbShort) {
 	teo.clientReaders.addShort(reader)
 }
 
-// add teonet client reader
-func (c *clientReaders) add(reader Treceivecb) {
+// add teonet client reader and return its index. Slots of removed readers
+// are reused so the readers slice does not grow on add and del
+func (c *clientReaders) add(reader Treceivecb) (idx int) {
 	c.Lock()
 	defer c.Unlock()
+	for idx = range c.clientReaders {
+		if c.clientReaders[idx] == nil {
+			c.clientReaders[idx] = reader
+			return
+		}
+	}
 	c.clientReaders = append(c.clientReaders, reader)
+	return len(c.clientReaders) - 1
 }
 
-// addShort add teonet client short reader
-func (c *clientReaders) addShort(reader TreceivecbShort) {
-	c.add(func(teo *Teonet, c *Channel, p *Packet, e *Event) bool {
+// addShort add teonet client short reader and return its index
+func (c *clientReaders) addShort(reader TreceivecbShort) (idx int) {
+	return c.add(func(teo *Teonet, c *Channel, p *Packet, e *Event) bool {
 		return reader(c, p, e)
 	})
 }
 
+// del remove teonet client reader by index
+func (c *clientReaders) del(idx int) {
+	c.Lock()
+	defer c.Unlock()
+	if idx >= 0 && idx < len(c.clientReaders) {
+		c.clientReaders[idx] = nil
+	}
+}
+
 // send to client readers (to reader from teonet.Init)
 func (c *clientReaders) send(teo *Teonet, ch *Channel, p *Packet, e *Event) bool {
 	c.RLock()
-	for i := range c.clientReaders {
-		if c.clientReaders[i] != nil {
-			c.RUnlock()
-			if c.clientReaders[i](teo, ch, p, e) {
-				return true
-			}
-			c.RLock()
+	for i := 0; i < len(c.clientReaders); i++ {
+		reader := c.clientReaders[i]
+		if reader == nil {
+			continue
+		}
+		c.RUnlock()
+		if reader(teo, ch, p, e) {
+			return true
 		}
+		c.RLock()
 	}
 	c.RUnlock()
 	return false
@@ -0,0 +1,47 @@
+// Test of teonet client readers
+package teonet
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestClientReaders(t *testing.T) {
+	c := new(clientReaders)
+	nop := func(teo *Teonet, c *Channel, p *Packet, e *Event) bool { return false }
+
+	t.Run("ReuseSlots", func(t *testing.T) {
+		idx := c.add(nop)
+		c.add(nop)
+		c.del(idx)
+		if i := c.add(nop); i != idx {
+			t.Errorf("removed slot does not reused: %d, want %d", i, idx)
+		}
+		for i := 0; i < 100; i++ {
+			c.del(c.add(nop))
+		}
+		if l := len(c.clientReaders); l != 3 {
+			t.Errorf("wrong readers slice length: %d", l)
+		}
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 1000; j++ {
+					c.del(c.add(nop))
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 1000; j++ {
+					c.send(nil, nil, nil, nil)
+				}
+			}()
+		}
+		wg.Wait()
+	})
+}
@@ -4,10 +4,13 @@ package main
 import (
 	"flag"
 	"fmt"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/teonet-go/teomon"
 	"github.com/teonet-go/teonet"
+	"github.com/teonet-go/teonet/wsapi"
 )
 
 const (
@@ -34,6 +37,11 @@ func Commands(teo *teonet.Teonet, api *teonet.API) {
 					data = append([]byte("Hello "), data...)
 					api.SendAnswer(cmdApi, c, data, p)
 					return true
+				}).
+				// Second reader (execute when command received from websocket)
+				SetReader2(func(data []byte, answer func(data []byte)) bool {
+					api.SendAnswer2(append([]byte("Hello "), data...), answer)
+					return true
 				}).SetAnswerMode( /* teonet.CmdAnswer | */ teonet.DataAnswer)
 			return cmdApi
 		}(teonet.APIData{}),
@@ -49,6 +57,11 @@ func Commands(teo *teonet.Teonet, api *teonet.API) {
 					ret := []byte(appName)
 					api.SendAnswer(cmdApi, c, ret, p)
 					return true
+				}).
+				// Second reader (execute when command received from websocket)
+				SetReader2(func(data []byte, answer func(data []byte)) bool {
+					api.SendAnswer2([]byte(appName), answer)
+					return true
 				})
 			return cmdApi
 		}(teonet.APIData{}),
@@ -65,7 +78,9 @@ func Commands(teo *teonet.Teonet, api *teonet.API) {
 					ret := []byte("this is very strong secret key: ququruqu")
 					api.SendAnswer(cmdApi, c, ret, p)
 					return true
-				}).SetAnswerMode( /* teonet.CmdAnswer | */ teonet.PacketIDAnswer)
+				}).SetAnswerMode( /* teonet.CmdAnswer | */ teonet.PacketIDAnswer).
+				// Allow this command to peers with admin role only
+				SetAccess(teonet.AccessPolicy{Roles: []string{"admin"}})
 			return cmdApi
 		}(teonet.APIData{}),
 	)
@@ -83,9 +98,14 @@ func main() {
 		logfilter string
 		monitor   string
 		connectTo string
+		ws        string
+		wsOrigins string
+		admins    string
+		registry  string
 		hotkey    bool
 		stat      bool
 		port      int
+		workers   int
 	}
 	flag.StringVar(&p.appShort, "name", appShort, "application short name")
 	flag.IntVar(&p.port, "p", 0, "local port")
@@ -95,23 +115,53 @@ func main() {
 	flag.StringVar(&p.loglevel, "loglevel", "NONE", "log level")
 	flag.StringVar(&p.logfilter, "logfilter", "", "log filter")
 	flag.StringVar(&p.monitor, "monitor", "", "monitor address")
+	flag.StringVar(&p.ws, "ws", "", "websocket server address, i.e. ':8080'")
+	flag.StringVar(&p.wsOrigins, "ws-origin", "", "comma separated allowed websocket origins")
+	flag.StringVar(&p.admins, "admin", "", "comma separated addresses of peers with admin role")
+	flag.StringVar(&p.registry, "registry", "", "services registry address to register api")
+	flag.IntVar(&p.workers, "workers", 0, "number of readers workers, readers execute in receive goroutine by default")
 	flag.Parse()
 
 	// Start teonet (client or server)
 	teo, err := teonet.New(p.appShort, p.port, teonet.Stat(p.stat),
-		teonet.Hotkey(p.hotkey), p.loglevel, teonet.Logfilter(p.logfilter))
+		teonet.Hotkey(p.hotkey), p.loglevel, teonet.Logfilter(p.logfilter),
+		teonet.DispatchWorkers(p.workers))
 	if err != nil {
 		panic("can't init Teonet, error: " + err.Error())
 	}
 
-	// Create new API, add commands and reader
-	api := teo.NewAPI(appName, appShort, appLong, appVersion)
+	// Create new API with built-in commands (ping, version, uptime, health,
+	// peers and stats), add commands and reader
+	api := teo.NewAPI(appName, appShort, appLong, appVersion,
+		teonet.APIBuiltin(true))
 	Commands(teo, api)
 	teo.AddReader(api.Reader())
 
+	// Set admin role to peers, they can execute 'secret' command
+	if len(p.admins) > 0 {
+		for _, address := range strings.Split(p.admins, ",") {
+			api.SetRoles(address, "admin")
+		}
+	}
+
 	// Print API
 	fmt.Printf("API description:\n\n%s\n\n", api.Help())
 
+	// Start websocket server. Browsers send [cmd][data] binary messages to
+	// ws://host:port/ws and receive answers in the same format
+	if len(p.ws) > 0 {
+		var origins wsapi.Origins
+		if len(p.wsOrigins) > 0 {
+			origins = strings.Split(p.wsOrigins, ",")
+		}
+		http.Handle("/ws", wsapi.New(api.Reader2(), origins))
+		go func() {
+			err := http.ListenAndServe(p.ws, nil)
+			fmt.Println("websocket server error:", err)
+		}()
+		fmt.Printf("Websocket server: %s/ws\n\n", p.ws)
+	}
+
 	// Connect to teonet
 	for teo.Connect() != nil {
 		time.Sleep(1 * time.Second)
@@ -120,6 +170,16 @@ func main() {
 	// Teonet address
 	fmt.Printf("Teonet address: %s\n\n", teo.Address())
 
+	// Register api in services registry, clients find this peer by api name
+	if len(p.registry) > 0 {
+		if err := teo.ConnectTo(p.registry); err != nil {
+			fmt.Println("can't connect to registry, error:", err)
+		}
+		if err := api.Register(p.registry); err != nil {
+			fmt.Println("can't register api, error:", err)
+		}
+	}
+
 	// Connect to monitor
 	if len(p.monitor) > 0 {
 		teomon.Connect(teo, p.monitor, teomon.Metric{
@@ -115,7 +115,7 @@ func main() {
 
 	// Test #2: Create Teonet client API interface -----------------------------
 	apicli, _ := teo.NewAPIClient(p.connectTo)
-	teo.Log().Debug.Printf("\n\n===> Test #2: Create API interface and Get servers APIData, Name: %s\n\n", apicli.Apis[0].Name())
+	teo.Log().Debug.Printf("\n\n===> Test #2: Create API interface and Get servers APIData, Name: %s\n\n", apicli.Snapshot().Apis[0].Name())
 
 	// Test #3: Send commands by number ----------------------------------------
 	teo.Log().Debug.Printf("===> Test #3: Send commands by number\n\n")
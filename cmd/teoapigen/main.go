@@ -0,0 +1,65 @@
+// Teonet api client generator application. It reads binary API description
+// file and writes typed Go client package. The description file may be
+// created by teonet CLI 'api -export bin -o <file> <address>' command or
+// taken from teonet api cache.
+//
+// Use it with go generate:
+//
+//	//go:generate go run github.com/teonet-go/teonet/cmd/teoapigen -in teoapi.bin -pkg teoapi -out client.go
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/teonet-go/teonet"
+	"github.com/teonet-go/teonet/apigen"
+)
+
+func main() {
+
+	// Parse applications flags
+	var p struct {
+		in  string
+		pkg string
+		out string
+	}
+	flag.StringVar(&p.in, "in", "", "binary api description file")
+	flag.StringVar(&p.pkg, "pkg", "", "generated package name, api short name by default")
+	flag.StringVar(&p.out, "out", "", "output file, stdout by default")
+	flag.Parse()
+
+	if p.in == "" {
+		fmt.Fprintln(os.Stderr, "Flag -in should be set")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// Read api description
+	data, err := os.ReadFile(p.in)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "can't read api description, error:", err)
+		os.Exit(1)
+	}
+	var ar teonet.APIDataAr
+	if err = ar.UnmarshalBinary(data); err != nil {
+		fmt.Fprintln(os.Stderr, "wrong api description, error:", err)
+		os.Exit(1)
+	}
+
+	// Generate client
+	code, err := apigen.Generate(ar, p.pkg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "can't generate client, error:", err)
+		os.Exit(1)
+	}
+	if p.out == "" {
+		os.Stdout.Write(code)
+		return
+	}
+	if err = os.WriteFile(p.out, code, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, "can't write client, error:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,131 @@
+// Teonet HTTP/JSON gateway application. It connects to teonet peers and
+// exposes their API commands as REST endpoints:
+//
+//	GET  /                  - index of available peers APIs
+//	GET  /{alias}           - peer API in OpenAPI style JSON document
+//	POST /{alias}/{command} - execute peer API command
+//
+// Peers sets by -peer alias=address flags. Peers in local network may be
+// connected without teonet auth server by -direct alias=ip:port flags.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/teonet-go/teonet"
+	"github.com/teonet-go/teonet/gateway"
+)
+
+const (
+	appShort   = "teogw"
+	appName    = "Teonet HTTP/JSON gateway application"
+	appVersion = teonet.Version
+)
+
+func main() {
+
+	// Application logo
+	teonet.Logo(appName, appVersion)
+
+	// Parse applications flags
+	var p struct {
+		appShort  string
+		port      int
+		stat      bool
+		logLevel  string
+		logFilter string
+		http      string
+		timeout   time.Duration
+		peers     peersFlag
+		direct    peersFlag
+	}
+	flag.StringVar(&p.appShort, "name", appShort, "application short name")
+	flag.IntVar(&p.port, "p", 0, "local port")
+	flag.BoolVar(&p.stat, "stat", false, "show trudp statistic")
+	flag.StringVar(&p.http, "http", ":8080", "http server address")
+	flag.DurationVar(&p.timeout, "timeout", gateway.DefaultTimeout, "command answer timeout")
+	flag.Var(&p.peers, "peer", "peer alias=address, may be repeated")
+	flag.Var(&p.direct, "direct", "direct connected peer alias=ip:port, may be repeated")
+	flag.StringVar(&p.logLevel, "loglevel", "NONE", "log level")
+	flag.StringVar(&p.logFilter, "logfilter", "", "log filter")
+	flag.Parse()
+
+	if len(p.peers) == 0 && len(p.direct) == 0 {
+		fmt.Println("Flag -peer or -direct should be set")
+		flag.Usage()
+		return
+	}
+
+	// Start teonet client
+	teo, err := teonet.New(p.appShort, p.port, teonet.Stat(p.stat),
+		p.logLevel, teonet.Logfilter(p.logFilter))
+	if err != nil {
+		panic("can't init Teonet, error: " + err.Error())
+	}
+	defer teo.Close()
+
+	gw := gateway.New(teo, p.timeout)
+
+	// Connect to direct peers
+	for _, peer := range p.direct {
+		addr, err := teo.ConnectDirect(peer.address)
+		if err != nil {
+			teo.Log().Error.Println("can't connect to", peer.address, "error:", err)
+			continue
+		}
+		if err = gw.AddPeer(peer.alias, addr); err != nil {
+			teo.Log().Error.Println("can't get", peer.alias, "api, error:", err)
+		}
+	}
+
+	// Connect to teonet and peers
+	if len(p.peers) > 0 {
+		for teo.Connect() != nil {
+			time.Sleep(1 * time.Second)
+		}
+		fmt.Printf("Teonet address: %s\n\n", teo.Address())
+	}
+	for _, peer := range p.peers {
+		for {
+			err := teo.ConnectTo(peer.address)
+			if err == nil {
+				break
+			}
+			teo.Log().Debug.Println("can't connect to", peer.alias, "error:", err)
+			time.Sleep(1 * time.Second)
+		}
+		if err = gw.AddPeer(peer.alias, peer.address); err != nil {
+			teo.Log().Error.Println("can't get", peer.alias, "api, error:", err)
+		}
+	}
+
+	// Start http server
+	fmt.Printf("Gateway listen at %s\n", p.http)
+	if err = http.ListenAndServe(p.http, gw); err != nil {
+		fmt.Println("http server error:", err)
+	}
+}
+
+// peersFlag is alias=address flags list
+type peersFlag []struct{ alias, address string }
+
+func (f *peersFlag) String() string {
+	var s []string
+	for _, p := range *f {
+		s = append(s, p.alias+"="+p.address)
+	}
+	return strings.Join(s, ",")
+}
+
+func (f *peersFlag) Set(value string) error {
+	alias, address, ok := strings.Cut(value, "=")
+	if !ok || alias == "" || address == "" {
+		return fmt.Errorf("wrong peer %q, should be alias=address", value)
+	}
+	*f = append(*f, struct{ alias, address string }{alias, address})
+	return nil
+}
@@ -19,6 +19,7 @@ import (
 
 	"github.com/teonet-go/teomon"
 	"github.com/teonet-go/teonet"
+	"github.com/teonet-go/teonet/apigen"
 	"github.com/teonet-go/teonet/cmd/teonet/menu"
 )
 
@@ -203,17 +204,25 @@ func (c CmdAPI) Help() string  { return "get peers api" }
 func (c CmdAPI) Exec(line string) (err error) {
 	flags := c.NewFlagSet(c.Name(), c.Usage(), c.Help())
 	var (
-		list     bool // list flag
-		appshort bool // app short name flag
-		appname  bool // app name flag
-		applong  bool // app long name flag
-		wallet   bool // wallet flag
+		list     bool   // list flag
+		appshort bool   // app short name flag
+		appname  bool   // app name flag
+		applong  bool   // app long name flag
+		wallet   bool   // wallet flag
+		export   string // export format flag
+		gen      string // generate client language flag
+		pkg      string // generated package name flag
+		out      string // output file flag
 	)
 	flags.BoolVar(&list, "list", list, "list all connected api")
 	flags.BoolVar(&appshort, "short", appshort, "get application short name")
 	flags.BoolVar(&appname, "name", appname, "get application name")
 	flags.BoolVar(&applong, "long", applong, "get application description")
 	flags.BoolVar(&wallet, "wallet", wallet, "this application wallet parameters")
+	flags.StringVar(&export, "export", export, "export api in format: json|md|bin")
+	flags.StringVar(&gen, "gen", gen, "generate api client in language: go")
+	flags.StringVar(&pkg, "pkg", pkg, "generated client package name")
+	flags.StringVar(&out, "o", out, "export or generate to file")
 	err = flags.Parse(c.menu.SplitSpace(line))
 	if err != nil {
 		return
@@ -246,7 +255,7 @@ func (c CmdAPI) Exec(line string) (err error) {
 	var address = c.alias.Address(args[0])
 	apiClient, ok := c.api.get(address)
 	if !ok {
-		apiClient, err = c.teo.NewAPIClient(address)
+		apiClient, err = c.teo.NewAPIClient(address, teonet.APICache(true))
 		if err != nil {
 			fmt.Printf("can't get api %s, error: %s\n", address, err)
 			if err == teonet.ErrPeerNotConnected {
@@ -254,6 +263,10 @@ func (c CmdAPI) Exec(line string) (err error) {
 			}
 			return nil
 		}
+		apiClient.OnAPIChanged(func(added, removed []string) {
+			fmt.Printf("\napi %s changed, added: %v, removed: %v\n", address,
+				added, removed)
+		})
 		c.api.add(address, apiClient)
 	}
 	// Extend APIClient with wallet commands
@@ -282,6 +295,37 @@ func (c CmdAPI) Exec(line string) (err error) {
 		case wallet:
 			fmt.Printf("%s\n", api.UserField.(*walletCommands).AppWalletUsage())
 
+		// Process -export flag
+		case export == "json":
+			data, err := api.Snapshot().ExportJSON()
+			if err != nil {
+				fmt.Println("can't export api, error:", err)
+				return nil
+			}
+			fmt.Printf("%s\n", data)
+		case export == "md":
+			fmt.Print(api.Snapshot().ExportMarkdown())
+		case export == "bin":
+			if out == "" {
+				fmt.Println("flag -o should be set to export api in binary format")
+				return nil
+			}
+			data, _ := api.Snapshot().MarshalBinary()
+			writeOut(out, data)
+		case export != "":
+			fmt.Println("wrong export format, use: json|md|bin")
+
+		// Process -gen flag
+		case gen == "go":
+			code, err := apigen.Generate(api.Snapshot(), pkg)
+			if err != nil {
+				fmt.Println("can't generate api client, error:", err)
+				return nil
+			}
+			writeOut(out, code)
+		case gen != "":
+			fmt.Println("wrong generate language, use: go")
+
 		// Print api commands
 		default:
 			fmt.Print(api.String() + "\n")
@@ -305,6 +349,15 @@ func (c CmdAPI) Exec(line string) (err error) {
 			}
 			data = append(data, []byte(v)...)
 		}
+		// Encode arguments by command parameters schema
+		if params, ok := api.Params(command); ok &&
+			params.Encoding != teonet.EncodingRaw {
+			data, err = encodeArgs(api, command, params, args[2:])
+			if err != nil {
+				fmt.Println("wrong arguments, error:", err)
+				return nil
+			}
+		}
 	}
 	// Send no answer command
 	if answerMode, ok := api.AnswerMode(command); ok && answerMode == teonet.NoAnswer {
@@ -323,7 +376,11 @@ func (c CmdAPI) Exec(line string) (err error) {
 		if err != nil {
 			// fmt.Println("got error:", err)
 		} else {
-			if ret, ok := api.Return(command); ok {
+			if values, err := api.DecodeReturn(command, data); err == nil {
+				// Print answer decoded by command return data schema
+				out, _ := json.MarshalIndent(values, "", "  ")
+				fmt.Println(string(out))
+			} else if ret, ok := api.Return(command); ok {
 				switch {
 				case strings.Contains(ret, "string"):
 					fmt.Println(string(data))
@@ -362,9 +419,54 @@ Wait:
 
 	return
 }
+
+// writeOut write data to file or print it if file name is empty
+func writeOut(file string, data []byte) {
+	if file == "" {
+		fmt.Printf("%s\n", data)
+		return
+	}
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		fmt.Println("can't write file, error:", err)
+		return
+	}
+	fmt.Println("saved to", file)
+}
+
+// encodeArgs encode command line arguments by command parameters schema.
+// Arguments may be in 'name=value' format or values in schema fields order
+func encodeArgs(api *teonet.APIClient, command string, params *teonet.Schema,
+	args []string) (data []byte, err error) {
+
+	values := make(map[string]interface{})
+	for i, arg := range args {
+		if name, value, ok := strings.Cut(arg, "="); ok && hasField(params, name) {
+			values[name] = value
+			continue
+		}
+		if i >= len(params.Fields) {
+			err = errors.New("too many arguments")
+			return
+		}
+		values[params.Fields[i].Name] = arg
+	}
+	return api.EncodeArgs(command, values)
+}
+
+// hasField return true if schema has field with name
+func hasField(s *teonet.Schema, name string) bool {
+	for i := range s.Fields {
+		if s.Fields[i].Name == name {
+			return true
+		}
+	}
+	return false
+}
+
 func (c CmdAPI) Compliter() (cmpl []menu.Compliter) {
 	return c.menu.MakeCompliterFromString([]string{
-		"-list", "-short", "-name", "-long", "-wallet",
+		"-list", "-short", "-name", "-long", "-wallet", "-export", "-gen",
+		"-pkg", "-o",
 	})
 }
 
@@ -7,15 +7,35 @@
 package teonet
 
 import (
+	"encoding/binary"
 	"errors"
+	"strconv"
 )
 
 // Error command packet too short
 var ErrCommandTooShort = errors.New("command packet too short")
 
+// CmdExtended is escape command byte: the extended command number (ExtCmd)
+// follows it in two bytes. The escape byte is used with peers which support
+// extended commands only, old peers send and receive legacy one byte command
+// 253 as before. Applications which used command 253 should move it to other
+// number before both peers upgraded: between upgraded peers command 253 is
+// always read as extended command
+const CmdExtended = 253
+
+// ExtCmd is extended command number. It sends as CmdExtended byte followed
+// by uint16 command number, so applications and libraries have 65536
+// commands in addition to one byte commands
+type ExtCmd uint16
+
+// CmdReservedFirst is the first extended command number reserved for teonet
+// services. Applications should not use extended commands from this number
+const CmdReservedFirst ExtCmd = 0xFF00
+
 // Command struct and method receiver
 type Command struct {
 	Cmd  byte
+	Ext  ExtCmd // Extended command number, used if Cmd is CmdExtended
 	Data []byte
 	teo  *Teonet
 }
@@ -26,7 +46,7 @@ type Command struct {
 //	    command & data slice - []byte
 //
 //	2 parameters
-//	    command  - AuthCmd | byte | int
+//	    command  - AuthCmd | byte | int | ExtCmd
 //	    data     - []byte | string | nil
 func (teo *Teonet) Command(attr ...interface{}) (cmd *Command) {
 
@@ -48,6 +68,8 @@ func (teo *Teonet) Command(attr ...interface{}) (cmd *Command) {
 			cmd.Cmd = c
 		case int:
 			cmd.Cmd = byte(c)
+		case ExtCmd:
+			cmd.Cmd, cmd.Ext = CmdExtended, c
 		default:
 			panic("wrong cmd attribute")
 		}
@@ -78,20 +100,45 @@ func (c Command) Send(channel *Channel, attr ...interface{}) (id int, err error)
 	if len(attr) > 0 {
 		attr = append([]interface{}{c.teo}, attr...)
 	}
-	return channel.Send(c.Bytes(), attr...)
+	return channel.Send(channel.commandBytes(c), attr...)
 }
 
 // SendTo send command to channel by address
 func (c Command) SendTo(addr string, attr ...interface{}) (id int, err error) {
-	// Add teo to attr, it need for subscribe to answer
-	if len(attr) > 0 {
-		attr = append([]interface{}{c.teo}, attr...)
+	channel, ok := c.teo.channels.get(addr)
+	if !ok {
+		err = ErrPeerNotConnected
+		return
+	}
+	return c.Send(channel, attr...)
+}
+
+// command unmarshal command received from channel peer. Old peers send one
+// byte commands only, so CmdExtended received from them is legacy command
+// without extended number
+func (c Channel) command(data []byte) *Command {
+	if c.capabilities().has(capExtCmd) || len(data) == 0 {
+		return c.teo.Command(data)
+	}
+	return c.teo.Command(data[0], data[1:])
+}
+
+// commandBytes binary marshal command sent to channel peer. Old peers
+// receive CmdExtended as legacy one byte command without extended number
+func (c Channel) commandBytes(cmd Command) []byte {
+	if c.capabilities().has(capExtCmd) || cmd.Cmd != CmdExtended {
+		return cmd.Bytes()
 	}
-	return c.teo.SendTo(addr, c.Bytes(), attr...)
+	return append([]byte{cmd.Cmd}, cmd.Data...)
 }
 
 // MarshalBinary binary marshal command struct
 func (c Command) MarshalBinary() (data []byte, err error) {
+	if c.Cmd == CmdExtended {
+		data = binary.LittleEndian.AppendUint16([]byte{c.Cmd}, uint16(c.Ext))
+		data = append(data, c.Data...)
+		return
+	}
 	data = append([]byte{c.Cmd}, c.Data...)
 	return
 }
@@ -103,9 +150,25 @@ func (c *Command) UnmarshalBinary(data []byte) (err error) {
 	}
 	c.Cmd = data[0]
 	c.Data = data[1:]
+	if c.Cmd == CmdExtended {
+		if len(c.Data) < 2 {
+			return ErrCommandTooShort
+		}
+		c.Ext = ExtCmd(binary.LittleEndian.Uint16(c.Data))
+		c.Data = c.Data[2:]
+	}
 	return
 }
 
+// cmdString return command number in string, extended command returns as
+// 'x<number>'
+func cmdString(cmd byte, ext ExtCmd) string {
+	if cmd == CmdExtended {
+		return "x" + strconv.Itoa(int(ext))
+	}
+	return strconv.Itoa(int(cmd))
+}
+
 // TeonetCommand is teonet command interface methods receiver
 type TeonetCommand struct {
 	*Teonet
@@ -130,6 +193,14 @@ func commandInterface(t *Teonet) (teo *TeonetCommand) {
 // SendAnswer send command answer
 func (teo TeonetCommand) SendAnswer(i interface{}, cmd byte, data []byte) (n int, err error) {
 	pac := i.(*Packet)
+	if _, ok := pac.RequestID(); ok {
+		c, ok := teo.channels.get(pac.From())
+		if !ok {
+			err = ErrPeerNotConnected
+			return
+		}
+		return c.Reply(pac, data)
+	}
 	return teo.SendTo(pac.From(), cmd, data)
 }
 
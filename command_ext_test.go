@@ -0,0 +1,133 @@
+// Test of extended commands
+package teonet
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/teonet-go/tru"
+	"github.com/teonet-go/tru/teolog"
+)
+
+func TestExtCmd(t *testing.T) {
+
+	if log == nil {
+		log = teolog.New()
+	}
+	teo := new(Teonet)
+
+	t.Run("Command", func(t *testing.T) {
+		data := teo.Command(ExtCmd(1000), "hello").Bytes()
+		if len(data) != 3+5 || data[0] != CmdExtended {
+			t.Errorf("wrong extended command data: %v", data)
+			return
+		}
+		cmd := teo.Command(data)
+		if cmd.Cmd != CmdExtended || cmd.Ext != 1000 || string(cmd.Data) != "hello" {
+			t.Errorf("wrong unmarshalled command: %v", cmd)
+		}
+
+		// Legacy command
+		cmd = teo.Command(teo.Command(129, "hello").Bytes())
+		if cmd.Cmd != 129 || cmd.Ext != 0 || string(cmd.Data) != "hello" {
+			t.Errorf("wrong unmarshalled legacy command: %v", cmd)
+		}
+	})
+
+	// Api with legacy and extended commands
+	var executed string
+	reader := func(name string) func(c *Channel, p *Packet, data []byte) bool {
+		return func(c *Channel, p *Packet, data []byte) bool {
+			executed = name + ":" + string(data)
+			return true
+		}
+	}
+	api := teo.NewAPI("test", "test", "test api", "0.0.1")
+	api.Add(
+		MakeAPI2().SetName("legacy").SetCmd(129).SetConnectMode(AnyMode).
+			SetReader(reader("legacy")),
+		MakeAPI2().SetName("ext1").SetExtCmd(1).SetConnectMode(AnyMode).
+			SetReader(reader("ext1")),
+		MakeAPI2().SetName("ext2").SetExtCmd(2).SetConnectMode(AnyMode).
+			SetReader(reader("ext2")),
+	)
+
+	t.Run("Reader", func(t *testing.T) {
+		c := &Channel{a: "test", conn: &channelConn{caps: localCaps}}
+		e := &Event{Event: EventData}
+		for _, test := range []struct {
+			cmd  interface{}
+			want string
+		}{
+			{ExtCmd(2), "ext2:data"},
+			{ExtCmd(1), "ext1:data"},
+			{129, "legacy:data"},
+		} {
+			data := teo.Command(test.cmd, "data").Bytes()
+			p := &Packet{Packet: new(tru.Packet).SetData(data)}
+			if !api.Reader()(c, p, e) || executed != test.want {
+				t.Errorf("wrong executed command: %s, want: %s", executed,
+					test.want)
+			}
+		}
+	})
+
+	// Old peers send and receive command 253 as legacy one byte command
+	t.Run("OldPeer", func(t *testing.T) {
+		c := &Channel{a: "test", conn: &channelConn{}}
+		e := &Event{Event: EventData}
+		legacy := teo.NewAPI("test", "test", "test api", "0.0.1")
+		legacy.Add(MakeAPI2().SetName("legacy").SetCmd(CmdExtended).
+			SetConnectMode(AnyMode).SetReader(reader("legacy")))
+		for _, data := range []string{"", "x", "hello"} {
+			p := &Packet{Packet: new(tru.Packet).SetData(
+				append([]byte{CmdExtended}, data...))}
+			if !legacy.Reader()(c, p, e) || executed != "legacy:"+data {
+				t.Errorf("wrong executed legacy command: %s, data: %s",
+					executed, data)
+			}
+		}
+
+		cmd := Command{Cmd: CmdExtended, Ext: 1000, Data: []byte("hi")}
+		if data := c.commandBytes(cmd); !bytes.Equal(data,
+			[]byte{CmdExtended, 'h', 'i'}) {
+			t.Errorf("wrong command data sent to old peer: %v", data)
+		}
+		c.conn.caps = localCaps
+		if data := c.commandBytes(cmd); len(data) != 3+2 {
+			t.Errorf("wrong command data sent to peer: %v", data)
+		}
+	})
+
+	t.Run("APIDataAr", func(t *testing.T) {
+		data, _ := api.MarshalBinary()
+		var ar APIDataAr
+		if err := ar.UnmarshalBinary(data); err != nil {
+			t.Error(err)
+			return
+		}
+		apicli := &APIClient{ar: &ar, cmdAPI: CmdServerAPI,
+			m: new(sync.RWMutex)}
+		cmd, ext, err := apicli.GetCmdExt("ext2")
+		if err != nil || cmd != CmdExtended || ext != 2 {
+			t.Errorf("wrong extended command: %d %d %v", cmd, ext, err)
+		}
+		if cmd, err := apicli.GetCmd("legacy"); err != nil || cmd != 129 {
+			t.Errorf("wrong legacy command: %d %v", cmd, err)
+		}
+		if apicli.hasCmd(CmdExtended, 3) || !apicli.hasCmd(CmdExtended, 1) {
+			t.Error("wrong extended command existence")
+		}
+
+		// Old clients read data without extensions block
+		var old APIDataAr
+		exts := makeAPIExts(api.cmds)
+		buf := new(bytes.Buffer)
+		exts.write(buf)
+		if err := old.UnmarshalBinary(data[:len(data)-buf.Len()]); err != nil ||
+			len(old.Apis) != len(ar.Apis) {
+			t.Errorf("wrong api data without extensions: %v", err)
+		}
+	})
+}
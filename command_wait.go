@@ -19,6 +19,7 @@ type WaitData []byte
 // WaitFrom wait answer from address. Attr is additional attributes by type:
 //
 //	byte or int: wait command number in answer
+//	ExtCmd: wait extended command number in answer
 //	uint32: wait packet id in answer
 //	func([]byte)bool: check packet data with callback, data without command and id
 //	time.Duration: wait timeout (default 5 sec)
@@ -35,7 +36,7 @@ func (teo *Teonet) WaitFrom(from string, attr ...interface{}) (data []byte, err
 	}
 	defer teo.Unsubscribe(scr)
 
-	data, err = teo.WaitReaderAnswer(wr.Wait(), wr.Timeout())
+	data, err = wr.answer()
 	return
 }
 
@@ -52,6 +53,7 @@ func (teo *Teonet) WaitReaderAnswer(wait chan WaitData, timeout time.Duration) (
 // WaitReader contain create reader, wait channel and timeout
 type WaitReader struct {
 	wait    chan WaitData
+	errs    chan error
 	reader  func(c *Channel, p *Packet, e *Event) (processed bool)
 	timeout time.Duration
 }
@@ -59,6 +61,7 @@ type WaitReader struct {
 // MakeWaitReader create reader, wait channel and timeout from attr:
 //
 //	byte or int: wait command number in answer
+//	ExtCmd: wait extended command number in answer
 //	uint32: wait packet id in answer
 //	func([]byte)bool: check packet data with callback, data without command and id
 //	time.Duration: wait timeout (default 5 sec)
@@ -77,6 +80,7 @@ func (teo *Teonet) MakeWaitReader(attr ...interface{}) (wr *WaitReader) {
 	)
 	var param struct {
 		cmd   byte
+		ext   ExtCmd
 		id    uint32
 		f     CheckDataFunc
 		check byte
@@ -94,6 +98,10 @@ func (teo *Teonet) MakeWaitReader(attr ...interface{}) (wr *WaitReader) {
 			param.cmd = byte(v)
 			param.check |= validCmd
 
+		case ExtCmd:
+			param.cmd, param.ext = CmdExtended, v
+			param.check |= validCmd
+
 		case uint32:
 			param.id = v
 			param.check |= validID
@@ -114,6 +122,24 @@ func (teo *Teonet) MakeWaitReader(attr ...interface{}) (wr *WaitReader) {
 	}
 
 	wr.reader = func(c *Channel, p *Packet, e *Event) (processed bool) {
+		// Check api error answer
+		if e.Event == EventAPIError {
+			cmd, id, apiErr, ok := apiErrorFrame(p.Data())
+			if !ok || param.check&(validCmd|validID) == 0 ||
+				param.check&validCmd > 0 && cmd != param.cmd ||
+				param.check&validID > 0 && id != param.id {
+				return
+			}
+			if param.wait {
+				select {
+				case wr.errs <- apiErr:
+					processed = true
+				default:
+				}
+			}
+			return
+		}
+
 		// Skip not Data Events
 		if e.Event != EventData {
 			return
@@ -128,6 +154,18 @@ func (teo *Teonet) MakeWaitReader(attr ...interface{}) (wr *WaitReader) {
 				return
 			}
 			idx += 1
+
+			// Check extended command, old peers send legacy command
+			if cmd == CmdExtended && c.capabilities().has(capExtCmd) {
+				if len(p.Data()[idx:]) < 2 {
+					return
+				}
+				ext := ExtCmd(binary.LittleEndian.Uint16(p.Data()[idx:]))
+				if ext != param.ext {
+					return
+				}
+				idx += 2
+			}
 		}
 
 		// Check ID
@@ -166,6 +204,7 @@ func (teo *Teonet) MakeWaitReader(attr ...interface{}) (wr *WaitReader) {
 
 	if param.wait {
 		wr.wait = make(chan WaitData)
+		wr.errs = make(chan error, 1)
 	}
 
 	return
@@ -176,6 +215,17 @@ func (wr WaitReader) Wait() chan WaitData {
 	return wr.wait
 }
 
+// answer wait data or api error from wait reader channels
+func (wr WaitReader) answer() (data []byte, err error) {
+	select {
+	case data = <-wr.wait:
+	case err = <-wr.errs:
+	case <-time.After(wr.timeout):
+		err = ErrTimeout
+	}
+	return
+}
+
 // Reader call wait reader
 func (wr WaitReader) Reader() func(c *Channel, p *Packet, e *Event) (processed bool) {
 	return wr.reader
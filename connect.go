@@ -359,6 +359,19 @@ func (teo *Teonet) SetConnected(c *Channel, addr string) {
 	reader(teo, c, nil, &Event{EventConnected, nil})
 }
 
+// setConnected set channel connected. In migrate mode existing channel with
+// the same address moves to the new tru channel and keeps its subscribers,
+// events does not send to readers in this case
+func (teo *Teonet) setConnected(c *Channel, addr string, migrate bool) {
+	if migrate {
+		if ch, ok := teo.channels.get(addr); ok && ch != teo.getAuth() {
+			teo.channels.migrate(ch, c)
+			return
+		}
+	}
+	teo.SetConnected(c, addr)
+}
+
 // ConnectData teonet connect data
 type ConnectData struct {
 	PubliKey      []byte // Client public key (generated from private key)
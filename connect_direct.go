@@ -0,0 +1,305 @@
+// Copyright 2023 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet direct connect module: connect to peer by its ip:port without
+// teonet auth server. Used in local networks and tests
+
+package teonet
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/teonet-go/tru"
+)
+
+const directConnectionPrefix = "dcon-"
+
+// DirectConnect used in teonet.New parameter to accept direct connections
+// from peers which know this peer ip:port (ConnectDirect function). The peer
+// address is not checked by teonet auth server in this case, so accept
+// direct connections in trusted networks only.
+//
+// Direct connect request signed by peer private key. Connection with address
+// of connected peer accepted only when it signed by the same key, the
+// existing channel moves to the new connection in this case (peer network
+// changed or peer restarted). Other connections with address of connected
+// peer refused. Signed request contains request time and accepted once
+// during directRequestTTL, so captured requests can't be replayed (peers
+// clocks should be synchronized).
+//
+// The peer key is not bound to its teonet address, any peer may connect
+// directly with any not connected address. So api access policies with
+// Addresses or Roles does not allow commands to directly connected peers
+// (see Channel.Direct)
+type DirectConnect bool
+
+// directRequestTTL is time during which direct connect request accepted
+const directRequestTTL = time.Minute
+
+// Direct connect errors
+var (
+	// ErrDirectConnect returns by ConnectDirect when peer does not answer
+	ErrDirectConnect = errors.New("direct connection does not accepted by peer")
+
+	// ErrAddressConnected returns by ConnectDirect when peer with the same
+	// address and other key already connected to peer
+	ErrAddressConnected = errors.New("peer with this address already connected")
+
+	// ErrWrongSign returns by ConnectDirect when peer can't check request
+	// signature
+	ErrWrongSign = errors.New("wrong direct connect request signature")
+
+	// ErrDirectReplay returns by ConnectDirect when peer already received
+	// this request or the request expired
+	ErrDirectReplay = errors.New("direct connect request replayed or expired")
+)
+
+// ConnectDirect connect to teonet peer by ip:port without teonet auth
+// server and returns connected peer address. The peer should be created
+// with DirectConnect(true) parameter
+func (teo *Teonet) ConnectDirect(ipport string, readers ...interface{}) (
+	addr string, err error) {
+
+	if addr, err = teo.connectDirectTo(ipport, false); err != nil {
+		return
+	}
+
+	// Subscribe to channel
+	for i := range readers {
+		teo.Subscribe(addr, readers[i])
+	}
+	return
+}
+
+// connectDirectTo connect to teonet peer by ip:port without teonet auth
+// server. The existing channel with peer address moves to new connection
+// if migrate is true
+func (teo *Teonet) connectDirectTo(ipport string, migrate bool) (
+	addr string, err error) {
+
+	log.Connect.Println(nMODULEconp, "direct", ipport)
+
+	// Connect to peer by tru
+	c, err := teo.tru.Connect(ipport)
+	if err != nil {
+		return
+	}
+
+	// Create wait channel and connect request
+	con := ConnectToData{
+		ID:       tru.RandomString(35),
+		FromAddr: teo.Address(),
+		Caps:     uint32(localCaps),
+		Migrate:  migrate,
+		Time:     time.Now().UnixNano(),
+	}
+	if err = teo.signDirect(&con); err != nil {
+		return
+	}
+	chanW := make(chanWait)
+	defer close(chanW)
+	teo.connRequests.add(&con, &chanW)
+	defer teo.connRequests.del(con.ID)
+
+	// Send direct connect request to peer
+	data, _ := con.MarshalBinary()
+	if _, err = c.WriteTo(append([]byte(directConnectionPrefix), data...)); err != nil {
+		return
+	}
+
+	// Wait connect answer
+	select {
+	case d := <-chanW:
+		if len(d) > 0 {
+			c.Close()
+			err = directError(d)
+			return
+		}
+	case <-time.After(tru.ClientConnectTimeout):
+		c.Close()
+		err = ErrDirectConnect
+		return
+	}
+	addr = con.ToAddr
+	return
+}
+
+// connectDirect check received message and process direct connect requests
+// and answers
+func (teo *Teonet) connectDirect(c *Channel, p *Packet) (ok bool) {
+	if !c.IsNew() || !bytes.HasPrefix(p.Data(), []byte(directConnectionPrefix)) {
+		return
+	}
+	ok = true
+
+	// Unmarshal data
+	var con ConnectToData
+	err := con.UnmarshalBinary(p.Data()[len(directConnectionPrefix):])
+	if err != nil || len(con.FromAddr) == 0 {
+		log.Error.Println(nMODULEconp, "direct connect unmarshal error:", err)
+		return
+	}
+
+	// Request from client (peer processed)
+	if c.ServerMode() {
+		if !teo.direct {
+			log.Debug.Println(nMODULEconp, "skip direct connect from",
+				c.truChannel().Addr().String())
+			return
+		}
+		answer := ConnectToData{
+			ID:       con.ID,
+			FromAddr: teo.Address(),
+			Caps:     uint32(localCaps),
+		}
+		migrate, err := teo.checkDirect(&con)
+		if err != nil {
+			log.Connect.Println(nMODULEconp, "direct connect from",
+				c.truChannel().Addr().String(), "refused:", err)
+			answer.Err = []byte(err.Error())
+		}
+		// The answer sends before any frame to connected channel
+		data, _ := answer.MarshalBinary()
+		c.truChannel().WriteTo(append([]byte(directConnectionPrefix), data...))
+		if err == nil {
+			c.setCaps(newPeerCaps(con.Caps))
+			c.key = con.Key
+			c.unverified = true
+			teo.setConnected(c, con.FromAddr, migrate)
+		}
+		return
+	}
+
+	// Answer from peer (client processed)
+	req, exists := teo.connRequests.get(con.ID)
+	if !exists {
+		log.Error.Println(nMODULEconp, "!!! wrong direct request id:", con.ID)
+		return
+	}
+	if len(con.Err) > 0 {
+		if req.chanWait.IsOpen() {
+			*req.chanWait <- con.Err
+		}
+		return
+	}
+	c.setCaps(newPeerCaps(con.Caps))
+	c.direct = c.truChannel().Addr().String()
+	c.unverified = true
+	req.ToAddr = con.FromAddr
+	teo.setConnected(c, con.FromAddr, req.Migrate)
+	if req.chanWait.IsOpen() {
+		*req.chanWait <- nil
+	}
+	return
+}
+
+// signDirect set public key and signature of direct connect request
+func (teo *Teonet) signDirect(con *ConnectToData) (err error) {
+	key := teo.config.trudpPrivateKey
+	if con.Key, err = x509.MarshalPKIXPublicKey(&key.PublicKey); err != nil {
+		return
+	}
+	hash := directHash(con)
+	con.Sign, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash)
+	return
+}
+
+// checkDirect check direct connect request signature, request time and
+// connected peer with the same address. Returns migrate true if connected
+// peer should move to this connection
+func (teo *Teonet) checkDirect(con *ConnectToData) (migrate bool, err error) {
+
+	// Check signature and request time, old peers does not sign requests
+	if len(con.Key) > 0 {
+		var pub interface{}
+		if pub, err = x509.ParsePKIXPublicKey(con.Key); err != nil {
+			err = ErrWrongSign
+			return
+		}
+		key, ok := pub.(*rsa.PublicKey)
+		if !ok || rsa.VerifyPKCS1v15(key, crypto.SHA256, directHash(con),
+			con.Sign) != nil {
+			err = ErrWrongSign
+			return
+		}
+		if !teo.directIDs.add(con.ID, time.Unix(0, con.Time)) {
+			err = ErrDirectReplay
+			return
+		}
+	}
+
+	// Check connected peer with the same address
+	ch, ok := teo.channels.get(con.FromAddr)
+	if !ok {
+		return
+	}
+	if len(ch.key) == 0 || !bytes.Equal(ch.key, con.Key) {
+		err = ErrAddressConnected
+		return
+	}
+	migrate = true
+	return
+}
+
+// directError return direct connect error by error message received from peer
+func directError(msg []byte) error {
+	for _, err := range []error{ErrAddressConnected, ErrWrongSign,
+		ErrDirectReplay} {
+		if string(msg) == err.Error() {
+			return err
+		}
+	}
+	return errors.New(string(msg))
+}
+
+// directHash return hash of signed direct connect request fields
+func directHash(con *ConnectToData) []byte {
+	h := sha256.New()
+	h.Write([]byte(con.ID))
+	h.Write([]byte(con.FromAddr))
+	h.Write(con.Key)
+	binary.Write(h, binary.LittleEndian, con.Time)
+	return h.Sum(nil)
+}
+
+// directRequests contains ids of accepted direct connect requests
+type directRequests struct {
+	m map[string]time.Time
+	sync.Mutex
+}
+
+// newDirectRequests create new direct connect requests object
+func newDirectRequests() *directRequests {
+	return &directRequests{m: make(map[string]time.Time)}
+}
+
+// add direct connect request id and request time. Returns false if request
+// expired or request with this id already added. Expired ids removed
+func (d *directRequests) add(id string, t time.Time) bool {
+	now := time.Now()
+	d.Lock()
+	defer d.Unlock()
+	for k, v := range d.m {
+		if now.Sub(v) > directRequestTTL {
+			delete(d.m, k)
+		}
+	}
+	if now.Sub(t) > directRequestTTL || t.Sub(now) > directRequestTTL {
+		return false
+	}
+	if _, ok := d.m[id]; ok {
+		return false
+	}
+	d.m[id] = t
+	return true
+}
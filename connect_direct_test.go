@@ -0,0 +1,249 @@
+// Test of direct connection between local peers
+package teonet
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/teonet-go/tru"
+)
+
+// newLocalPeers create two teonet peers and connect them directly. Returns
+// client, server and server address
+func newLocalPeers(t *testing.T) (cli, srv *Teonet, addr string) {
+	srv, err := New("test-server", OsConfigDir(t.TempDir()), DirectConnect(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(srv.Close)
+	cli, err = New("test-client", OsConfigDir(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cli.Close)
+
+	addr, err = cli.ConnectDirect(fmt.Sprintf("127.0.0.1:%d", srv.Port()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr != srv.Address() {
+		t.Fatalf("wrong connected address: %s", addr)
+	}
+	return
+}
+
+func TestConnectDirect(t *testing.T) {
+	cli, srv, addr := newLocalPeers(t)
+
+	// Echo api with streaming command
+	api := srv.NewAPI("echo", "echo", "echo api", "0.0.1")
+	api.Add(
+		MakeAPI2().SetName("echo").SetCmd(129).SetConnectMode(AnyMode).
+			SetReader(func(c *Channel, p *Packet, data []byte) bool {
+				c.Reply(p, data)
+				return true
+			}),
+		MakeAPI2().SetName("count").SetCmd(130).SetConnectMode(AnyMode).
+			SetReader(func(c *Channel, p *Packet, data []byte) bool {
+				w, err := c.ReplyStream(p)
+				if err != nil {
+					return false
+				}
+				go func() {
+					for i := 0; i < 100; i++ {
+						w.Write([]byte{byte(i)})
+					}
+					w.Close()
+				}()
+				return true
+			}),
+	)
+	srv.AddReader(api.Reader())
+
+	t.Run("Request", func(t *testing.T) {
+		data, err := cli.Request(context.Background(), addr, 129, []byte("hello"))
+		if err != nil || string(data) != "hello" {
+			t.Errorf("wrong reply: %s %v", data, err)
+		}
+		_, err = cli.Request(context.Background(), addr, 131, nil)
+		if err != ErrAPIUnknownCommand && !isAPIError(err, APIErrUnknownCommand) {
+			t.Errorf("wrong unknown command error: %v", err)
+		}
+	})
+
+	t.Run("RequestStream", func(t *testing.T) {
+		s, err := cli.RequestStream(context.Background(), addr, 130, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		for i := 0; ; i++ {
+			data, err := s.Recv()
+			if err == io.EOF {
+				if i != 100 {
+					t.Errorf("wrong number of parts: %d", i)
+				}
+				break
+			}
+			if err != nil || data[0] != byte(i) {
+				t.Errorf("wrong part %d: %v %v", i, data, err)
+				return
+			}
+		}
+	})
+
+	t.Run("Stream", func(t *testing.T) {
+		l, err := srv.ListenStream(80)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer l.Close()
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			io.Copy(conn, conn)
+			conn.Close()
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		conn, err := cli.DialStream(ctx, addr, 80)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		msg := make([]byte, 1024*1024)
+		for i := range msg {
+			msg[i] = byte(i)
+		}
+		go func() {
+			conn.Write(msg)
+			conn.(*Stream).CloseWrite()
+		}()
+		conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+		got, err := io.ReadAll(conn)
+		if err != nil || len(got) != len(msg) {
+			t.Errorf("wrong echo length: %d %v", len(got), err)
+		}
+		conn.Close()
+	})
+}
+
+// Connection with address of connected peer refused when it does not signed
+// by the peer key
+func TestConnectDirectSpoof(t *testing.T) {
+	cli, srv, addr := newLocalPeers(t)
+	api := srv.NewAPI("ping", "ping", "ping api", "0.0.1")
+	api.Add(MakeAPI2().SetName("ping").SetCmd(129).
+		SetReader(func(c *Channel, p *Packet, data []byte) bool {
+			c.Reply(p, []byte("pong"))
+			return true
+		}))
+	srv.AddReader(api.Reader())
+	attacker, err := New("test-attacker", OsConfigDir(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer attacker.Close()
+	ipport := fmt.Sprintf("127.0.0.1:%d", srv.Port())
+
+	// Valid signature of other key
+	con := ConnectToData{FromAddr: cli.Address(), Caps: uint32(localCaps)}
+	if err := spoofDirect(attacker, ipport, con, false); err != ErrAddressConnected {
+		t.Errorf("wrong other key error: %v", err)
+	}
+
+	// Wrong signature
+	if err := spoofDirect(attacker, ipport, con, true); err != ErrWrongSign {
+		t.Errorf("wrong signature error: %v", err)
+	}
+
+	// Connected peer channel does not changed
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if data, err := cli.Request(ctx, addr, 129, nil); err != nil ||
+		string(data) != "pong" {
+		t.Errorf("connected peer request failed: %s, err: %v", data, err)
+	}
+	if _, ok := srv.Channel(attacker.Address()); ok {
+		t.Error("attacker connected")
+	}
+}
+
+// Signed direct connect request accepted once and during request TTL only
+func TestConnectDirectReplay(t *testing.T) {
+	srv, err := New("test-server", OsConfigDir(t.TempDir()), DirectConnect(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	cli, err := New("test-client", OsConfigDir(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	request := func(t time.Time) *ConnectToData {
+		con := &ConnectToData{ID: tru.RandomString(35), FromAddr: cli.Address(),
+			Time: t.UnixNano()}
+		cli.signDirect(con)
+		return con
+	}
+
+	con := request(time.Now())
+	if _, err := srv.checkDirect(con); err != nil {
+		t.Errorf("wrong request error: %v", err)
+	}
+	if _, err := srv.checkDirect(con); err != ErrDirectReplay {
+		t.Errorf("wrong replayed request error: %v", err)
+	}
+	if _, err := srv.checkDirect(request(time.Now().Add(-2 *
+		directRequestTTL))); err != ErrDirectReplay {
+		t.Errorf("wrong expired request error: %v", err)
+	}
+}
+
+// spoofDirect send direct connect request with connect data signed by teo
+// key and wait answer. The signature spoiled if wrongSign is true
+func spoofDirect(teo *Teonet, ipport string, con ConnectToData,
+	wrongSign bool) error {
+
+	con.ID = tru.RandomString(35)
+	con.Time = time.Now().UnixNano()
+	teo.signDirect(&con)
+	if wrongSign {
+		con.Sign[0]++
+	}
+	chanW := make(chanWait)
+	defer close(chanW)
+	teo.connRequests.add(&con, &chanW)
+	defer teo.connRequests.del(con.ID)
+
+	c, err := teo.tru.Connect(ipport)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	data, _ := con.MarshalBinary()
+	c.WriteTo(append([]byte(directConnectionPrefix), data...))
+	select {
+	case d := <-chanW:
+		if len(d) == 0 {
+			return nil
+		}
+		return directError(d)
+	case <-time.After(tru.ClientConnectTimeout):
+		return ErrDirectConnect
+	}
+}
+
+func isAPIError(err error, code APIErrorCode) bool {
+	e, ok := err.(*APIError)
+	return ok && e.Code == code
+}
@@ -57,43 +57,8 @@ func (teo Teonet) ConnectTo(addr string, readers ...interface{}) (err error) {
 		return
 	}
 
-	// Local IPs and port
-	ips, _ := teo.getIPs()
-	port := teo.tru.LocalPort()
-
-	// Connect data
-	con := ConnectToData{
-		ID:        tru.RandomString(35),
-		FromAddr:  teo.Address(),
-		ToAddr:    addr,
-		LocalIPs:  ips,
-		LocalPort: uint32(port),
-	}
-	data, _ := con.MarshalBinary()
-
-	// Send command to teonet
-	log.Debugv.Println(nMODULEconp, "send request to teonet, addr:",
-		con.ToAddr[:8], "id:", con.ID[:8])
-	teo.Command(CmdConnectTo, data).Send(auth)
-
-	// Wait and receive punch answer
-	teo.clientPunchReceive(&con)
-
-	// Create wait channel and connect request
-	chanW := make(chanWait)
-	defer close(chanW)
-	teo.connRequests.add(&con, &chanW)
-	defer teo.connRequests.del(con.ID)
-
-	// Wait Connect answer data
-	select {
-	case d := <-chanW:
-		if len(d) > 0 {
-			err = errors.New(string(d))
-			return
-		}
-	case <-time.After(tru.ClientConnectTimeout):
-		err = ErrTimeout
+	// Send connect request to teonet and connect to peer
+	if err = teo.connectTo(addr, false); err != nil {
 		return
 	}
 
@@ -138,6 +103,63 @@ func (teo Teonet) ConnectTo(addr string, readers ...interface{}) (err error) {
 	return
 }
 
+// connectTo send connect request to teonet auth server, punch firewall and
+// wait peer connected. In migrate mode existing channel with the same address
+// moves to new connection
+func (teo Teonet) connectTo(addr string, migrate bool) (err error) {
+
+	// Check teonet connected
+	var auth = teo.getAuth()
+	if auth == nil || auth.IsNew() {
+		err = ErrDoesNotConnectedToTeonet
+		return
+	}
+
+	// Local IPs and port
+	ips, _ := teo.getIPs()
+	port := teo.tru.LocalPort()
+
+	// Connect data
+	con := ConnectToData{
+		ID:        tru.RandomString(35),
+		FromAddr:  teo.Address(),
+		ToAddr:    addr,
+		LocalIPs:  ips,
+		LocalPort: uint32(port),
+		Migrate:   migrate,
+		Endpoints: teo.getEndpoints(),
+	}
+	data, _ := con.MarshalBinary()
+
+	// Send command to teonet
+	log.Debugv.Println(nMODULEconp, "send request to teonet, addr:",
+		con.ToAddr[:8], "id:", con.ID[:8])
+	teo.Command(CmdConnectTo, data).Send(auth)
+
+	// Wait and receive punch answer
+	teo.clientPunchReceive(&con)
+
+	// Create wait channel and connect request
+	chanW := make(chanWait)
+	defer close(chanW)
+	teo.connRequests.add(&con, &chanW)
+	defer teo.connRequests.del(con.ID)
+
+	// Wait Connect answer data
+	select {
+	case d := <-chanW:
+		if len(d) > 0 {
+			err = errors.New(string(d))
+			return
+		}
+	case <-time.After(tru.ClientConnectTimeout):
+		err = ErrTimeout
+		return
+	}
+
+	return
+}
+
 // CloseTo close connection to peere previously opened by ConnecTo
 func (teo Teonet) CloseTo(addr string) (err error) {
 	log.Debug.Println("close connection to peer", addr)
@@ -147,7 +169,7 @@ func (teo Teonet) CloseTo(addr string) (err error) {
 		return
 	}
 	ch.closing = true
-	ch.c.Close()
+	ch.truChannel().Close()
 	return
 }
 
@@ -229,6 +251,7 @@ func (teo Teonet) processCmdConnectToPeer(data []byte) (err error) {
 		LocalIPs:  ips,
 		LocalPort: uint32(port),
 		Resend:    con.Resend,
+		Endpoints: teo.getEndpoints(),
 	}
 	data, _ = conPeer.MarshalBinary()
 
@@ -277,6 +300,7 @@ func (teo Teonet) serverPunchSend(con *ConnectToData) {
 			LocalPort: con.LocalPort,
 			IP:        con.IP,
 			Port:      con.Port,
+			Endpoints: con.Endpoints,
 		}, func() bool { _, ok := teo.peerRequests.get(con.ID); return !ok })
 	}()
 }
@@ -347,6 +371,8 @@ func (teo Teonet) clientPunchReceive(con *ConnectToData) {
 		// Marshal peer connect request
 		var conPeer ConnectToData
 		conPeer.ID = con.ID
+		conPeer.Caps = uint32(localCaps)
+		conPeer.Migrate = con.Migrate
 		data, err := conPeer.MarshalBinary()
 		if err != nil {
 			log.Error.Println(nMODULEconp, cantConnectToPeer, err)
@@ -399,6 +425,7 @@ func (teo Teonet) clientPunchSend(con *ConnectToData) {
 			LocalPort: con.LocalPort,
 			IP:        con.IP,
 			Port:      con.Port,
+			Endpoints: con.Endpoints,
 		}, func() bool { _, ok := teo.connRequests.get(con.ID); return !ok })
 	}()
 }
@@ -421,11 +448,14 @@ func (teo Teonet) connectToPeer(c *Channel, p *Packet) (ok bool) {
 			log.Debugv.Println(nMODULEconp, "got answer from new client, id:", con.ID[:6])
 
 			if res, ok := teo.peerRequests.del(con.ID); ok {
-				// Set channel connected
-				teo.SetConnected(c, res.FromAddr)
-				// Send answer to client
+				// Send answer with this peer capabilities to client. The
+				// answer sends before any frame to connected channel
 				log.Debugv.Println(nMODULEconp, "send answer to client, id:", con.ID[:6])
-				c.Send(p.Data())
+				answer, _ := ConnectToData{ID: con.ID, Caps: uint32(localCaps)}.MarshalBinary()
+				c.truChannel().WriteTo(append([]byte(newConnectionPrefix), answer...))
+				// Set channel connected
+				c.setCaps(newPeerCaps(con.Caps))
+				teo.setConnected(c, res.FromAddr, con.Migrate)
 			} else {
 				log.Error.Println(nMODULEconp, "!!! wrong request id:", con.ID[:6])
 				// TODO: we can't delete channel here becaus deadlock will be
@@ -459,7 +489,8 @@ func (teo Teonet) connectToClient(c *Channel, p *Packet) (ok bool) {
 
 			if req, ok := teo.connRequests.get(con.ID); ok {
 				// Set channel connected
-				teo.SetConnected(c, req.ToAddr)
+				c.setCaps(newPeerCaps(con.Caps))
+				teo.setConnected(c, req.ToAddr, req.Migrate)
 				// Send to wait channel to finish connection and close connRequest
 				if req.chanWait.IsOpen() {
 					*req.chanWait <- nil
@@ -486,6 +517,12 @@ type ConnectToData struct {
 	LocalPort uint32   // Local port (set by client or peer)
 	Err       []byte   // Error of connectTo processing
 	Resend    bool     // Resend flag
+	Caps      uint32   // Peer capabilities (set by client or peer)
+	Migrate   bool     // Move existing channel to this connection
+	Endpoints []string // Custom ip:port endpoints (dropped by old teonet auth)
+	Key       []byte   // Peer public key (set by peer in direct connect)
+	Sign      []byte   // Request signature (set by peer in direct connect)
+	Time      int64    // Request unix time in nanoseconds (direct connect)
 	bslice.ByteSlice
 }
 
@@ -502,6 +539,12 @@ func (c ConnectToData) MarshalBinary() (data []byte, err error) {
 	binary.Write(buf, binary.LittleEndian, c.LocalPort)
 	c.WriteSlice(buf, c.Err)
 	binary.Write(buf, binary.LittleEndian, c.Resend)
+	binary.Write(buf, binary.LittleEndian, c.Caps)
+	binary.Write(buf, binary.LittleEndian, c.Migrate)
+	c.WriteStringSlice(buf, c.Endpoints)
+	c.WriteSlice(buf, c.Key)
+	c.WriteSlice(buf, c.Sign)
+	binary.Write(buf, binary.LittleEndian, c.Time)
 
 	data = buf.Bytes()
 	return
@@ -547,5 +590,33 @@ func (c *ConnectToData) UnmarshalBinary(data []byte) (err error) {
 		return
 	}
 
+	// Old peers does not send capabilities
+	if buf.Len() == 0 {
+		return
+	}
+	if err = binary.Read(buf, binary.LittleEndian, &c.Caps); err != nil {
+		return
+	}
+	if err = binary.Read(buf, binary.LittleEndian, &c.Migrate); err != nil {
+		return
+	}
+	if c.Endpoints, err = c.ReadStringSlice(buf); err != nil {
+		return
+	}
+
+	// Old peers does not send key and signature
+	if buf.Len() == 0 {
+		return
+	}
+	if c.Key, err = c.ReadSlice(buf); err != nil {
+		return
+	}
+	if c.Sign, err = c.ReadSlice(buf); err != nil {
+		return
+	}
+	if err = binary.Read(buf, binary.LittleEndian, &c.Time); err != nil {
+		return
+	}
+
 	return
 }
@@ -0,0 +1,250 @@
+// Copyright 2023 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet dispatch module: executes subscribers and client readers (and api
+// commands handlers) in workers pool, so slow reader does not stall packets
+// of other peers.
+//
+// Packets of each channel queue to the channel queue and execute in order
+// of receiving, queues of different channels execute in parallel by
+// workers. Teonet internal messages (connect, heartbeats, fragments,
+// streams and requests replies) processes in receive goroutine before
+// dispatching. Dispatching is disabled by default, set DispatchWorkers
+// parameter of teonet.New to enable it.
+
+package teonet
+
+import (
+	"sync"
+)
+
+// Default dispatch queue length
+const DefaultDispatchQueue = 1024
+
+// DispatchWorkers used in teonet.New parameter to set number of readers
+// workers. Default 0 - readers execute in receive goroutine
+type DispatchWorkers int
+
+// DispatchQueue used in teonet.New parameter to set max number of packets
+// queued to each channel, DefaultDispatchQueue by default
+type DispatchQueue int
+
+// QueuePolicy is dispatch policy used when channel queue is full
+type QueuePolicy byte
+
+const (
+	// QueueDropNewest - drop received packet
+	QueueDropNewest QueuePolicy = iota
+
+	// QueueDropOldest - drop the oldest packet in queue
+	QueueDropOldest
+
+	// QueueBlock - wait until queue has space, it blocks receiving packets
+	// from all peers
+	QueueBlock
+)
+
+// DispatchStats contains dispatch queues metrics
+type DispatchStats struct {
+	Workers   int    // Number of workers
+	Channels  int    // Number of channels with queued packets
+	Queued    int    // Number of queued packets
+	MaxQueued int    // Max number of packets queued to one channel now
+	Processed uint64 // Number of processed packets and events
+	Dropped   uint64 // Number of dropped packets
+	Blocked   uint64 // Number of packets waited for queue space
+}
+
+// dispatcher executes readers jobs in workers
+type dispatcher struct {
+	workers int
+	queue   int
+	policy  QueuePolicy
+	queues  map[interface{}]*dispatchQueue // Channels queues by tru channel
+	ready   []*dispatchQueue               // Queues wait for worker
+	stat    DispatchStats
+	closed  bool
+	work    *sync.Cond // Signals ready queue or close
+	space   *sync.Cond // Signals space in queues or close
+	sync.Mutex
+}
+
+// dispatchQueue is channel queue
+type dispatchQueue struct {
+	key       interface{}
+	jobs      []dispatchJob
+	scheduled bool // Queue is in ready list or executing by worker
+}
+
+// dispatchJob is queued reader job, events jobs does not dropped
+type dispatchJob struct {
+	f         func()
+	droppable bool
+}
+
+// newDispatcher create dispatcher and start workers
+func newDispatcher(workers, queue int, policy QueuePolicy) (d *dispatcher) {
+	if queue <= 0 {
+		queue = DefaultDispatchQueue
+	}
+	d = &dispatcher{workers: workers, queue: queue, policy: policy,
+		queues: make(map[interface{}]*dispatchQueue)}
+	d.work = sync.NewCond(&d.Mutex)
+	d.space = sync.NewCond(&d.Mutex)
+	for i := 0; i < workers; i++ {
+		go d.worker()
+	}
+	return
+}
+
+// dispatch queue job to channel queue or execute it if dispatcher has not
+// workers. Data packets jobs are droppable. Events jobs execute in caller
+// goroutine when dispatcher closed, so channels deleted on errors
+func (d *dispatcher) dispatch(c *Channel, droppable bool, f func()) {
+	if d == nil || d.workers <= 0 {
+		f()
+		return
+	}
+
+	var key interface{} = c
+	if tc := c.truChannel(); tc != nil {
+		key = tc
+	}
+
+	d.Lock()
+	if d.closed {
+		d.Unlock()
+		if !droppable {
+			f()
+		}
+		return
+	}
+	defer d.Unlock()
+	q, ok := d.queues[key]
+	if !ok {
+		q = &dispatchQueue{key: key}
+		d.queues[key] = q
+	}
+
+	// Apply queue policy when queue is full
+	for droppable && len(q.jobs) >= d.queue {
+		switch d.policy {
+		case QueueDropOldest:
+			if i := q.droppable(); i >= 0 {
+				q.jobs = append(q.jobs[:i], q.jobs[i+1:]...)
+				d.stat.Dropped++
+				continue
+			}
+			fallthrough
+		case QueueDropNewest:
+			d.stat.Dropped++
+			log.Debugv.Println("dispatch queue of", c, "is full, packet dropped")
+			return
+		case QueueBlock:
+			d.stat.Blocked++
+			for len(q.jobs) >= d.queue && !d.closed {
+				d.space.Wait()
+			}
+			if d.closed {
+				return
+			}
+		}
+	}
+
+	q.jobs = append(q.jobs, dispatchJob{f, droppable})
+	if !q.scheduled {
+		q.scheduled = true
+		d.ready = append(d.ready, q)
+		d.work.Signal()
+	}
+}
+
+// worker execute jobs of ready queues. Worker executes one job of queue and
+// returns queue to the end of ready list, so busy channel does not hold
+// worker
+func (d *dispatcher) worker() {
+	d.Lock()
+	defer d.Unlock()
+	for {
+		for len(d.ready) == 0 && !d.closed {
+			d.work.Wait()
+		}
+		if d.closed {
+			return
+		}
+		q := d.ready[0]
+		d.ready = d.ready[1:]
+		job := q.jobs[0]
+		q.jobs = q.jobs[1:]
+		d.space.Broadcast()
+
+		d.Unlock()
+		job.f()
+		d.Lock()
+
+		d.stat.Processed++
+		if len(q.jobs) > 0 {
+			d.ready = append(d.ready, q)
+			continue
+		}
+		q.scheduled = false
+		delete(d.queues, q.key)
+	}
+}
+
+// close stop workers, queued data packets jobs does not execute and queued
+// events jobs execute in caller goroutine
+func (d *dispatcher) close() {
+	if d == nil {
+		return
+	}
+	d.Lock()
+	d.closed = true
+	var events []func()
+	for _, q := range d.queues {
+		for _, job := range q.jobs {
+			if !job.droppable {
+				events = append(events, job.f)
+			}
+		}
+		q.jobs = nil
+	}
+	d.work.Broadcast()
+	d.space.Broadcast()
+	d.Unlock()
+
+	for _, f := range events {
+		f()
+	}
+}
+
+// droppable return index of the oldest droppable job or -1
+func (q *dispatchQueue) droppable() int {
+	for i := range q.jobs {
+		if q.jobs[i].droppable {
+			return i
+		}
+	}
+	return -1
+}
+
+// DispatchStats return dispatch queues metrics
+func (teo *Teonet) DispatchStats() (stat DispatchStats) {
+	d := teo.dispatcher
+	if d == nil {
+		return
+	}
+	d.Lock()
+	defer d.Unlock()
+	stat = d.stat
+	stat.Workers = d.workers
+	stat.Channels = len(d.queues)
+	for _, q := range d.queues {
+		stat.Queued += len(q.jobs)
+		if len(q.jobs) > stat.MaxQueued {
+			stat.MaxQueued = len(q.jobs)
+		}
+	}
+	return
+}
@@ -0,0 +1,177 @@
+// Test of readers dispatcher
+package teonet
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/teonet-go/tru/teolog"
+)
+
+func TestDispatch(t *testing.T) {
+
+	if log == nil {
+		log = teolog.New()
+	}
+	c1, c2 := &Channel{a: "peer-1"}, &Channel{a: "peer-2"}
+
+	// Packets of one channel execute in order of receiving
+	t.Run("Order", func(t *testing.T) {
+		d := newDispatcher(4, 0, QueueBlock)
+		defer d.close()
+		var got []int
+		var wg sync.WaitGroup
+		wg.Add(100)
+		for i := 0; i < 100; i++ {
+			i := i
+			d.dispatch(c1, true, func() { got = append(got, i); wg.Done() })
+		}
+		wg.Wait()
+		for i := range got {
+			if got[i] != i {
+				t.Fatalf("wrong order: %v", got)
+			}
+		}
+	})
+
+	// Slow channel does not block other channels
+	t.Run("Parallel", func(t *testing.T) {
+		d := newDispatcher(2, 0, QueueBlock)
+		defer d.close()
+		release, done := make(chan struct{}), make(chan struct{})
+		d.dispatch(c1, true, func() { <-release })
+		d.dispatch(c2, true, func() { close(done) })
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Error("channel blocked by other channel")
+		}
+		close(release)
+	})
+
+	// Full queue policies, events does not dropped
+	policies := map[QueuePolicy]string{
+		QueueDropNewest: "1 2 event ",
+		QueueDropOldest: "2 3 event ",
+	}
+	for policy, want := range policies {
+		t.Run(fmt.Sprint("Policy", policy), func(t *testing.T) {
+			d := newDispatcher(1, 2, policy)
+			defer d.close()
+			teo := &Teonet{dispatcher: d}
+			release, done := make(chan struct{}), make(chan struct{})
+			started := make(chan struct{})
+			var got string
+			d.dispatch(c1, true, func() { close(started); <-release })
+			<-started
+			for _, s := range []string{"1", "2", "3"} {
+				s := s
+				d.dispatch(c1, true, func() { got += s + " " })
+			}
+			d.dispatch(c1, false, func() { got += "event "; close(done) })
+
+			stat := teo.DispatchStats()
+			if stat.Dropped != 1 || stat.Queued != 3 || stat.Channels != 1 {
+				t.Errorf("wrong stats: %+v", stat)
+			}
+			close(release)
+			<-done
+			if got != want {
+				t.Errorf("wrong executed packets: %s", got)
+			}
+		})
+	}
+
+	t.Run("Block", func(t *testing.T) {
+		d := newDispatcher(1, 1, QueueBlock)
+		defer d.close()
+		teo := &Teonet{dispatcher: d}
+		release, dispatched := make(chan struct{}), make(chan struct{})
+		started := make(chan struct{})
+		d.dispatch(c1, true, func() { close(started); <-release })
+		<-started
+		d.dispatch(c1, true, func() {})
+		go func() {
+			d.dispatch(c1, true, func() {})
+			close(dispatched)
+		}()
+		select {
+		case <-dispatched:
+			t.Fatal("dispatch does not blocked by full queue")
+		case <-time.After(50 * time.Millisecond):
+		}
+		close(release)
+		<-dispatched
+		if stat := teo.DispatchStats(); stat.Blocked != 1 || stat.Dropped != 0 {
+			t.Errorf("wrong stats: %+v", stat)
+		}
+	})
+
+	// Events queued and dispatched after close execute, data packets dropped
+	t.Run("Closed", func(t *testing.T) {
+		d := newDispatcher(1, 0, QueueBlock)
+		release, started := make(chan struct{}), make(chan struct{})
+		d.dispatch(c1, true, func() { close(started); <-release })
+		<-started
+		var events, packets int
+		d.dispatch(c1, false, func() { events++ })
+		d.dispatch(c1, true, func() { packets++ })
+		d.close()
+		close(release)
+		d.dispatch(c1, false, func() { events++ })
+		d.dispatch(c1, true, func() { packets++ })
+		if events != 2 || packets != 0 {
+			t.Errorf("wrong jobs executed after close: events %d, packets %d",
+				events, packets)
+		}
+	})
+
+	// Slow api command of one peer does not stall other peers
+	t.Run("Teonet", func(t *testing.T) {
+		srv, err := New("test-server", OsConfigDir(t.TempDir()),
+			DirectConnect(true), DispatchWorkers(4))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer srv.Close()
+		api := srv.NewAPI("test", "test", "test api", "0.0.1")
+		api.Add(MakeAPI2().SetName("sleep").SetCmd(129).
+			SetReader(func(c *Channel, p *Packet, data []byte) bool {
+				time.Sleep(500 * time.Millisecond)
+				c.Reply(p, data)
+				return true
+			}))
+		srv.AddReader(api.Reader())
+
+		var clis []*Teonet
+		for i := 0; i < 2; i++ {
+			cli, err := New("test-client", OsConfigDir(t.TempDir()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cli.Close()
+			if _, err = cli.ConnectDirect(fmt.Sprintf("127.0.0.1:%d",
+				srv.Port())); err != nil {
+				t.Fatal(err)
+			}
+			clis = append(clis, cli)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		go clis[0].Request(ctx, srv.Address(), 129, nil)
+		time.Sleep(50 * time.Millisecond)
+		start := time.Now()
+		reply, err := clis[1].Request(ctx, srv.Address(), CmdServerAPI, nil)
+		if err != nil || len(reply) == 0 || time.Since(start) > 250*time.Millisecond {
+			t.Errorf("request stalled by other peer: %v, err: %v",
+				time.Since(start), err)
+		}
+		if stat := srv.DispatchStats(); stat.Workers != 4 || stat.Processed == 0 {
+			t.Errorf("wrong stats: %+v", stat)
+		}
+	})
+}
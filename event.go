@@ -36,6 +36,10 @@ const (
 
 	// Event when Data Received, Err = nil
 	EventData
+
+	// Event when api error answer to command received, Err = nil. The
+	// WaitFrom and Request functions return this error to waiting command
+	EventAPIError
 )
 
 // Event to string
@@ -55,6 +59,8 @@ func (e Event) String() (str string) {
 		str = "EventDisconnected"
 	case EventData:
 		str = "EventData"
+	case EventAPIError:
+		str = "EventAPIError"
 	}
 	return
 }
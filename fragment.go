@@ -0,0 +1,235 @@
+// Copyright 2023 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet messages fragmentation and reassembly module
+
+package teonet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/teonet-go/tru"
+)
+
+const (
+	fragmentHeaderLen    = 4 + 2 + 2 // id, idx, num
+	defaultMaxMessageLen = 1024 * 1024
+	fragmentsTimeout     = 30 * time.Second
+
+	// Max number of incomplete messages of channel and max size of their
+	// received data in max message lengths. The oldest incomplete message
+	// drops when the limits exceeded
+	maxIncompleteMessages = 16
+	maxIncompleteSize     = 4
+)
+
+// ErrMessageTooLarge returns by Send when message is larger than MaxMessageLen
+var ErrMessageTooLarge = errors.New("message too large")
+
+// MaxMessageLen used in teonet.New parameter to set max length of message
+// which may be send or received. Messages larger than MaxDataLen are
+// fragmented and reassembled on the receiving side. Default 1 MiB
+type MaxMessageLen int
+
+// fragmenter contains fragmentation parameters and message id counter
+type fragmenter struct {
+	maxDataLen    int    // Max fragment length (include header)
+	maxMessageLen int    // Max message length
+	id            uint32 // Last message id
+}
+
+// newFragmenter create new fragmenter
+func newFragmenter(maxDataLen, maxMessageLen int) *fragmenter {
+	if maxDataLen <= 0 {
+		maxDataLen = new(tru.Packet).MaxDataLen()
+	}
+	return &fragmenter{maxDataLen: maxDataLen, maxMessageLen: maxMessageLen}
+}
+
+// needFragment return true if message should be send in fragments to channel
+func (f *fragmenter) needFragment(c Channel, message []byte) bool {
+	return c.capabilities().has(capFragment) && len(message) > f.maxDataLen
+}
+
+// fragments split message to fragment frames
+func (f *fragmenter) fragments(message []byte) (frags [][]byte) {
+	const headerLen = frameHeaderLen + fragmentHeaderLen
+	id := atomic.AddUint32(&f.id, 1)
+	size := f.maxDataLen - headerLen
+	num := (len(message) + size - 1) / size
+	for i := 0; i < num; i++ {
+		end := (i + 1) * size
+		if end > len(message) {
+			end = len(message)
+		}
+		frag := make([]byte, headerLen, headerLen+end-i*size)
+		frag[0] = byte(frameFragment)
+		binary.LittleEndian.PutUint32(frag[frameHeaderLen:], id)
+		binary.LittleEndian.PutUint16(frag[frameHeaderLen+4:], uint16(i))
+		binary.LittleEndian.PutUint16(frag[frameHeaderLen+6:], uint16(num))
+		frags = append(frags, append(frag, message[i*size:end]...))
+	}
+	return
+}
+
+// sendFragments send message to channel in fragment frames, returns first
+// fragment packet id. The delivery callback sets to last fragment
+func (c Channel) sendFragments(message []byte,
+	delivery func(p *tru.Packet, err error)) (id int, err error) {
+
+	frags := c.teo.fragmenter.fragments(message)
+	if len(frags) > 0xffff {
+		err = ErrMessageTooLarge
+		return
+	}
+	for i, frag := range frags {
+		var fragID int
+		if i == len(frags)-1 {
+			fragID, err = c.truChannel().WriteTo(frag, delivery)
+		} else {
+			fragID, err = c.truChannel().WriteTo(frag)
+		}
+		if err != nil {
+			return
+		}
+		if i == 0 {
+			id = fragID
+		}
+	}
+	return
+}
+
+// reassembler contains channels incomplete messages
+type reassembler struct {
+	m    map[uint32]*fragments
+	size int // Received data size of all incomplete messages
+	sync.Mutex
+}
+
+// fragments contains received fragments of message
+type fragments struct {
+	first    *tru.Packet // First fragment packet
+	parts    [][]byte    // Fragments data
+	received int         // Number of received fragments
+	size     int         // Received data size
+	started  time.Time   // First fragment received time
+}
+
+// newReassembler create new reassembler
+func newReassembler() *reassembler {
+	return &reassembler{m: make(map[uint32]*fragments)}
+}
+
+// reassemble check received frame and collect fragments. Returns wait true
+// if frame is fragment of incomplete message. When all fragments received
+// returns packet and frame type of full message
+func (teo *Teonet) reassemble(c *Channel, t frameType, p *Packet) (
+	pac *Packet, typ frameType, wait bool) {
+
+	pac, typ = p, t
+	if t != frameFragment {
+		return
+	}
+	wait = true
+	data := p.Data()
+	if len(data) < fragmentHeaderLen {
+		return
+	}
+
+	id := binary.LittleEndian.Uint32(data)
+	idx := int(binary.LittleEndian.Uint16(data[4:]))
+	num := int(binary.LittleEndian.Uint16(data[6:]))
+	data = data[fragmentHeaderLen:]
+
+	r := c.reasm
+	r.Lock()
+	defer r.Unlock()
+
+	// Remove expired incomplete messages
+	for k, f := range r.m {
+		if time.Since(f.started) > fragmentsTimeout {
+			log.Debugv.Println("drop expired incomplete message from", c)
+			r.del(k)
+		}
+	}
+
+	// Fragments of message received in order (tru is ordered), so new message
+	// starts from first fragment. Fragments of dropped message skipped here
+	f, ok := r.m[id]
+	if !ok {
+		if idx != 0 || num == 0 {
+			return
+		}
+		if len(r.m) >= maxIncompleteMessages {
+			log.Debugv.Println("too many incomplete messages from", c)
+			r.delOldest()
+		}
+		f = &fragments{parts: make([][]byte, num), started: time.Now()}
+		r.m[id] = f
+	}
+	if idx >= len(f.parts) || f.parts[idx] != nil {
+		return
+	}
+
+	// Check max message length
+	if f.size+len(data) > teo.fragmenter.maxMessageLen {
+		log.Error.Println("drop too large message from", c)
+		r.del(id)
+		return
+	}
+
+	// Check size of incomplete messages, the oldest messages drops
+	maxSize := maxIncompleteSize * teo.fragmenter.maxMessageLen
+	for r.size+len(data) > maxSize {
+		log.Debugv.Println("too large incomplete messages from", c)
+		if r.delOldest() == id {
+			return
+		}
+	}
+	f.size += len(data)
+	r.size += len(data)
+
+	// Save fragment
+	f.parts[idx] = data
+	f.received++
+	if idx == 0 {
+		f.first = p.Packet
+	}
+	if f.received < len(f.parts) {
+		return
+	}
+
+	// All fragments received
+	r.del(id)
+	pac = &Packet{Packet: f.first, from: p.from}
+	pac.SetData(bytes.Join(f.parts, nil))
+	typ = c.readFrame(pac)
+	wait = false
+	return
+}
+
+// del remove incomplete message
+func (r *reassembler) del(id uint32) {
+	if f, ok := r.m[id]; ok {
+		r.size -= f.size
+		delete(r.m, id)
+	}
+}
+
+// delOldest remove the oldest incomplete message and return its id
+func (r *reassembler) delOldest() (id uint32) {
+	var oldest *fragments
+	for k, f := range r.m {
+		if oldest == nil || f.started.Before(oldest.started) {
+			id, oldest = k, f
+		}
+	}
+	r.del(id)
+	return
+}
@@ -0,0 +1,109 @@
+// Test of messages fragmentation and reassembly
+package teonet
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/teonet-go/tru"
+	"github.com/teonet-go/tru/teolog"
+)
+
+func TestFragments(t *testing.T) {
+
+	if log == nil {
+		log = teolog.New()
+	}
+	teo := &Teonet{fragmenter: newFragmenter(64, 1024)}
+	c := &Channel{a: "test", conn: &channelConn{caps: capFragment | capFrames},
+		reasm: newReassembler()}
+
+	// reassemble received frame
+	reassemble := func(frame []byte) (*Packet, frameType, bool) {
+		p := &Packet{Packet: new(tru.Packet).SetData(frame), from: c.a}
+		return teo.reassemble(c, c.readFrame(p), p)
+	}
+
+	// Make message larger than fragment length
+	msg := make([]byte, 300)
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+
+	t.Run("Reassemble", func(t *testing.T) {
+		frags := teo.fragmenter.fragments(c.frame(frameData, msg))
+		if len(frags) != 6 {
+			t.Errorf("wrong number of fragments: %d", len(frags))
+			return
+		}
+		for i, frag := range frags {
+			if len(frag) > teo.fragmenter.maxDataLen {
+				t.Errorf("fragment %d too large: %d", i, len(frag))
+				return
+			}
+			pac, typ, wait := reassemble(frag)
+			if i < len(frags)-1 {
+				if !wait {
+					t.Errorf("message completed after fragment %d", i)
+				}
+				continue
+			}
+			if wait || typ != frameData || !bytes.Equal(pac.Data(), msg) {
+				t.Error("wrong reassembled message")
+			}
+		}
+	})
+
+	t.Run("NotFragment", func(t *testing.T) {
+		data := []byte("frag-\x01\x00\x00\x00\x00\x00\x02\x00")
+		pac, typ, wait := reassemble(c.frame(frameData, data))
+		if wait || typ != frameData || !bytes.Equal(pac.Data(), data) {
+			t.Error("not fragment packet processed")
+		}
+	})
+
+	t.Run("TooLarge", func(t *testing.T) {
+		frags := teo.fragmenter.fragments(make([]byte, 2048))
+		for _, frag := range frags {
+			if _, _, wait := reassemble(frag); !wait {
+				t.Error("too large message reassembled")
+			}
+		}
+		if len(c.reasm.m) != 0 {
+			t.Error("too large message does not removed")
+		}
+	})
+
+	// Number and size of incomplete messages are limited, the oldest
+	// messages drops
+	t.Run("Limits", func(t *testing.T) {
+		for i := 0; i < maxIncompleteMessages+1; i++ {
+			reassemble(teo.fragmenter.fragments(msg)[0])
+		}
+		if len(c.reasm.m) != maxIncompleteMessages {
+			t.Errorf("wrong number of incomplete messages: %d",
+				len(c.reasm.m))
+		}
+
+		// Send all but last fragments of large messages
+		for i := 0; i < maxIncompleteSize+1; i++ {
+			frags := teo.fragmenter.fragments(make([]byte,
+				teo.fragmenter.maxMessageLen))
+			for _, frag := range frags[:len(frags)-1] {
+				reassemble(frag)
+			}
+		}
+		maxSize := maxIncompleteSize * teo.fragmenter.maxMessageLen
+		if c.reasm.size > maxSize {
+			t.Errorf("wrong size of incomplete messages: %d", c.reasm.size)
+		}
+		size := 0
+		for _, f := range c.reasm.m {
+			size += f.size
+		}
+		if size != c.reasm.size {
+			t.Errorf("wrong incomplete messages size: %d, want: %d",
+				c.reasm.size, size)
+		}
+	})
+}
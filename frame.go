@@ -0,0 +1,82 @@
+// Copyright 2023 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet frames module: messages sent to peers which support frames start
+// with frame type byte, so teonet service frames are carried out of band and
+// application data is never reinterpreted as service frame
+
+package teonet
+
+// frameType is first byte of messages sent to peers with capFrames capability
+type frameType byte
+
+const (
+	// frameData - application data
+	frameData frameType = iota
+
+	// frameHeartbeat - heartbeat: send time
+	frameHeartbeat
+
+	// frameHeartbeatAnswer - heartbeat answer: send time from heartbeat
+	frameHeartbeatAnswer
+
+	// frameFragment - fragment of large message: id, index, number, data
+	frameFragment
+
+	// frameStream - stream frame: stream frame type, id, payload
+	frameStream
+
+	// frameRPCRequest - request: id, cmd, data
+	frameRPCRequest
+
+	// frameRPCReply - reply to request: id, data
+	frameRPCReply
+
+	// frameRPCError - api error answer to request: id, error
+	frameRPCError
+
+	// frameRPCStream - streaming request: id, credits, cmd, data
+	frameRPCStream
+
+	// frameRPCPart - streaming reply part: id, data
+	frameRPCPart
+
+	// frameRPCEnd - end of streaming reply: id
+	frameRPCEnd
+
+	// frameRPCCredits - credits granted by streaming requester: id, credits
+	frameRPCCredits
+
+	// frameRPCCancel - streaming request canceled by requester: id
+	frameRPCCancel
+
+	// frameAPIError - api error answer to command: cmd, packet id, error
+	frameAPIError
+)
+
+// frameHeaderLen is length of frame type header
+const frameHeaderLen = 1
+
+// frame return message of frame type t with data. Frame type header adds if
+// peer supports frames only, old peers receive data as is
+func (c Channel) frame(t frameType, data []byte) []byte {
+	if !c.capabilities().has(capFrames) {
+		return data
+	}
+	frame := make([]byte, frameHeaderLen, frameHeaderLen+len(data))
+	frame[0] = byte(t)
+	return append(frame, data...)
+}
+
+// readFrame return frame type of received packet and remove frame type header
+// from packet data. Packets from old peers are application data
+func (c Channel) readFrame(p *Packet) (t frameType) {
+	data := p.Data()
+	if !c.capabilities().has(capFrames) || len(data) == 0 {
+		return frameData
+	}
+	t = frameType(data[0])
+	p.SetData(data[1:])
+	return
+}
@@ -0,0 +1,66 @@
+// Test of teonet frames
+package teonet
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestFrames(t *testing.T) {
+
+	// Application data which looks like old in-band service frames receives
+	// as is by peers with caps
+	send := func(t *testing.T, caps peerCaps) {
+		srv, err := New("test-server", OsConfigDir(t.TempDir()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer srv.Close()
+		cli, err := New("test-client", OsConfigDir(t.TempDir()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cli.Close()
+		c, s := truLink(t, cli, srv)
+		c.setCaps(caps)
+		s.setCaps(caps)
+		cli.SetConnected(c, srv.Address())
+		srv.SetConnected(s, cli.Address())
+
+		received := make(chan []byte, 1)
+		_, err = srv.Subscribe(cli.Address(), func(c *Channel, p *Packet, e *Event) bool {
+			if e.Event == EventData {
+				received <- append([]byte(nil), p.Data()...)
+				return true
+			}
+			return false
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, data := range [][]byte{
+			[]byte("hbeat-\x00\x00\x00\x00\x00\x00\x00\x00"),
+			[]byte("frag-\x01\x00\x00\x00\x00\x00\x02\x00"),
+			[]byte("strm-\x01\x01\x00\x00\x00"),
+			[]byte("rpca-\x01\x00\x00\x00"),
+			{byte(frameHeartbeat)},
+			{byte(frameRPCReply), 1, 0, 0, 0},
+		} {
+			cli.SendTo(srv.Address(), data)
+			select {
+			case d := <-received:
+				if !bytes.Equal(d, data) {
+					t.Errorf("wrong data received: %q, want %q", d, data)
+				}
+			case <-time.After(time.Second):
+				t.Errorf("data %q does not received", data)
+			}
+		}
+	}
+	t.Run("Frames", func(t *testing.T) { send(t, localCaps) })
+
+	// Old peers send and receive data without frame type header
+	t.Run("OldPeer", func(t *testing.T) { send(t, 0) })
+}
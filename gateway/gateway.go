@@ -0,0 +1,333 @@
+// Copyright 2023 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet gateway package: HTTP/JSON gateway which exposes teonet peers API
+// commands as REST endpoints.
+//
+// The gateway serves:
+//
+//	GET  /                  - index of available peers APIs
+//	GET  /{alias}           - peer API in OpenAPI style JSON document
+//	POST /{alias}/{command} - execute peer API command
+//
+// The POST request body sends to peer as command data. If command has
+// parameters schema and request Content-Type is application/json, the json
+// object encodes to command data by the schema. The command answer returns
+// in response body, it converts to json object when command has return data
+// schema. Teonet api errors returns as json object with error code and
+// message.
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/teonet-go/teonet"
+)
+
+// DefaultTimeout is default command answer timeout
+const DefaultTimeout = 5 * time.Second
+
+// MaxBodySize is max size of POST request body
+const MaxBodySize = 1 << 20
+
+// ErrAliasExists returns by AddPeer when alias already added
+var ErrAliasExists = errors.New("peer alias already exists")
+
+// Gateway is teonet HTTP/JSON gateway, it implements http.Handler
+type Gateway struct {
+	teo     *teonet.Teonet
+	timeout time.Duration
+	peers   map[string]*peer
+	sync.RWMutex
+}
+
+// peer is gateway peer data
+type peer struct {
+	alias   string
+	address string
+	api     *teonet.APIClient
+}
+
+// Index is gateway index element
+type Index struct {
+	Alias    string   `json:"alias"`
+	Address  string   `json:"address"`
+	Name     string   `json:"name"`
+	Short    string   `json:"short"`
+	Long     string   `json:"long"`
+	Version  string   `json:"version"`
+	Commands []string `json:"commands"`
+}
+
+// Error is gateway error response
+type Error struct {
+	Code    int    `json:"code,omitempty"`
+	Error   string `json:"error"`
+	Details string `json:"details,omitempty"`
+}
+
+// New create new gateway. The timeout is command answer timeout, the
+// DefaultTimeout used if timeout is 0
+func New(teo *teonet.Teonet, timeout time.Duration) *Gateway {
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	return &Gateway{
+		teo:     teo,
+		timeout: timeout,
+		peers:   make(map[string]*peer),
+	}
+}
+
+// AddPeer add peer to gateway. The peer should be connected before this
+// call. The peer API gets from peer by teonet.NewAPIClient
+func (g *Gateway) AddPeer(alias, address string) (err error) {
+	api, err := g.teo.NewAPIClient(address)
+	if err != nil {
+		return
+	}
+
+	g.Lock()
+	defer g.Unlock()
+	if _, ok := g.peers[alias]; ok {
+		err = ErrAliasExists
+		return
+	}
+	g.peers[alias] = &peer{alias, address, api}
+	return
+}
+
+// DelPeer remove peer from gateway
+func (g *Gateway) DelPeer(alias string) {
+	g.Lock()
+	defer g.Unlock()
+	if p, ok := g.peers[alias]; ok {
+		p.api.Close()
+		delete(g.peers, alias)
+	}
+}
+
+// Index return gateway peers index sorted by alias
+func (g *Gateway) Index() (index []Index) {
+	g.RLock()
+	defer g.RUnlock()
+
+	index = []Index{}
+	for _, p := range g.peers {
+		ar := p.api.Snapshot()
+		idx := Index{
+			Alias:    p.alias,
+			Address:  p.address,
+			Name:     ar.AppName(),
+			Short:    ar.AppShort(),
+			Long:     ar.AppLong(),
+			Version:  ar.AppVersion(),
+			Commands: []string{},
+		}
+		for _, a := range ar.Apis {
+			idx.Commands = append(idx.Commands, a.Name())
+		}
+		index = append(index, idx)
+	}
+	sort.Slice(index, func(i, j int) bool {
+		return index[i].Alias < index[j].Alias
+	})
+	return
+}
+
+// get return peer by alias
+func (g *Gateway) get(alias string) (p *peer, ok bool) {
+	g.RLock()
+	defer g.RUnlock()
+	p, ok = g.peers[alias]
+	return
+}
+
+// ServeHTTP process gateway HTTP requests
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+
+	// Index
+	case len(path) == 1 && path[0] == "":
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, nil)
+			return
+		}
+		writeJSON(w, http.StatusOK, g.Index())
+
+	// Peer API
+	case len(path) == 1:
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, nil)
+			return
+		}
+		p, ok := g.get(path[0])
+		if !ok {
+			writeError(w, http.StatusNotFound, nil)
+			return
+		}
+		data, err := p.api.Snapshot().ExportJSON()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+
+	// Peer API command
+	case len(path) == 2:
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, nil)
+			return
+		}
+		p, ok := g.get(path[0])
+		if !ok {
+			writeError(w, http.StatusNotFound, nil)
+			return
+		}
+		g.execute(w, r, p, path[1])
+
+	default:
+		writeError(w, http.StatusNotFound, nil)
+	}
+}
+
+// execute peer API command and write answer
+func (g *Gateway) execute(w http.ResponseWriter, r *http.Request, p *peer,
+	command string) {
+
+	if _, _, err := p.api.GetCmdExt(command); err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	// Read request body and make command data
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxBodySize))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+	data, err := commandData(p.api, command, r.Header.Get("Content-Type"), body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// Send command without answer
+	if mode, _ := p.api.AnswerMode(command); mode == teonet.NoAnswer {
+		if _, err = p.api.SendTo(command, data); err != nil {
+			writeTeonetError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// Send request and wait answer in command answer mode
+	ctx, cancel := context.WithTimeout(r.Context(), g.timeout)
+	defer cancel()
+	answer, err := p.api.Request(ctx, command, data)
+	if err != nil {
+		writeTeonetError(w, err)
+		return
+	}
+
+	// Write answer
+	if _, ok := p.api.Returns(command); ok {
+		values, err := p.api.DecodeReturn(command, answer)
+		if err != nil {
+			writeError(w, http.StatusBadGateway, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, values)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(answer)
+}
+
+// commandData return command data from request body. The json body encodes
+// by command parameters schema if it exists
+func commandData(api *teonet.APIClient, command, contentType string,
+	body []byte) (data []byte, err error) {
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if _, ok := api.Params(command); !ok || mediaType != "application/json" {
+		data = body
+		return
+	}
+
+	var args map[string]interface{}
+	if err = json.Unmarshal(body, &args); err != nil {
+		return
+	}
+	return api.EncodeArgs(command, args)
+}
+
+// writeTeonetError write teonet request error
+func writeTeonetError(w http.ResponseWriter, err error) {
+	var apiErr *teonet.APIError
+	switch {
+	case errors.As(err, &apiErr):
+		writeJSON(w, apiErrorStatus(apiErr.Code), Error{
+			Code:    int(apiErr.Code),
+			Error:   apiErr.Message,
+			Details: string(apiErr.Details),
+		})
+	case errors.Is(err, teonet.ErrTimeout),
+		errors.Is(err, context.DeadlineExceeded):
+		writeError(w, http.StatusGatewayTimeout, err)
+	case errors.Is(err, teonet.ErrPeerNotConnected):
+		writeError(w, http.StatusServiceUnavailable, err)
+	default:
+		writeError(w, http.StatusBadGateway, err)
+	}
+}
+
+// apiErrorStatus return HTTP status code of teonet api error code
+func apiErrorStatus(code teonet.APIErrorCode) int {
+	switch code {
+	case teonet.APIErrUnknownCommand:
+		return http.StatusNotFound
+	case teonet.APIErrBadArgument:
+		return http.StatusBadRequest
+	case teonet.APIErrNotAuthorized:
+		return http.StatusForbidden
+	case teonet.APIErrFailed:
+		return http.StatusUnprocessableEntity
+	case teonet.APIErrTimeout:
+		return http.StatusGatewayTimeout
+	case teonet.APIErrBusy:
+		return http.StatusTooManyRequests
+	case teonet.APIErrInternal:
+		return http.StatusInternalServerError
+	}
+	return http.StatusBadGateway
+}
+
+// writeError write error response with status text if err is nil
+func writeError(w http.ResponseWriter, status int, err error) {
+	e := Error{Error: http.StatusText(status)}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	writeJSON(w, status, e)
+}
+
+// writeJSON write json response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,134 @@
+// Test of teonet gateway with local peers
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/teonet-go/teonet"
+)
+
+func TestGateway(t *testing.T) {
+
+	// Server peer with API
+	srv, err := teonet.New("test-server", teonet.OsConfigDir(t.TempDir()),
+		teonet.DirectConnect(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	api := srv.NewAPI("test", "test", "test api", "0.0.1")
+	idCmd := teonet.MakeAPI2().SetName("id").SetCmd(132).
+		SetConnectMode(teonet.AnyMode).
+		SetAnswerMode(teonet.CmdAnswer | teonet.PacketIDAnswer)
+	idCmd.SetReader(func(c *teonet.Channel, p *teonet.Packet, data []byte) bool {
+		api.SendAnswer(idCmd, c, []byte("id answer"), p)
+		return true
+	})
+	api.Add(
+		teonet.MakeAPI2().SetName("echo").SetCmd(129).
+			SetConnectMode(teonet.AnyMode).
+			SetReader(func(c *teonet.Channel, p *teonet.Packet, data []byte) bool {
+				c.Reply(p, data)
+				return true
+			}),
+		teonet.MakeAPI2().SetName("sum").SetCmd(130).
+			SetConnectMode(teonet.AnyMode).
+			SetParams(teonet.Schema{Encoding: teonet.EncodingJSON,
+				Fields: []teonet.SchemaField{
+					{Name: "a", Type: teonet.SchemaInt},
+					{Name: "b", Type: teonet.SchemaInt},
+				}}).
+			SetReturns(teonet.Schema{Encoding: teonet.EncodingJSON,
+				Fields: []teonet.SchemaField{
+					{Name: "sum", Type: teonet.SchemaInt},
+				}}).
+			SetReader(func(c *teonet.Channel, p *teonet.Packet, data []byte) bool {
+				var args struct{ A, B int }
+				if err := json.Unmarshal(data, &args); err != nil {
+					api.SendError(c, p, teonet.ErrAPIBadArgument)
+					return true
+				}
+				c.Reply(p, []byte(fmt.Sprintf(`{"sum":%d}`, args.A+args.B)))
+				return true
+			}),
+		teonet.MakeAPI2().SetName("fail").SetExtCmd(1).
+			SetConnectMode(teonet.AnyMode).
+			SetReader(func(c *teonet.Channel, p *teonet.Packet, data []byte) bool {
+				api.SendError(c, p, teonet.NewAPIError(teonet.APIErrNotAuthorized,
+					"not authorized", nil))
+				return true
+			}),
+		teonet.MakeAPI2().SetName("silent").SetCmd(131).
+			SetConnectMode(teonet.AnyMode).
+			SetReader(func(c *teonet.Channel, p *teonet.Packet, data []byte) bool {
+				return true
+			}),
+		idCmd,
+	)
+	srv.AddReader(api.Reader())
+
+	// Gateway peer
+	teo, err := teonet.New("test-gateway", teonet.OsConfigDir(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teo.Close()
+	addr, err := teo.ConnectDirect(fmt.Sprintf("127.0.0.1:%d", srv.Port()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw := New(teo, 500*time.Millisecond)
+	if err = gw.AddPeer("test", addr); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(gw)
+	defer ts.Close()
+
+	for _, test := range []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		body        string
+		status      int
+		want        string
+	}{
+		{"Index", "GET", "/", "", "", 200, `"commands":["api","echo","sum","fail","silent","id"]`},
+		{"API", "GET", "/test", "", "", 200, `"openapi": "3.0.3"`},
+		{"Echo", "POST", "/test/echo", "", "hello", 200, "hello"},
+		{"Schema", "POST", "/test/sum", "application/json", `{"a":1,"b":2}`, 200, `{"sum":3}`},
+		{"BadArgument", "POST", "/test/sum", "application/json", `{"a":1}`, 400, ""},
+		{"APIError", "POST", "/test/fail", "", "", 403, `"error":"not authorized"`},
+		{"AnswerMode", "POST", "/test/id", "", "", 200, "id answer"},
+		{"Timeout", "POST", "/test/silent", "", "", 504, ""},
+		{"UnknownPeer", "POST", "/unknown/echo", "", "", 404, ""},
+		{"UnknownCommand", "POST", "/test/unknown", "", "", 404, ""},
+		{"Method", "GET", "/test/echo", "", "", 405, ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req, _ := http.NewRequest(test.method, ts.URL+test.path,
+				strings.NewReader(test.body))
+			if test.contentType != "" {
+				req.Header.Set("Content-Type", test.contentType)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != test.status ||
+				!strings.Contains(string(body), test.want) {
+				t.Errorf("wrong response: %d %s", resp.StatusCode, body)
+			}
+		})
+	}
+}
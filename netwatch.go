@@ -0,0 +1,103 @@
+// Copyright 2023 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet network change detection and connection migration module
+
+package teonet
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/teonet-go/tru"
+)
+
+const defaultNetworkCheckInterval = 2 * time.Second
+
+// NetworkCheckInterval used in teonet.New parameter to set interval between
+// checks of local interfaces addresses. When addresses changed (f.e. host
+// switched from Wi-Fi to LTE) teonet reconnects to auth server and moves
+// connected peers channels to new network path. Default 2 seconds, set 0 to
+// switch network changes detection off
+type NetworkCheckInterval time.Duration
+
+// netwatchProcess periodically check local IPs returned by localIPs and
+// migrate connections when they changed
+func (teo *Teonet) netwatchProcess(interval time.Duration,
+	localIPs func() string) {
+
+	if interval <= 0 {
+		return
+	}
+
+	ips := localIPs()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-teo.closing:
+			return
+		case <-ticker.C:
+			newIPs := localIPs()
+			if newIPs == ips {
+				continue
+			}
+			log.Connect.Println("network changed, local ips:", newIPs)
+			ips = newIPs
+			teo.networkChanged()
+		}
+	}
+}
+
+// netwatchIPs return sorted local IPs in string
+func (teo *Teonet) netwatchIPs() string {
+	ips, _ := teo.getIPs()
+	sort.Strings(ips)
+	return strings.Join(ips, ",")
+}
+
+// networkChanged reconnect to teonet auth server and migrate connected peers
+func (teo *Teonet) networkChanged() {
+
+	// Reconnect to teonet if connected
+	auth := teo.getAuth()
+	connected := auth != nil && !auth.IsNew()
+	if connected {
+		// Close auth channel, the teonet reconnect starts in auth channel
+		// subscriber
+		auth.truChannel().Close()
+
+		// Wait teonet reconnected
+		const checkConnectedAfter = 50 * time.Millisecond
+		for start := time.Now(); ; time.Sleep(checkConnectedAfter) {
+			if a := teo.getAuth(); a != nil && a != auth && !a.IsNew() {
+				break
+			}
+			if time.Since(start) > tru.ClientConnectTimeout {
+				log.Connect.Println("can't reconnect to teonet after network changed")
+				return
+			}
+		}
+	}
+
+	// Migrate connected peers: peers connected by ConnectDirect reconnect
+	// by ip:port, other peers reconnect by teonet
+	for _, c := range teo.channels.peersChannels() {
+		if c.IsNew() || c.direct == "" && !connected {
+			continue
+		}
+		go func(c *Channel) {
+			var err error
+			if c.direct != "" {
+				_, err = teo.connectDirectTo(c.direct, true)
+			} else {
+				err = teo.connectTo(c.a, true)
+			}
+			if err != nil {
+				log.Connect.Println("can't migrate peer", c.a, "error:", err)
+			}
+		}(c)
+	}
+}
@@ -0,0 +1,202 @@
+// Test of peers connections migration
+package teonet
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Peer with the same key connected from new ip:port moves existing channel
+// to new connection, subscribers and readers of channel does not changed
+func TestMigrate(t *testing.T) {
+	srv, err := New("test-server", OsConfigDir(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	api := srv.NewAPI("ping", "ping", "ping api", "0.0.1")
+	ping := MakeAPI2().SetName("ping").SetCmd(129).SetAnswerMode(CmdAnswer)
+	api.Add(ping.SetReader(func(c *Channel, p *Packet, data []byte) bool {
+		api.SendAnswer(ping, c, []byte("pong"), p)
+		return true
+	}))
+	srv.AddReader(api.Reader())
+
+	// Client connected before and after network changed. Clients use the
+	// same config, so they have the same address
+	dir := t.TempDir()
+	newClient := func() *Teonet {
+		cli, err := New("test-client", OsConfigDir(dir))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cli
+	}
+	cli := newClient()
+	defer cli.Close()
+	linkPeers(t, cli, srv)
+	addr := cli.Address()
+
+	// Subscribe to client channel events
+	var events []string
+	var mu sync.Mutex
+	received := make(chan struct{}, 1)
+	_, err = srv.Subscribe(addr, func(c *Channel, p *Packet, e *Event) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if e.Event != EventData {
+			events = append(events, e.String())
+			return false
+		}
+		if string(p.Data()) == "after" {
+			received <- struct{}{}
+			return true
+		}
+		return false
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, _ := srv.Channel(addr)
+	old := c.truChannel()
+
+	// Connect from new ip:port, server migrates existing channel
+	moved := newClient()
+	defer moved.Close()
+	if moved.Address() != addr {
+		t.Fatalf("wrong moved client address: %s", moved.Address())
+	}
+	mc, sc := truLink(t, moved, srv)
+	moved.SetConnected(mc, srv.Address())
+	srv.setConnected(sc, addr, true)
+
+	ch, ok := srv.Channel(addr)
+	if !ok || ch != c || ch.truChannel() == old ||
+		ch.truChannel() != sc.truChannel() {
+		t.Fatal("channel does not migrated")
+	}
+	if n := srv.SubscribersNum(); n != 1 {
+		t.Errorf("wrong number of subscribers: %d", n)
+	}
+
+	// Subscriber receives data from new connection
+	moved.SendTo(srv.Address(), []byte("after"))
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Error("subscriber does not received data after migration")
+	}
+
+	// Api reader answers to new connection
+	moved.Command(129, nil).SendTo(srv.Address())
+	if data, err := moved.WaitFrom(srv.Address(), 129, time.Second); err != nil ||
+		string(data) != "pong" {
+		t.Errorf("wrong answer after migration: %s, err: %v", data, err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) > 0 {
+		t.Errorf("events sent on migration: %v", events)
+	}
+}
+
+// Network change detected by netwatch moves peer connected by ConnectDirect to
+// new network path
+func TestNetwatch(t *testing.T) {
+	srv, err := New("test-server", OsConfigDir(t.TempDir()), DirectConnect(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	cli, err := New("test-client", OsConfigDir(t.TempDir()),
+		NetworkCheckInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	// Server reachable by other local ip after network changed
+	var ip string
+	ips, _ := cli.getIPs()
+	for _, a := range ips {
+		if v := net.ParseIP(a); v != nil && v.To4() != nil && !v.IsLoopback() {
+			ip = a
+			break
+		}
+	}
+	if ip == "" {
+		t.Skip("local ip does not found")
+	}
+
+	addr, err := cli.ConnectDirect(fmt.Sprintf("127.0.0.1:%d", srv.Port()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []string
+	var mu sync.Mutex
+	received := make(chan []byte, 1)
+	_, err = srv.Subscribe(cli.Address(), func(c *Channel, p *Packet, e *Event) bool {
+		if e.Event == EventData {
+			received <- append([]byte(nil), p.Data()...)
+			return true
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, e.String())
+		return false
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, _ := cli.channels.get(addr)
+	s, _ := srv.channels.get(cli.Address())
+	oldc, olds := c.truChannel(), s.truChannel()
+	c.direct = fmt.Sprintf("%s:%d", ip, srv.Port())
+
+	// Change local ips returned to netwatch
+	var changed atomic.Bool
+	go cli.netwatchProcess(10*time.Millisecond, func() string {
+		if changed.Load() {
+			return "new"
+		}
+		return "old"
+	})
+	time.Sleep(20 * time.Millisecond)
+	changed.Store(true)
+
+	// Wait channels migrated on both sides
+	for start := time.Now(); c.truChannel() == oldc || s.truChannel() == olds; {
+		if time.Since(start) > time.Second {
+			t.Fatal("channels does not migrated")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if ch, _ := cli.channels.get(addr); ch != c {
+		t.Error("client channel changed")
+	}
+	if ch, _ := srv.channels.get(cli.Address()); ch != s {
+		t.Error("server channel changed")
+	}
+
+	// Data sent by new path
+	cli.SendTo(addr, []byte("after"))
+	select {
+	case data := <-received:
+		if string(data) != "after" {
+			t.Errorf("wrong data received: %q", data)
+		}
+	case <-time.After(time.Second):
+		t.Error("data does not received after migration")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) > 0 {
+		t.Errorf("events sent on migration: %v", events)
+	}
+}
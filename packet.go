@@ -13,6 +13,10 @@ type Packet struct {
 	*tru.Packet
 	from        string
 	commandMode bool
+	rpc         bool   // Packet is request received from Request function
+	rpcID       uint32 // Request correlation id
+	rpcStream   bool   // Packet is request received from RequestStream
+	rpcCredits  uint32 // Streaming request initial credits
 }
 
 // From return packets from address
@@ -36,6 +40,12 @@ func (p Packet) Data() []byte {
 	return p.Packet.Data()
 }
 
+// RequestID return request correlation id if packet is request received
+// from Request function
+func (p Packet) RequestID() (id uint32, ok bool) {
+	return p.rpcID, p.rpc
+}
+
 // setCommandMode set packet command mode, that mean that packet contain
 // command + data
 func (p *Packet) setCommandMode() *Packet {
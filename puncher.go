@@ -16,13 +16,15 @@ import (
 	"github.com/teonet-go/tru"
 )
 
-// Allow IPv6 connection between peers
+// Allow IPv6 connection between peers. Use CandidatePolicy Family to select
+// address family in runtime
 const IPv6Allow = true
 
 // puncher struct and methods receiver
 type puncher struct {
-	tru *tru.Tru
-	m   map[string]*PuncherData
+	tru   *tru.Tru
+	m     map[string]*PuncherData
+	allow func(ip string) bool // check remote ip allowed by candidate policy
 	sync.RWMutex
 }
 
@@ -37,15 +39,20 @@ type IPs struct {
 	LocalPort uint32
 	IP        string
 	Port      uint32
+	Endpoints []string // Custom ip:port endpoints
 }
 
-// getIPs return string slice with this host local IPs address
+// getIPs return string slice with this host local IPs address selected by
+// candidate policy and custom endpoints IPs
 func (teo Teonet) getIPs() (ips []string, err error) {
 	ifaces, err := net.Interfaces()
 	if err != nil {
 		return
 	}
 	for _, i := range ifaces {
+		if !teo.candidates.allowInterface(i.Name) {
+			continue
+		}
 		addrs, err := i.Addrs()
 		if err != nil {
 			continue
@@ -58,6 +65,9 @@ func (teo Teonet) getIPs() (ips []string, err error) {
 			case *net.IPAddr:
 				ip = v.IP
 			}
+			if ip == nil || !teo.candidates.allowLocal(ip) {
+				continue
+			}
 			a := ip.String()
 			// Check ipv6 address, add [ ... ] if ipv6 allowed and
 			// skip this address if ipv6 not allowed
@@ -68,12 +78,32 @@ func (teo Teonet) getIPs() (ips []string, err error) {
 			ips = append(ips, a)
 		}
 	}
+	teo.candidates.sort(ips)
+
+	// Add custom endpoints IPs
+	extra, _ := teo.candidates.endpoints()
+	for _, a := range extra {
+		a, _ = teo.safeIPv6(a)
+		ips = append(ips, a)
+	}
+	return
+}
+
+// getEndpoints return custom ip:port endpoints from candidate policy
+func (teo Teonet) getEndpoints() (endpoints []string) {
+	_, endpoints = teo.candidates.endpoints()
 	return
 }
 
 // safeIPv6 check ipv6 address and add [ ... ] to it, set ok to true if address
 // is IPv6
 func (teo Teonet) safeIPv6(ipin string) (ipout string, ok bool) {
+	return safeIPv6(ipin)
+}
+
+// safeIPv6 check ipv6 address and add [ ... ] to it, set ok to true if address
+// is IPv6
+func safeIPv6(ipin string) (ipout string, ok bool) {
 	if strings.IndexByte(ipin, ':') >= 0 {
 		ok = true
 		ipout = "[" + ipin + "]"
@@ -89,7 +119,8 @@ func (teo *Teonet) newPuncher() {
 	if teo.tru == nil {
 		panic("trudp should be Init befor call to newPuncher()")
 	}
-	teo.puncher = &puncher{tru: teo.tru, m: make(map[string]*PuncherData)}
+	teo.puncher = &puncher{tru: teo.tru, m: make(map[string]*PuncherData),
+		allow: teo.candidates.allowRemote}
 
 	// Connect puncher to TRU - set punch callback
 	teo.tru.SetPunchCb(func(addr net.Addr, data []byte) {
@@ -139,8 +170,23 @@ func (p *puncher) send(key string, ips IPs, stop ...func() bool) (err error) {
 		if len(stop) > 0 && stop[0]() {
 			return
 		}
+		if !p.allow(ips.LocalIPs[i]) {
+			continue
+		}
 		sendKey(ips.LocalIPs[i], ips.LocalPort)
 	}
+	for i := range ips.Endpoints {
+		if len(stop) > 0 && stop[0]() {
+			return
+		}
+		ip, port, err := net.SplitHostPort(ips.Endpoints[i])
+		if err != nil || !p.allow(ip) {
+			continue
+		}
+		portNum, _ := strconv.Atoi(port)
+		ip, _ = safeIPv6(ip)
+		sendKey(ip, uint32(portNum))
+	}
 	sendKey(ips.IP, ips.Port)
 
 	return
@@ -0,0 +1,407 @@
+// Copyright 2023 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet services registry module: peers register its API name and versions
+// in registry peer, clients find live peers addresses by API name and
+// version range.
+//
+// Any peer may host registry (NewRegistry function). Other peers set
+// registry address by RegistryAddress parameter of teonet.New or by
+// SetRegistry function. Registrations expire after TTL and removes when
+// registered peer disconnected from registry, the API.Register renews
+// registration in background.
+
+package teonet
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kirill-scherba/bslice"
+)
+
+// Registry commands
+const (
+	cmdRegistryRegister ExtCmd = CmdReservedFirst + iota
+	cmdRegistryUnregister
+	cmdRegistryFind
+)
+
+// Registration TTL limits
+const (
+	DefaultRegistryTTL = 30 * time.Second
+	minRegistryTTL     = time.Second
+	maxRegistryTTL     = time.Hour
+)
+
+// ErrRegistryNotSet returns when registry address does not set and this peer
+// does not host registry
+var ErrRegistryNotSet = errors.New("registry address does not set")
+
+// RegistryAddress used in teonet.New parameter to set services registry
+// peer address
+type RegistryAddress string
+
+// registry contains registry address, hosted registry and this peer
+// registrations
+type registry struct {
+	address string                 // Registry peer address
+	server  *Registry              // Registry hosted by this peer
+	regs    map[*API]chan struct{} // Registered APIs renew stop channels
+	sync.RWMutex
+}
+
+// Registry is services registry hosted by teonet peer
+type Registry struct {
+	services map[string]map[string]*registryEntry // Entries by name and address
+	sync.Mutex
+}
+
+// registryEntry is registered service
+type registryEntry struct {
+	versions []string
+	expires  time.Time
+}
+
+// registryRequest is register, unregister and find request data
+type registryRequest struct {
+	name     string
+	versions []string      // Versions for register, version range for find
+	ttl      time.Duration // Registration TTL
+	bslice.ByteSlice
+}
+
+// newRegistry create teonet registry holder
+func (teo *Teonet) newRegistry(address string) {
+	teo.registry = &registry{address: address, regs: make(map[*API]chan struct{})}
+}
+
+// SetRegistry set services registry peer address
+func (teo *Teonet) SetRegistry(address string) {
+	teo.registry.Lock()
+	defer teo.registry.Unlock()
+	teo.registry.address = address
+}
+
+// NewRegistry create services registry hosted by this peer. Peers register
+// in it by API.Register and find services by FindServices functions
+func (teo *Teonet) NewRegistry() (r *Registry) {
+	r = &Registry{services: make(map[string]map[string]*registryEntry)}
+	teo.registry.Lock()
+	defer teo.registry.Unlock()
+	teo.registry.server = r
+	return
+}
+
+// Register register API short name and versions in services registry and
+// renews registration in background until Unregister called or teonet
+// closed. The attr parameters:
+//
+//	string          registry address, registry set in teonet used by default
+//	time.Duration   registration TTL, DefaultRegistryTTL by default
+//
+// Returns first registration error, registration renews in background even
+// if first registration failed
+func (a *API) Register(attr ...interface{}) (err error) {
+	address, ttl := "", DefaultRegistryTTL
+	for i := range attr {
+		switch v := attr[i].(type) {
+		case string:
+			address = v
+		case time.Duration:
+			ttl = v
+		}
+	}
+	if ttl < minRegistryTTL {
+		ttl = minRegistryTTL
+	}
+
+	// Stop previous registration renew
+	stop := make(chan struct{})
+	r := a.registry
+	r.Lock()
+	if old, ok := r.regs[a]; ok {
+		close(old)
+	}
+	r.regs[a] = stop
+	r.Unlock()
+
+	req := registryRequest{name: a.short, versions: a.Versions(), ttl: ttl}
+	data, _ := req.MarshalBinary()
+	register := func() (err error) {
+		_, err = a.registryCall(address, cmdRegistryRegister, data)
+		return
+	}
+	err = register()
+
+	// Register again when registry peer reconnected
+	idx := a.clientReaders.addShort(func(c *Channel, p *Packet, e *Event) bool {
+		if e.Event == EventConnected && c.Address() == a.registryAddress(address) {
+			go register()
+		}
+		return false
+	})
+
+	// Renew registration
+	go func() {
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		defer a.clientReaders.del(idx)
+		for {
+			select {
+			case <-ticker.C:
+				if err := register(); err != nil {
+					log.Debug.Println("can't renew api", a.short,
+						"registration, err:", err)
+				}
+			case <-stop:
+				return
+			case <-a.closing:
+				return
+			}
+		}
+	}()
+	return
+}
+
+// Unregister stop API registration renew and remove it from services
+// registry
+func (a *API) Unregister(attr ...interface{}) (err error) {
+	address := ""
+	for i := range attr {
+		if v, ok := attr[i].(string); ok {
+			address = v
+		}
+	}
+
+	r := a.registry
+	r.Lock()
+	if stop, ok := r.regs[a]; ok {
+		close(stop)
+		delete(r.regs, a)
+	}
+	r.Unlock()
+
+	req := registryRequest{name: a.short}
+	data, _ := req.MarshalBinary()
+	_, err = a.registryCall(address, cmdRegistryUnregister, data)
+	return
+}
+
+// FindServices return addresses of live peers registered API with name and
+// version matching range. Empty range matches any version
+func (teo *Teonet) FindServices(ctx context.Context, name string,
+	versionRange APIVersionRange) (addresses []string, err error) {
+
+	req := registryRequest{name: name, versions: []string{string(versionRange)}}
+	data, _ := req.MarshalBinary()
+	data, err = teo.registryCall(ctx, "", cmdRegistryFind, data)
+	if err != nil {
+		return
+	}
+	var list registryRequest
+	addresses, err = list.ReadStringSlice(bytes.NewBuffer(data))
+	return
+}
+
+// registryCall execute registry command in registry peer or in registry
+// hosted by this peer
+func (teo *Teonet) registryCall(ctx context.Context, address string,
+	cmd ExtCmd, data []byte) (reply []byte, err error) {
+
+	address = teo.registryAddress(address)
+	teo.registry.RLock()
+	server := teo.registry.server
+	teo.registry.RUnlock()
+
+	switch {
+	case server != nil && (address == "" || address == teo.Address()):
+		return server.exec(teo.Address(), cmd, data)
+	case address == "":
+		err = ErrRegistryNotSet
+		return
+	}
+	return teo.RequestAPI(ctx, address, Command{Cmd: CmdExtended, Ext: cmd,
+		Data: data}, CmdAnswer)
+}
+
+// registryAddress return registry address or registry address set in
+// teonet if address is empty
+func (teo *Teonet) registryAddress(address string) string {
+	if address != "" {
+		return address
+	}
+	teo.registry.RLock()
+	defer teo.registry.RUnlock()
+	return teo.registry.address
+}
+
+// registryCall execute registry command with default timeout
+func (a *API) registryCall(address string, cmd ExtCmd, data []byte) (
+	reply []byte, err error) {
+	return a.Teonet.registryCall(context.Background(), address, cmd, data)
+}
+
+// processRegistry check received message and execute registry commands if
+// this peer hosts registry. Returns true if message processed
+func (teo *Teonet) processRegistry(c *Channel, p *Packet) (processed bool) {
+	teo.registry.RLock()
+	server := teo.registry.server
+	teo.registry.RUnlock()
+	if server == nil {
+		return
+	}
+
+	cmd := c.command(p.Data())
+	if cmd.Cmd != CmdExtended ||
+		cmd.Ext < cmdRegistryRegister || cmd.Ext > cmdRegistryFind {
+		return
+	}
+	processed = true
+
+	reply, err := server.exec(c.Address(), cmd.Ext, cmd.Data)
+	if err != nil {
+		c.SendError(p, err)
+		return
+	}
+	if _, ok := p.RequestID(); ok {
+		c.Reply(p, reply)
+		return
+	}
+	teo.Command(cmd.Ext, reply).Send(c)
+	return
+}
+
+// closeChannel remove registrations of disconnected peer
+func (r *registry) closeChannel(c *Channel) {
+	r.RLock()
+	server := r.server
+	r.RUnlock()
+	if server == nil {
+		return
+	}
+	server.Lock()
+	defer server.Unlock()
+	for _, entries := range server.services {
+		delete(entries, c.Address())
+	}
+}
+
+// exec execute registry command received from peer address
+func (r *Registry) exec(address string, cmd ExtCmd, data []byte) (
+	reply []byte, err error) {
+
+	var req registryRequest
+	if err = req.UnmarshalBinary(data); err != nil {
+		err = ErrAPIBadArgument
+		return
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	switch cmd {
+	case cmdRegistryRegister:
+		ttl := req.ttl
+		if ttl < minRegistryTTL {
+			ttl = minRegistryTTL
+		} else if ttl > maxRegistryTTL {
+			ttl = maxRegistryTTL
+		}
+		entries, ok := r.services[req.name]
+		if !ok {
+			entries = make(map[string]*registryEntry)
+			r.services[req.name] = entries
+		}
+		entries[address] = &registryEntry{req.versions, time.Now().Add(ttl)}
+		log.Debugv.Println("registry: service", req.name, req.versions,
+			"registered by", address)
+
+	case cmdRegistryUnregister:
+		delete(r.services[req.name], address)
+
+	case cmdRegistryFind:
+		var versionRange APIVersionRange
+		if len(req.versions) > 0 {
+			versionRange = APIVersionRange(req.versions[0])
+		}
+		var addresses []string
+		for addr, entry := range r.services[req.name] {
+			if time.Now().After(entry.expires) {
+				delete(r.services[req.name], addr)
+				continue
+			}
+			if entry.match(versionRange) {
+				addresses = append(addresses, addr)
+			}
+		}
+		sort.Strings(addresses)
+		buf := new(bytes.Buffer)
+		req.WriteStringSlice(buf, addresses)
+		reply = buf.Bytes()
+	}
+	return
+}
+
+// Services return registered services names and addresses of its live
+// peers
+func (r *Registry) Services() (services map[string][]string) {
+	r.Lock()
+	defer r.Unlock()
+	services = make(map[string][]string)
+	for name, entries := range r.services {
+		for addr, entry := range entries {
+			if time.Now().After(entry.expires) {
+				continue
+			}
+			services[name] = append(services[name], addr)
+		}
+		sort.Strings(services[name])
+	}
+	return
+}
+
+// match check one of entry versions matches range
+func (e registryEntry) match(versionRange APIVersionRange) bool {
+	if versionRange == "" {
+		return true
+	}
+	for _, v := range e.versions {
+		if ok, _ := versionRange.Match(v); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// MarshalBinary binary marshal registryRequest
+func (r registryRequest) MarshalBinary() (data []byte, err error) {
+	buf := new(bytes.Buffer)
+	r.WriteSlice(buf, []byte(r.name))
+	r.WriteStringSlice(buf, r.versions)
+	binary.Write(buf, binary.LittleEndian, uint32(r.ttl/time.Millisecond))
+	data = buf.Bytes()
+	return
+}
+
+// UnmarshalBinary binary unmarshal registryRequest
+func (r *registryRequest) UnmarshalBinary(data []byte) (err error) {
+	buf := bytes.NewBuffer(data)
+	if r.name, err = r.ReadString(buf); err != nil {
+		return
+	}
+	if r.versions, err = r.ReadStringSlice(buf); err != nil {
+		return
+	}
+	var ttl uint32
+	if err = binary.Read(buf, binary.LittleEndian, &ttl); err != nil {
+		return
+	}
+	r.ttl = time.Duration(ttl) * time.Millisecond
+	return
+}
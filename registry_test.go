@@ -0,0 +1,85 @@
+// Test of services registry
+package teonet
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	cli, srv, addr := newLocalPeers(t)
+
+	// Server hosts registry, client registers its api
+	registry := srv.NewRegistry()
+	cli.SetRegistry(addr)
+	api := cli.NewAPI("test", "teotest", "test api", "1.2.0")
+	if err := api.Register(); err != nil {
+		t.Fatal(err)
+	}
+
+	find := func(teo *Teonet, name string, r APIVersionRange) []string {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		addresses, err := teo.FindServices(ctx, name, r)
+		if err != nil {
+			t.Error(err)
+		}
+		return addresses
+	}
+	want := []string{cli.Address()}
+
+	t.Run("Find", func(t *testing.T) {
+		if got := find(cli, "teotest", "^1.1"); !reflect.DeepEqual(got, want) {
+			t.Errorf("wrong services: %v", got)
+		}
+		if got := find(cli, "teotest", "^2"); len(got) != 0 {
+			t.Errorf("wrong services of version 2: %v", got)
+		}
+		if got := find(cli, "other", ""); len(got) != 0 {
+			t.Errorf("wrong other services: %v", got)
+		}
+	})
+
+	// Registry host finds services in local registry
+	t.Run("Local", func(t *testing.T) {
+		if got := find(srv, "teotest", ""); !reflect.DeepEqual(got, want) {
+			t.Errorf("wrong services: %v", got)
+		}
+		services := registry.Services()
+		if !reflect.DeepEqual(services["teotest"], want) {
+			t.Errorf("wrong registry services: %v", services)
+		}
+	})
+
+	t.Run("Expire", func(t *testing.T) {
+		registry.Lock()
+		registry.services["teotest"][cli.Address()].expires = time.Now()
+		registry.Unlock()
+		if got := find(cli, "teotest", ""); len(got) != 0 {
+			t.Errorf("expired service found: %v", got)
+		}
+		api.Register()
+		if got := find(cli, "teotest", ""); !reflect.DeepEqual(got, want) {
+			t.Errorf("wrong services after register: %v", got)
+		}
+	})
+
+	t.Run("Unregister", func(t *testing.T) {
+		if err := api.Unregister(); err != nil {
+			t.Error(err)
+		}
+		if got := find(cli, "teotest", ""); len(got) != 0 {
+			t.Errorf("unregistered service found: %v", got)
+		}
+	})
+
+	t.Run("NotSet", func(t *testing.T) {
+		cli.SetRegistry("")
+		if _, err := cli.FindServices(context.Background(), "teotest",
+			""); err != ErrRegistryNotSet {
+			t.Errorf("wrong error: %v", err)
+		}
+	})
+}
@@ -0,0 +1,366 @@
+// Copyright 2023 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet request/response (RPC) module: requests and replies frames carry
+// correlation id, so reply routes to exactly one waiting request
+
+package teonet
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+
+	"github.com/teonet-go/tru"
+)
+
+const (
+	rpcHeaderLen = 4 // id
+)
+
+// rpc contains requests waiting replies and streaming replies writers
+type rpc struct {
+	m       map[uint32]*rpcWaiter
+	writers map[rpcKey]*ReplyWriter
+	id      uint32 // Last request id
+	sync.Mutex
+}
+
+// rpcWaiter is request waiting reply
+type rpcWaiter struct {
+	c      *Channel
+	stream bool // Wait streaming reply
+	reply  chan rpcReply
+}
+
+// rpcReply is reply data, end of streaming reply or error
+type rpcReply struct {
+	data []byte
+	end  bool
+	err  error
+}
+
+// newRPC create teonet rpc requests holder
+func (teo *Teonet) newRPC() {
+	teo.rpc = &rpc{
+		m:       make(map[uint32]*rpcWaiter),
+		writers: make(map[rpcKey]*ReplyWriter),
+	}
+}
+
+// Request send command to peer and wait reply. The request frame carries
+// correlation id and waiter registers before request sent, so reply can't be
+// lost or received by other request. Peers which does not support requests
+// receive ordinary command, and first answer with this command number
+// returns in this case. The ctx used to cancel request or set timeout, the
+// tru.ClientConnectTimeout used if ctx has no deadline
+func (teo *Teonet) Request(ctx context.Context, addr string, cmd byte,
+	data []byte) (reply []byte, err error) {
+	return teo.request(ctx, addr, cmd, data, CmdAnswer)
+}
+
+// RequestAPI send api command to peer and wait answer. Peers which support
+// requests answer by request id in any answer mode. Old peers answers are
+// checked and received depending of command answer mode: command header
+// (CmdAnswer) and packet id (PacketIDAnswer) are checked and removed from
+// answer. Commands with NoAnswer mode sends without waiting answer
+func (teo *Teonet) RequestAPI(ctx context.Context, addr string, cmd Command,
+	mode APIanswerMode) (reply []byte, err error) {
+
+	cmd.teo = teo
+	if mode == NoAnswer {
+		_, err = cmd.SendTo(addr)
+		return
+	}
+	data := cmd.Bytes()
+	return teo.request(ctx, addr, data[0], data[1:], mode)
+}
+
+// request send request to peer and wait reply. The old peers answer
+// depending of answer mode
+func (teo *Teonet) request(ctx context.Context, addr string, cmd byte,
+	data []byte, mode APIanswerMode) (reply []byte, err error) {
+
+	c, ok := teo.channels.get(addr)
+	if !ok {
+		err = ErrPeerNotConnected
+		return
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, tru.ClientConnectTimeout)
+		defer cancel()
+	}
+
+	// Old peer: send command and wait answer depending of answer mode
+	if !c.capabilities().has(capRPC) {
+		return teo.requestCommand(ctx, c, cmd, data, mode)
+	}
+
+	// Register waiter and send request
+	id, w := teo.rpc.add(c, false)
+	defer teo.rpc.del(id)
+	frame := append(rpcFrame(id, 1+len(data)), cmd)
+	if _, err = c.send(frameRPCRequest, append(frame, data...)); err != nil {
+		return
+	}
+
+	// Wait reply
+	select {
+	case r := <-w.reply:
+		reply, err = r.data, r.err
+	case <-ctx.Done():
+		err = ctx.Err()
+		if err == context.DeadlineExceeded {
+			err = ErrTimeout
+		}
+	}
+	return
+}
+
+// requestCommand send command to old peer and wait answer depending of
+// answer mode: the answer with the same command header if mode has
+// CmdAnswer, and with the sent packet id if mode has PacketIDAnswer. The
+// first received data answer if mode has no CmdAnswer and PacketIDAnswer
+func (teo *Teonet) requestCommand(ctx context.Context, c *Channel, cmd byte,
+	data []byte, mode APIanswerMode) (reply []byte, err error) {
+
+	command := teo.Command(append([]byte{cmd}, data...))
+	header := c.commandBytes(Command{Cmd: command.Cmd, Ext: command.Ext})
+
+	// The reader waits until command sent and packet id known
+	var m sync.Mutex
+	var id uint32
+	answer := make(chan []byte, 1)
+	errs := make(chan error, 1)
+	m.Lock()
+	scr := teo.subscribe(c, func(c *Channel, p *Packet, e *Event) bool {
+		if e.Event != EventData && e.Event != EventAPIError {
+			return false
+		}
+		m.Lock()
+		defer m.Unlock()
+
+		// Api error answer
+		if e.Event == EventAPIError {
+			ecmd, eid, apiErr, ok := apiErrorFrame(p.Data())
+			if !ok || ecmd != command.Cmd || mode&PacketIDAnswer > 0 && eid != id {
+				return false
+			}
+			select {
+			case errs <- apiErr:
+				return true
+			default:
+				return false
+			}
+		}
+
+		d, ok := answerData(p.Data(), header, id, mode)
+		if !ok {
+			return false
+		}
+		select {
+		case answer <- d:
+			return true
+		default:
+			return false
+		}
+	})
+	defer teo.Unsubscribe(scr)
+
+	pid, err := command.Send(c)
+	id = uint32(pid)
+	m.Unlock()
+	if err != nil {
+		return
+	}
+	select {
+	case reply = <-answer:
+	case err = <-errs:
+	case <-ctx.Done():
+		err = ctx.Err()
+		if err == context.DeadlineExceeded {
+			err = ErrTimeout
+		}
+	}
+	return
+}
+
+// answerData check answer data contains command header and packet id
+// depending of answer mode and return data without header and id
+func answerData(data, header []byte, id uint32, mode APIanswerMode) (
+	d []byte, ok bool) {
+
+	d = data
+	if mode&CmdAnswer > 0 {
+		if !bytes.HasPrefix(d, header) {
+			return
+		}
+		d = d[len(header):]
+	}
+	if mode&PacketIDAnswer > 0 {
+		if len(d) < 4 || binary.LittleEndian.Uint32(d) != id {
+			return
+		}
+		d = d[4:]
+	}
+	ok = true
+	return
+}
+
+// Reply send reply to request packet received from channel. If the packet
+// is rpc request (received from Request function) the reply frame with
+// request correlation id sends, in other case data sends as is. The
+// streaming request (received from RequestStream) gets one part reply
+func (c *Channel) Reply(p *Packet, data []byte) (id int, err error) {
+	switch {
+	case p.rpcStream:
+		w, _ := c.ReplyStream(p)
+		if _, err = w.Write(data); err != nil {
+			return
+		}
+		err = w.Close()
+		return
+	case p.rpc:
+		frame := rpcFrame(p.rpcID, len(data))
+		return c.send(frameRPCReply, append(frame, data...))
+	}
+	return c.Send(data)
+}
+
+// processRPC check received frame and process rpc frames. Returns true if
+// frame processed. The request frame converts to command packet with
+// request id and returns false to send it to api and other readers
+func (teo *Teonet) processRPC(c *Channel, t frameType, p *Packet) (processed bool) {
+	if t < frameRPCRequest || t > frameRPCCancel {
+		return
+	}
+	data := p.Data()
+	if len(data) < rpcHeaderLen {
+		processed = true
+		return
+	}
+
+	id := binary.LittleEndian.Uint32(data)
+
+	switch t {
+	// Request: remove frame header and mark packet as rpc request
+	case frameRPCRequest:
+		p.rpc, p.rpcID = true, id
+		p.SetData(data[rpcHeaderLen:])
+
+	// Streaming request: remove frame header and mark packet as rpc streaming
+	// request
+	case frameRPCStream:
+		if len(data) < rpcHeaderLen+4 {
+			processed = true
+			return
+		}
+		p.rpc, p.rpcStream, p.rpcID = true, true, id
+		p.rpcCredits = binary.LittleEndian.Uint32(data[rpcHeaderLen:])
+		p.SetData(data[rpcHeaderLen+4:])
+
+	// Reply or reply part: send data to waiter
+	case frameRPCReply, frameRPCPart:
+		processed = true
+		teo.rpc.reply(c, id, rpcReply{data: data[rpcHeaderLen:]})
+
+	// End of streaming reply
+	case frameRPCEnd:
+		processed = true
+		teo.rpc.reply(c, id, rpcReply{end: true})
+
+	// Error: send error to waiter
+	case frameRPCError:
+		processed = true
+		err := parseAPIError(data[rpcHeaderLen:])
+		teo.rpc.reply(c, id, rpcReply{err: err})
+
+	// Credits from streaming requester
+	case frameRPCCredits:
+		processed = true
+		if w, ok := teo.rpc.writer(c, id); ok && len(data) >= rpcHeaderLen+4 {
+			w.addCredits(int(binary.LittleEndian.Uint32(data[rpcHeaderLen:])))
+		}
+
+	// Streaming request canceled by requester
+	case frameRPCCancel:
+		processed = true
+		if w, ok := teo.rpc.writer(c, id); ok {
+			w.finish(ErrReplyStreamCanceled)
+		}
+	}
+	return
+}
+
+// add create new waiter. The streaming reply waiter channel has place for
+// all parts which may be sent without credits and end or error
+func (r *rpc) add(c *Channel, stream bool) (id uint32, w *rpcWaiter) {
+	id = atomic.AddUint32(&r.id, 1)
+	size := 1
+	if stream {
+		size = rpcStreamWindow + 1
+	}
+	w = &rpcWaiter{c: c, stream: stream, reply: make(chan rpcReply, size)}
+	r.Lock()
+	defer r.Unlock()
+	r.m[id] = w
+	return
+}
+
+// del remove waiter
+func (r *rpc) del(id uint32) {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.m, id)
+}
+
+// reply send reply to waiter and remove it. Streaming reply waiter removes
+// after end or error. Replies from other channel than request was sent to
+// are skipped
+func (r *rpc) reply(c *Channel, id uint32, reply rpcReply) {
+	r.Lock()
+	defer r.Unlock()
+	w, ok := r.m[id]
+	if !ok || w.c != c {
+		log.Debugv.Println("skip reply to unknown request", id, "from", c)
+		return
+	}
+	if !w.stream || reply.end || reply.err != nil {
+		delete(r.m, id)
+	}
+	select {
+	case w.reply <- reply:
+	default:
+		log.Error.Println("skip reply part exceeded credits, request", id)
+	}
+}
+
+// closeChannel send error to all requests waiting reply from disconnected
+// channel and cancel streaming replies writers to this channel
+func (r *rpc) closeChannel(c *Channel) {
+	r.Lock()
+	var writers []*ReplyWriter
+	for id, w := range r.m {
+		if w.c == c {
+			delete(r.m, id)
+			select {
+			case w.reply <- rpcReply{err: ErrPeerNotConnected}:
+			default:
+			}
+		}
+	}
+	for k, w := range r.writers {
+		if k.c == c {
+			writers = append(writers, w)
+		}
+	}
+	r.Unlock()
+
+	for _, w := range writers {
+		w.finish(ErrPeerNotConnected)
+	}
+}
@@ -0,0 +1,257 @@
+// Copyright 2023 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet streaming replies module: request handler sends sequence of reply
+// parts followed by end marker or error. The requester grants credits to
+// handler when it reads parts, so handler writer blocks when requester is
+// slow. Streaming requests handlers execute in separate goroutines and may
+// write reply parts before return
+
+package teonet
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+)
+
+// Number of parts which may be sent without credits
+const rpcStreamWindow = 16
+
+// Streaming replies errors
+var (
+	ErrReplyStreamNotSupported = errors.New("peer does not support streaming replies")
+	ErrReplyStreamCanceled     = errors.New("streaming reply canceled")
+	ErrNotStreamRequest        = errors.New("packet is not streaming request")
+)
+
+// rpcKey is reply writers map key: requests id unique inside channel only
+type rpcKey struct {
+	c  *Channel
+	id uint32
+}
+
+// ReplyStream is requester side of streaming reply
+type ReplyStream struct {
+	ctx      context.Context
+	teo      *Teonet
+	c        *Channel
+	id       uint32
+	w        *rpcWaiter
+	consumed int  // Parts read but not reported in credits
+	done     bool // End or error received
+}
+
+// RequestStream send streaming request to peer and return reply stream to
+// receive reply parts. The ctx cancels the request, the handler receives
+// cancel and its writer returns ErrReplyStreamCanceled
+func (teo *Teonet) RequestStream(ctx context.Context, addr string, cmd byte,
+	data []byte) (s *ReplyStream, err error) {
+
+	c, ok := teo.channels.get(addr)
+	if !ok {
+		err = ErrPeerNotConnected
+		return
+	}
+	if !c.capabilities().has(capRPCStream) {
+		err = ErrReplyStreamNotSupported
+		return
+	}
+
+	// Register waiter and send request
+	id, w := teo.rpc.add(c, true)
+	frame := rpcFrame(id, 4+1+len(data))
+	frame = binary.LittleEndian.AppendUint32(frame, rpcStreamWindow)
+	frame = append(frame, cmd)
+	if _, err = c.send(frameRPCStream, append(frame, data...)); err != nil {
+		teo.rpc.del(id)
+		return
+	}
+	s = &ReplyStream{ctx: ctx, teo: teo, c: c, id: id, w: w}
+	return
+}
+
+// Recv return next reply part. It returns io.EOF after last part, or
+// error sent by handler, or ctx error
+func (s *ReplyStream) Recv() (data []byte, err error) {
+	if s.done {
+		err = io.EOF
+		return
+	}
+	select {
+	case r := <-s.w.reply:
+		switch {
+		case r.err != nil:
+			s.done, err = true, r.err
+		case r.end:
+			s.done, err = true, io.EOF
+		default:
+			data = r.data
+			s.credit()
+		}
+	case <-s.ctx.Done():
+		s.Close()
+		err = s.ctx.Err()
+	}
+	return
+}
+
+// credit count read part and send credits to handler
+func (s *ReplyStream) credit() {
+	s.consumed++
+	if s.consumed < rpcStreamWindow/2 {
+		return
+	}
+	frame := rpcFrame(s.id, 4)
+	frame = binary.LittleEndian.AppendUint32(frame, uint32(s.consumed))
+	s.consumed = 0
+	s.c.send(frameRPCCredits, frame)
+}
+
+// Close cancel the request if reply is not finished yet
+func (s *ReplyStream) Close() {
+	if s.done {
+		return
+	}
+	s.done = true
+	s.teo.rpc.del(s.id)
+	s.c.send(frameRPCCancel, rpcFrame(s.id, 0))
+}
+
+// ReplyWriter is handler side of streaming reply
+type ReplyWriter struct {
+	r       *rpc
+	c       *Channel
+	p       *Packet
+	credits int           // Parts which may be sent
+	done    bool          // Reply finished
+	err     error         // Cancel error
+	changed chan struct{} // Closed when credits or state changed
+	sync.Mutex
+}
+
+// ReplyStream create writer to send streaming reply to request received
+// from RequestStream function
+func (c *Channel) ReplyStream(p *Packet) (w *ReplyWriter, err error) {
+	if !p.rpcStream {
+		err = ErrNotStreamRequest
+		return
+	}
+	w = &ReplyWriter{r: c.teo.rpc, c: c, p: p, credits: int(p.rpcCredits),
+		changed: make(chan struct{})}
+	c.teo.rpc.addWriter(w)
+	return
+}
+
+// ReplyStream create writer to send streaming reply to request
+func (a *API) ReplyStream(c *Channel, p *Packet) (*ReplyWriter, error) {
+	return c.ReplyStream(p)
+}
+
+// Write send reply part. It blocks while requester does not read previous
+// parts, and returns error if request canceled or peer disconnected
+func (w *ReplyWriter) Write(data []byte) (n int, err error) {
+	w.Lock()
+	for w.credits == 0 && w.err == nil && !w.done {
+		changed := w.changed
+		w.Unlock()
+		<-changed
+		w.Lock()
+	}
+	switch {
+	case w.err != nil:
+		err = w.err
+	case w.done:
+		err = io.ErrClosedPipe
+	default:
+		w.credits--
+	}
+	w.Unlock()
+	if err != nil {
+		return
+	}
+
+	frame := rpcFrame(w.p.rpcID, len(data))
+	if _, err = w.c.send(frameRPCPart, append(frame, data...)); err != nil {
+		return
+	}
+	n = len(data)
+	return
+}
+
+// Close send end of reply marker
+func (w *ReplyWriter) Close() (err error) {
+	if !w.finish(nil) {
+		return
+	}
+	_, err = w.c.send(frameRPCEnd, rpcFrame(w.p.rpcID, 0))
+	return
+}
+
+// CloseWithError finish reply with error, requester receives it as typed
+// api error
+func (w *ReplyWriter) CloseWithError(err error) error {
+	if w.finish(nil) {
+		w.c.SendError(w.p, err)
+	}
+	return nil
+}
+
+// finish mark writer done and remove it from writers, returns false if
+// writer already finished
+func (w *ReplyWriter) finish(err error) bool {
+	w.Lock()
+	defer w.Unlock()
+	if w.done {
+		return false
+	}
+	w.done = true
+	if err != nil {
+		w.err = err
+	}
+	close(w.changed)
+	w.changed = make(chan struct{})
+	w.r.delWriter(w)
+	return true
+}
+
+// addCredits add credits received from requester
+func (w *ReplyWriter) addCredits(n int) {
+	w.Lock()
+	defer w.Unlock()
+	w.credits += n
+	close(w.changed)
+	w.changed = make(chan struct{})
+}
+
+// rpcFrame make rpc frame header with capacity for payload
+func rpcFrame(id uint32, payloadLen int) (frame []byte) {
+	frame = make([]byte, rpcHeaderLen, rpcHeaderLen+payloadLen)
+	binary.LittleEndian.PutUint32(frame, id)
+	return
+}
+
+// addWriter add reply writer
+func (r *rpc) addWriter(w *ReplyWriter) {
+	r.Lock()
+	defer r.Unlock()
+	r.writers[rpcKey{w.c, w.p.rpcID}] = w
+}
+
+// delWriter remove reply writer
+func (r *rpc) delWriter(w *ReplyWriter) {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.writers, rpcKey{w.c, w.p.rpcID})
+}
+
+// writer get reply writer
+func (r *rpc) writer(c *Channel, id uint32) (w *ReplyWriter, ok bool) {
+	r.Lock()
+	defer r.Unlock()
+	w, ok = r.writers[rpcKey{c, id}]
+	return
+}
@@ -0,0 +1,252 @@
+// Test of requests with correlation ids and api requests answer modes
+package teonet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRequestAPI(t *testing.T) {
+	cli, srv, addr := newLocalPeers(t)
+
+	// Api with commands answered in different answer modes
+	api := srv.NewAPI("test", "test", "test api", "0.0.1")
+	answer := func(mode APIanswerMode) func(c *Channel, p *Packet, data []byte) bool {
+		return func(c *Channel, p *Packet, data []byte) bool {
+			cmd := MakeAPI2().SetCmd(p.Data()[0]).SetAnswerMode(mode)
+			if p.Data()[0] == CmdExtended {
+				cmd.SetExtCmd(1000)
+			}
+			api.SendAnswer(cmd, c, append([]byte("re: "), data...), p)
+			return true
+		}
+	}
+	pings := make(chan []byte, 1)
+	api.Add(
+		MakeAPI2().SetName("data").SetCmd(129).SetAnswerMode(DataAnswer).
+			SetReader(answer(DataAnswer)),
+		MakeAPI2().SetName("cmd").SetCmd(130).SetAnswerMode(CmdAnswer).
+			SetReader(answer(CmdAnswer)),
+		MakeAPI2().SetName("id").SetCmd(131).
+			SetAnswerMode(CmdAnswer|PacketIDAnswer).
+			SetReader(answer(CmdAnswer|PacketIDAnswer)),
+		MakeAPI2().SetName("ext").SetExtCmd(1000).SetAnswerMode(CmdAnswer).
+			SetReader(answer(CmdAnswer)),
+		MakeAPI2().SetName("ping").SetCmd(132).SetAnswerMode(NoAnswer).
+			SetReader(func(c *Channel, p *Packet, data []byte) bool {
+				pings <- data
+				return true
+			}),
+	)
+	srv.AddReader(api.Reader())
+
+	requests := func(t *testing.T) {
+		for _, r := range []struct {
+			cmd  Command
+			mode APIanswerMode
+		}{
+			{Command{Cmd: 129, Data: []byte("data")}, DataAnswer},
+			{Command{Cmd: 130, Data: []byte("cmd")}, CmdAnswer},
+			{Command{Cmd: 131, Data: []byte("id")}, CmdAnswer | PacketIDAnswer},
+			{Command{Cmd: CmdExtended, Ext: 1000, Data: []byte("ext")}, CmdAnswer},
+		} {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			reply, err := cli.RequestAPI(ctx, addr, r.cmd, r.mode)
+			cancel()
+			if err != nil || string(reply) != "re: "+string(r.cmd.Data) {
+				t.Errorf("wrong reply to %s: %q, err: %v", r.cmd.Data, reply, err)
+			}
+		}
+
+		// Command without answer
+		_, err := cli.RequestAPI(context.Background(), addr,
+			Command{Cmd: 132, Data: []byte("ping")}, NoAnswer)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		select {
+		case data := <-pings:
+			if string(data) != "ping" {
+				t.Errorf("wrong ping data: %q", data)
+			}
+		case <-time.After(time.Second):
+			t.Error("ping does not received")
+		}
+	}
+
+	t.Run("Request", requests)
+
+	// Old peer answers depending of answer mode
+	t.Run("OldPeer", func(t *testing.T) {
+		c, _ := cli.channels.get(addr)
+		c.setCaps(c.capabilities() &^ capRPC)
+		requests(t)
+	})
+}
+
+func TestRequest(t *testing.T) {
+	cli, srv, addr := newLocalPeers(t)
+
+	// Answers to requests sent in reverse order
+	api := srv.NewAPI("test", "test", "test api", "0.0.1")
+	var cmd *APIData
+	cmd = MakeAPI2().SetName("delay").SetCmd(129).SetAnswerMode(CmdAnswer).
+		SetReader(func(c *Channel, p *Packet, data []byte) bool {
+			go func() {
+				time.Sleep(time.Duration(10-data[0]) * 10 * time.Millisecond)
+				api.SendAnswer(cmd, c, data, p)
+			}()
+			return true
+		})
+	api.Add(cmd)
+	srv.AddReader(api.Reader())
+
+	// Concurrent requests of the same command get their own replies
+	requests := func(t *testing.T, n byte) {
+		var wg sync.WaitGroup
+		for i := byte(0); i < n; i++ {
+			wg.Add(1)
+			go func(i byte) {
+				defer wg.Done()
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				defer cancel()
+				reply, err := cli.Request(ctx, addr, 129, []byte{i})
+				if err != nil || len(reply) != 1 || reply[0] != i {
+					t.Errorf("wrong reply to request %d: %v, err: %v", i, reply, err)
+				}
+			}(i)
+		}
+		wg.Wait()
+	}
+
+	t.Run("Concurrent", func(t *testing.T) { requests(t, 10) })
+
+	t.Run("Errors", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		if _, err := cli.Request(ctx, addr, 129, []byte{0}); err != ErrTimeout {
+			t.Errorf("wrong timeout error: %v", err)
+		}
+		if _, err := cli.Request(context.Background(), "unknown", 129,
+			nil); err != ErrPeerNotConnected {
+			t.Errorf("wrong not connected error: %v", err)
+		}
+	})
+
+	// Old peer answers with command header
+	t.Run("OldPeer", func(t *testing.T) {
+		c, _ := cli.channels.get(addr)
+		c.setCaps(c.capabilities() &^ capRPC)
+		requests(t, 1)
+	})
+}
+
+func TestRequestStream(t *testing.T) {
+	cli, srv, addr := newLocalPeers(t)
+
+	// Handlers write reply parts inline, more than window parts
+	const parts = 5 * rpcStreamWindow
+	api := srv.NewAPI("test", "test", "test api", "0.0.1")
+	canceled := make(chan error, 1)
+	api.Add(
+		MakeAPI2().SetName("count").SetCmd(129).
+			SetReader(func(c *Channel, p *Packet, data []byte) bool {
+				w, _ := c.ReplyStream(p)
+				for i := 0; i < parts; i++ {
+					w.Write([]byte{byte(i)})
+				}
+				w.Close()
+				return true
+			}),
+		MakeAPI2().SetName("fail").SetCmd(130).
+			SetReader(func(c *Channel, p *Packet, data []byte) bool {
+				w, _ := c.ReplyStream(p)
+				w.Write([]byte("part"))
+				w.CloseWithError(ErrAPIBadArgument)
+				return true
+			}),
+		MakeAPI2().SetName("endless").SetCmd(131).
+			SetReader(func(c *Channel, p *Packet, data []byte) bool {
+				w, _ := c.ReplyStream(p)
+				for {
+					if _, err := w.Write([]byte("part")); err != nil {
+						canceled <- err
+						return true
+					}
+				}
+			}),
+	)
+	srv.AddReader(api.Reader())
+	apicli, err := cli.NewAPIClient(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer apicli.Close()
+
+	// receive reply parts from api stream
+	receive := func(ctx context.Context, command string) (n int, err error) {
+		s, err := apicli.Stream(ctx, command, nil)
+		if err != nil {
+			return
+		}
+		for data := range s.C {
+			if command == "count" && data[0] != byte(n) {
+				return n, fmt.Errorf("wrong part %d: %v", n, data)
+			}
+			n++
+		}
+		return n, s.Err()
+	}
+
+	t.Run("Inline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		if n, err := receive(ctx, "count"); err != nil || n != parts {
+			t.Errorf("wrong number of parts: %d, err: %v", n, err)
+		}
+
+		// Receive goroutine does not blocked by handler
+		if _, err := cli.Request(ctx, addr, CmdServerAPI, nil); err != nil {
+			t.Errorf("request after stream: %v", err)
+		}
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if n, err := receive(ctx, "fail"); !errors.Is(err, ErrAPIBadArgument) ||
+			n != 1 {
+			t.Errorf("wrong stream error: %v, parts: %d", err, n)
+		}
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		s, err := apicli.Stream(ctx, "endless", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 5; i++ {
+			<-s.C
+		}
+		cancel()
+		for range s.C {
+		}
+		if s.Err() != context.Canceled {
+			t.Errorf("wrong canceled stream error: %v", s.Err())
+		}
+		select {
+		case err := <-canceled:
+			if err != ErrReplyStreamCanceled {
+				t.Errorf("wrong handler error: %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Error("handler does not canceled")
+		}
+	})
+}
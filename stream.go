@@ -0,0 +1,657 @@
+// Copyright 2023 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet streams module: net.Conn compatible streams multiplexed over teonet
+// channel with per stream flow control, half-close and deadlines
+
+package teonet
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	streamHeaderLen = 1 + 4      // type, id
+	streamWindow    = 256 * 1024 // Receive window size
+	streamBacklog   = 64         // Listener accept queue length
+)
+
+// Stream frame types
+const (
+	streamOpen byte = iota + 1
+	streamAccept
+	streamData
+	streamWindowUpdate
+	streamFin
+	streamRst
+)
+
+// Streams errors
+var (
+	ErrStreamsNotSupported = errors.New("peer does not support streams")
+	ErrStreamRefused       = errors.New("stream refused")
+	ErrStreamReset         = errors.New("stream reset by peer")
+	ErrStreamPortInUse     = errors.New("stream port already in use")
+)
+
+// StreamAddr is teonet stream address: peer address and stream port
+type StreamAddr struct {
+	Address string
+	Port    uint16
+}
+
+// Network return stream address network name
+func (a StreamAddr) Network() string { return "teonet" }
+
+// String return stream address in 'address:port' format
+func (a StreamAddr) String() string {
+	return a.Address + ":" + strconv.Itoa(int(a.Port))
+}
+
+// streams contains teonet listeners and opened streams
+type streams struct {
+	listeners map[uint16]*StreamListener
+	m         map[streamKey]*Stream
+	id        uint32 // Last dialed stream id
+	sync.RWMutex
+}
+
+// streamKey is stream map key: streams id unique inside channel only
+type streamKey struct {
+	c  *Channel
+	id uint32
+}
+
+// newStreams create teonet streams holder
+func (teo *Teonet) newStreams() {
+	teo.streams = &streams{
+		listeners: make(map[uint16]*StreamListener),
+		m:         make(map[streamKey]*Stream),
+	}
+}
+
+// ListenStream announces stream port and returns listener which accepts
+// streams dialed by peers to this port with DialStream
+func (teo *Teonet) ListenStream(port uint16) (l net.Listener, err error) {
+	s := teo.streams
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.listeners[port]; ok {
+		err = ErrStreamPortInUse
+		return
+	}
+	listener := &StreamListener{
+		s:      s,
+		addr:   StreamAddr{teo.Address(), port},
+		accept: make(chan *Stream, streamBacklog),
+	}
+	s.listeners[port] = listener
+	l = listener
+	return
+}
+
+// DialStream opens stream to peer port. The peer should be connected and
+// listen this port with ListenStream
+func (teo *Teonet) DialStream(ctx context.Context, addr string, port uint16) (
+	conn net.Conn, err error) {
+
+	c, ok := teo.channels.get(addr)
+	if !ok {
+		err = ErrPeerNotConnected
+		return
+	}
+	if !c.capabilities().has(capStream) {
+		err = ErrStreamsNotSupported
+		return
+	}
+
+	// Streams dialed from client mode channel side have odd id and from
+	// server mode side have even id, so ids does not overlap
+	id := atomic.AddUint32(&teo.streams.id, 1) << 1
+	if c.ClientMode() {
+		id |= 1
+	}
+	st := teo.streams.newStream(c, id, StreamAddr{teo.Address(), 0},
+		StreamAddr{addr, port})
+	st.accepted = make(chan error, 1)
+	st.dialing = true
+
+	// Send open frame and wait answer
+	payload := make([]byte, 6)
+	binary.LittleEndian.PutUint16(payload, port)
+	binary.LittleEndian.PutUint32(payload[2:], streamWindow)
+	if err = st.sendFrame(streamOpen, payload); err != nil {
+		teo.streams.del(st)
+		return
+	}
+	select {
+	case err = <-st.accepted:
+	case <-ctx.Done():
+		err = ctx.Err()
+		st.answer(err)
+		st.sendFrame(streamRst, nil)
+	}
+	if err != nil {
+		teo.streams.del(st)
+		return
+	}
+	conn = st
+	return
+}
+
+// newStream create stream and add it to streams map
+func (s *streams) newStream(c *Channel, id uint32, local, remote StreamAddr) *Stream {
+	st := &Stream{s: s, c: c, id: id, local: local, remote: remote,
+		changed: make(chan struct{})}
+	s.Lock()
+	defer s.Unlock()
+	s.m[streamKey{c, id}] = st
+	return st
+}
+
+// get stream by channel and id
+func (s *streams) get(c *Channel, id uint32) (st *Stream, ok bool) {
+	s.RLock()
+	defer s.RUnlock()
+	st, ok = s.m[streamKey{c, id}]
+	return
+}
+
+// del remove stream from streams map
+func (s *streams) del(st *Stream) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.m, streamKey{st.c, st.id})
+}
+
+// closeChannel reset all streams of disconnected channel
+func (s *streams) closeChannel(c *Channel) {
+	s.Lock()
+	var chStreams []*Stream
+	for k, st := range s.m {
+		if k.c == c {
+			chStreams = append(chStreams, st)
+			delete(s.m, k)
+		}
+	}
+	s.Unlock()
+	for _, st := range chStreams {
+		st.reset(ErrPeerNotConnected)
+	}
+}
+
+// processStream check received frame and process stream frames. Returns
+// true if frame processed
+func (teo *Teonet) processStream(c *Channel, t frameType, p *Packet) (ok bool) {
+	if t != frameStream {
+		return
+	}
+	ok = true
+	data := p.Data()
+	if len(data) < streamHeaderLen {
+		return
+	}
+
+	typ := data[0]
+	id := binary.LittleEndian.Uint32(data[1:])
+	payload := data[streamHeaderLen:]
+
+	// Open stream request
+	if typ == streamOpen {
+		teo.streams.open(c, id, payload)
+		return
+	}
+
+	st, exists := teo.streams.get(c, id)
+	if !exists {
+		if typ != streamRst && typ != streamFin {
+			sendStreamFrame(c, streamRst, id, nil)
+		}
+		return
+	}
+
+	switch typ {
+	case streamAccept:
+		if len(payload) < 4 {
+			return
+		}
+		st.accept(int(binary.LittleEndian.Uint32(payload)))
+
+	case streamData:
+		if !st.receive(payload) {
+			teo.streams.del(st)
+			st.sendFrame(streamRst, nil)
+			st.reset(ErrStreamReset)
+		}
+
+	case streamWindowUpdate:
+		if len(payload) < 4 {
+			return
+		}
+		st.addWindow(int(binary.LittleEndian.Uint32(payload)))
+
+	case streamFin:
+		st.mu.Lock()
+		st.finRecv = true
+		done := st.finSent
+		st.notify()
+		st.mu.Unlock()
+		if done {
+			teo.streams.del(st)
+		}
+
+	case streamRst:
+		teo.streams.del(st)
+		if st.answer(ErrStreamRefused) {
+			return
+		}
+		st.reset(ErrStreamReset)
+	}
+	return
+}
+
+// open process open stream request: create stream, send answer and send
+// stream to listener
+func (s *streams) open(c *Channel, id uint32, payload []byte) {
+	if len(payload) < 6 {
+		return
+	}
+	port := binary.LittleEndian.Uint16(payload)
+	window := binary.LittleEndian.Uint32(payload[2:])
+
+	s.RLock()
+	l, ok := s.listeners[port]
+	s.RUnlock()
+	if !ok {
+		sendStreamFrame(c, streamRst, id, nil)
+		return
+	}
+
+	st := s.newStream(c, id, l.addr, StreamAddr{c.a, 0})
+	st.sendWindow = int(window)
+	if !l.queue(st) {
+		// Listener closed or accept queue is full
+		s.del(st)
+		sendStreamFrame(c, streamRst, id, nil)
+		return
+	}
+	answer := make([]byte, 4)
+	binary.LittleEndian.PutUint32(answer, streamWindow)
+	st.sendFrame(streamAccept, answer)
+}
+
+// sendStreamFrame send stream frame to channel
+func sendStreamFrame(c *Channel, typ byte, id uint32, payload []byte) (err error) {
+	frame := make([]byte, streamHeaderLen, streamHeaderLen+len(payload))
+	frame[0] = typ
+	binary.LittleEndian.PutUint32(frame[1:], id)
+	_, err = c.send(frameStream, append(frame, payload...))
+	return
+}
+
+// StreamListener is teonet streams listener, it implements net.Listener
+type StreamListener struct {
+	s      *streams
+	addr   StreamAddr
+	accept chan *Stream
+	closed bool // Accept channel closed
+	mu     sync.Mutex
+}
+
+// queue add opened stream to accept queue, returns false if listener closed
+// or queue is full
+func (l *StreamListener) queue(st *Stream) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return false
+	}
+	select {
+	case l.accept <- st:
+		return true
+	default:
+		return false
+	}
+}
+
+// Accept waits for and returns the next stream dialed to the listener port
+func (l *StreamListener) Accept() (net.Conn, error) {
+	st, ok := <-l.accept
+	if !ok {
+		return nil, net.ErrClosed
+	}
+	l.mu.Lock()
+	closed := l.closed
+	l.mu.Unlock()
+	if closed {
+		// Stream received from closed listener queue, Close reset it
+		l.s.del(st)
+		st.sendFrame(streamRst, nil)
+		st.reset(net.ErrClosed)
+		return nil, net.ErrClosed
+	}
+	return st, nil
+}
+
+// Close closes the listener, streams accepted before continue working and
+// streams waiting in accept queue reset
+func (l *StreamListener) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.accept)
+	l.mu.Unlock()
+
+	l.s.Lock()
+	delete(l.s.listeners, l.addr.Port)
+	l.s.Unlock()
+	for st := range l.accept {
+		l.s.del(st)
+		st.sendFrame(streamRst, nil)
+		st.reset(net.ErrClosed)
+	}
+	return nil
+}
+
+// Addr returns the listener's address
+func (l *StreamListener) Addr() net.Addr { return l.addr }
+
+// Stream is teonet stream multiplexed over teonet channel, it implements
+// net.Conn
+type Stream struct {
+	s             *streams
+	c             *Channel
+	id            uint32
+	local, remote StreamAddr
+	accepted      chan error // Dial answer received
+
+	mu         sync.Mutex
+	dialing    bool          // Dial answer does not received yet
+	wmu        sync.Mutex    // Serialize writes
+	rbuf       []byte        // Received and unread data
+	unacked    int           // Read data does not reported in window update
+	sendWindow int           // Bytes which may be sent to peer
+	finRecv    bool          // Peer finished writing
+	finSent    bool          // This side finished writing
+	closed     bool          // Stream closed by Close
+	err        error         // Stream reset error
+	rdeadline  time.Time     // Read deadline
+	wdeadline  time.Time     // Write deadline
+	changed    chan struct{} // Closed when stream state changed
+}
+
+// notify wakes up goroutines waiting stream state changes, should be called
+// under lock
+func (st *Stream) notify() {
+	close(st.changed)
+	st.changed = make(chan struct{})
+}
+
+// wait stream state changes or deadline, should be called under lock and
+// returns under lock
+func (st *Stream) wait(deadline time.Time) error {
+	changed := st.changed
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		t := time.NewTimer(d)
+		defer t.Stop()
+		timeout = t.C
+	}
+	st.mu.Unlock()
+	defer st.mu.Lock()
+	select {
+	case <-changed:
+		return nil
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+// sendFrame send stream frame to streams channel
+func (st *Stream) sendFrame(typ byte, payload []byte) error {
+	return sendStreamFrame(st.c, typ, st.id, payload)
+}
+
+// receive add received data to read buffer, returns false if peer exceeded
+// receive window
+func (st *Stream) receive(data []byte) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if len(st.rbuf)+len(data) > streamWindow {
+		return false
+	}
+	if !st.closed {
+		st.rbuf = append(st.rbuf, data...)
+	}
+	st.notify()
+	return true
+}
+
+// accept process dial answer: increase send window and wake up dialer.
+// Accept answers received after dial finished ignored
+func (st *Stream) accept(window int) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if !st.dialing {
+		return
+	}
+	st.dialing = false
+	st.sendWindow += window
+	st.notify()
+	select {
+	case st.accepted <- nil:
+	default:
+	}
+}
+
+// answer finish dial with error, returns false if dial already finished
+func (st *Stream) answer(err error) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if !st.dialing {
+		return false
+	}
+	st.dialing = false
+	select {
+	case st.accepted <- err:
+	default:
+	}
+	return true
+}
+
+// addWindow increase send window
+func (st *Stream) addWindow(n int) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.sendWindow += n
+	st.notify()
+}
+
+// reset set stream error and wake up waiting goroutines
+func (st *Stream) reset(err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.err == nil {
+		st.err = err
+	}
+	st.notify()
+}
+
+// Read reads data from stream
+func (st *Stream) Read(b []byte) (n int, err error) {
+	st.mu.Lock()
+	for {
+		switch {
+		case st.closed:
+			err = net.ErrClosed
+
+		case len(st.rbuf) > 0:
+			n = copy(b, st.rbuf)
+			st.rbuf = st.rbuf[n:]
+			st.unacked += n
+			var update int
+			if st.unacked >= streamWindow/2 {
+				update, st.unacked = st.unacked, 0
+			}
+			st.mu.Unlock()
+			if update > 0 {
+				payload := make([]byte, 4)
+				binary.LittleEndian.PutUint32(payload, uint32(update))
+				st.sendFrame(streamWindowUpdate, payload)
+			}
+			return
+
+		case st.err != nil:
+			err = st.err
+
+		case st.finRecv:
+			err = io.EOF
+		}
+		if err == nil {
+			err = st.wait(st.rdeadline)
+		}
+		if err != nil {
+			st.mu.Unlock()
+			return
+		}
+	}
+}
+
+// Write writes data to stream. It blocks while peer receive window is full
+func (st *Stream) Write(b []byte) (n int, err error) {
+	st.wmu.Lock()
+	defer st.wmu.Unlock()
+
+	maxChunk := st.c.teo.fragmenter.maxDataLen - frameHeaderLen - streamHeaderLen
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for len(b) > 0 {
+		switch {
+		case st.closed || st.finSent:
+			err = net.ErrClosed
+		case st.err != nil:
+			err = st.err
+		case !st.wdeadline.IsZero() && time.Now().After(st.wdeadline):
+			err = os.ErrDeadlineExceeded
+		case st.sendWindow == 0:
+			err = st.wait(st.wdeadline)
+			if err != nil {
+				return
+			}
+			continue
+		}
+		if err != nil {
+			return
+		}
+
+		chunk := len(b)
+		if chunk > st.sendWindow {
+			chunk = st.sendWindow
+		}
+		if chunk > maxChunk {
+			chunk = maxChunk
+		}
+		st.sendWindow -= chunk
+
+		st.mu.Unlock()
+		err = st.sendFrame(streamData, b[:chunk])
+		st.mu.Lock()
+		if err != nil {
+			return
+		}
+		n += chunk
+		b = b[chunk:]
+	}
+	return
+}
+
+// CloseWrite shuts down the writing side of the stream, peer reads io.EOF
+// after all sent data
+func (st *Stream) CloseWrite() (err error) {
+	st.mu.Lock()
+	if st.finSent || st.err != nil {
+		st.mu.Unlock()
+		return
+	}
+	st.finSent = true
+	done := st.finRecv
+	st.notify()
+	st.mu.Unlock()
+
+	err = st.sendFrame(streamFin, nil)
+	if done {
+		st.s.del(st)
+	}
+	return
+}
+
+// Close closes the stream
+func (st *Stream) Close() (err error) {
+	st.mu.Lock()
+	if st.closed {
+		st.mu.Unlock()
+		return
+	}
+	st.closed = true
+	st.rbuf = nil
+	sendFin := !st.finSent && st.err == nil
+	st.finSent = true
+	st.notify()
+	st.mu.Unlock()
+
+	st.s.del(st)
+	if sendFin {
+		err = st.sendFrame(streamFin, nil)
+	}
+	return
+}
+
+// LocalAddr returns the local stream address
+func (st *Stream) LocalAddr() net.Addr { return st.local }
+
+// RemoteAddr returns the remote stream address
+func (st *Stream) RemoteAddr() net.Addr { return st.remote }
+
+// SetDeadline sets the read and write deadlines
+func (st *Stream) SetDeadline(t time.Time) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.rdeadline, st.wdeadline = t, t
+	st.notify()
+	return nil
+}
+
+// SetReadDeadline sets the read deadline
+func (st *Stream) SetReadDeadline(t time.Time) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.rdeadline = t
+	st.notify()
+	return nil
+}
+
+// SetWriteDeadline sets the write deadline
+func (st *Stream) SetWriteDeadline(t time.Time) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.wdeadline = t
+	st.notify()
+	return nil
+}
@@ -0,0 +1,192 @@
+// Test of teonet streams
+package teonet
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// dialAccept dial stream to server port and return dialed and accepted
+// streams
+func dialAccept(t *testing.T, cli, srv *Teonet, l net.Listener) (
+	dialed, accepted *Stream) {
+
+	accept := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			t.Error(err)
+		}
+		accept <- conn
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	conn, err := cli.DialStream(ctx, srv.Address(), l.Addr().(StreamAddr).Port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	a := <-accept
+	if a == nil {
+		t.FailNow()
+	}
+	t.Cleanup(func() { a.Close() })
+	return conn.(*Stream), a.(*Stream)
+}
+
+// readAll read stream until EOF or error with read deadline
+func readAll(st *Stream) ([]byte, error) {
+	st.SetReadDeadline(time.Now().Add(time.Second))
+	return io.ReadAll(st)
+}
+
+func TestStream(t *testing.T) {
+	srv, err := New("test-server", OsConfigDir(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	cli, err := New("test-client", OsConfigDir(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	linkPeers(t, cli, srv)
+
+	l, err := srv.ListenStream(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	t.Run("DialAccept", func(t *testing.T) {
+		dialed, accepted := dialAccept(t, cli, srv, l)
+		if dialed.RemoteAddr().String() != srv.Address()+":10" ||
+			accepted.RemoteAddr().(StreamAddr).Address != cli.Address() {
+			t.Errorf("wrong addresses: %v %v", dialed.RemoteAddr(),
+				accepted.RemoteAddr())
+		}
+		if _, err := srv.ListenStream(10); err != ErrStreamPortInUse {
+			t.Errorf("wrong port in use error: %v", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if _, err := cli.DialStream(ctx, srv.Address(), 11); err != ErrStreamRefused {
+			t.Errorf("wrong refused error: %v", err)
+		}
+	})
+
+	// Each side finishes writing separately
+	t.Run("HalfClose", func(t *testing.T) {
+		dialed, accepted := dialAccept(t, cli, srv, l)
+		dialed.Write([]byte("request"))
+		dialed.CloseWrite()
+		if data, err := readAll(accepted); err != nil || string(data) != "request" {
+			t.Fatalf("wrong request: %s, err: %v", data, err)
+		}
+		if _, err := dialed.Write([]byte("more")); !errors.Is(err, net.ErrClosed) {
+			t.Errorf("write after close write: %v", err)
+		}
+		accepted.Write([]byte("reply"))
+		accepted.Close()
+		if data, err := readAll(dialed); err != nil || string(data) != "reply" {
+			t.Errorf("wrong reply: %s, err: %v", data, err)
+		}
+	})
+
+	// Peer reset established stream, repeated accept answers ignored
+	t.Run("Reset", func(t *testing.T) {
+		dialed, accepted := dialAccept(t, cli, srv, l)
+		window := make([]byte, 4)
+		binary.LittleEndian.PutUint32(window, streamWindow)
+		for i := 0; i < 3; i++ {
+			accepted.sendFrame(streamAccept, window)
+		}
+		accepted.Write([]byte("data"))
+		buf := make([]byte, 4)
+		dialed.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := io.ReadFull(dialed, buf); err != nil || string(buf) != "data" {
+			t.Fatalf("stream blocked by accept answers: %s, err: %v", buf, err)
+		}
+		dialed.mu.Lock()
+		window0 := dialed.sendWindow
+		dialed.mu.Unlock()
+		if window0 != streamWindow {
+			t.Errorf("wrong send window: %d", window0)
+		}
+
+		accepted.sendFrame(streamRst, nil)
+		if _, err := readAll(dialed); err != ErrStreamReset {
+			t.Errorf("wrong reset error: %v", err)
+		}
+		if _, err := dialed.Write([]byte("data")); err != ErrStreamReset {
+			t.Errorf("wrong write after reset error: %v", err)
+		}
+	})
+
+	t.Run("Deadline", func(t *testing.T) {
+		dialed, _ := dialAccept(t, cli, srv, l)
+		dialed.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		start := time.Now()
+		if _, err := dialed.Read(make([]byte, 1)); !errors.Is(err,
+			os.ErrDeadlineExceeded) || time.Since(start) < 50*time.Millisecond {
+			t.Errorf("wrong read deadline error: %v", err)
+		}
+		dialed.SetWriteDeadline(time.Now().Add(-time.Second))
+		if _, err := dialed.Write([]byte("data")); !errors.Is(err,
+			os.ErrDeadlineExceeded) {
+			t.Errorf("wrong write deadline error: %v", err)
+		}
+
+		// Deadline cleared
+		dialed.SetDeadline(time.Time{})
+		if _, err := dialed.Write([]byte("data")); err != nil {
+			t.Errorf("write after deadline cleared: %v", err)
+		}
+	})
+
+	// Streams dialed while listener closing refused without panic
+	t.Run("ListenerClose", func(t *testing.T) {
+		l, err := srv.ListenStream(12)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				defer cancel()
+				if conn, err := cli.DialStream(ctx, srv.Address(), 12); err == nil {
+					conn.Close()
+				}
+			}()
+		}
+		time.Sleep(time.Millisecond)
+		l.Close()
+		wg.Wait()
+
+		if _, err := l.Accept(); err != net.ErrClosed {
+			t.Errorf("wrong accept error: %v", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if _, err := cli.DialStream(ctx, srv.Address(), 12); err != ErrStreamRefused {
+			t.Errorf("stream dialed to closed listener: %v", err)
+		}
+		if l, err = srv.ListenStream(12); err != nil {
+			t.Errorf("port does not freed: %v", err)
+		} else {
+			l.Close()
+		}
+	})
+}
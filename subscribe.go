@@ -115,17 +115,23 @@ func (s *subscribers) len() int {
 	return len(s.idx)
 }
 
-// send packet to all subscribers
+// send packet to all subscribers. Subscribers readers executes without lock,
+// so readers may subscribe and unsubscribe
 func (s *subscribers) send(teo *Teonet, c *Channel, p *Packet, e *Event) bool {
 
-	var next *list.Element
-	for el := s.lst.Front(); el != nil; el = next {
-		next = el.Next()
+	s.RLock()
+	var readers []Treceivecb
+	for el := s.lst.Front(); el != nil; el = el.Next() {
 		scr := el.Value.(*subscribeData)
 		if scr.channel == c {
-			if scr.reader(teo, c, p, e) {
-				return true
-			}
+			readers = append(readers, scr.reader)
+		}
+	}
+	s.RUnlock()
+
+	for _, reader := range readers {
+		if reader(teo, c, p, e) {
+			return true
 		}
 	}
 
@@ -35,7 +35,17 @@ type Teonet struct {
 	peerRequests  *connectRequests
 	connRequests  *connectRequests
 	puncher       *puncher
+	candidates    *candidates
+	keeper        *keeper
+	fragmenter    *fragmenter
+	streams       *streams
+	rpc           *rpc
+	registry      *registry
+	dispatcher    *dispatcher
+	direct        bool // Accept direct connections
+	directIDs     *directRequests
 	closing       chan interface{}
+	started       chan struct{} // Closed when New initialized teonet
 }
 
 type Treceivecb func(teo *Teonet, c *Channel, p *Packet, e *Event) bool
@@ -101,6 +111,75 @@ func CheckRequeredParams(req ...string) {
 // reader is Main teonet reader
 func reader(teo *Teonet, c *Channel, p *Packet, e *Event) {
 
+	// Reset streams of disconnected channel
+	if e.Event == EventDisconnected || e.Event == EventTeonetDisconnected {
+		teo.streams.closeChannel(c)
+		teo.rpc.closeChannel(c)
+		teo.registry.closeChannel(c)
+	}
+
+	// Process commect messages
+	if e.Event == EventData && (teo.connectToPeer(c, p) ||
+		teo.connectToClient(c, p) || teo.connectDirect(c, p)) {
+		return
+	}
+
+	// Process heartbeat messages, any other data packet is channel activity
+	if e.Event == EventData {
+		t := c.readFrame(p)
+		if teo.processHeartbeat(c, t, p) {
+			return
+		}
+		c.stat.touch()
+
+		// Collect fragments and continue with full message
+		var wait bool
+		if p, t, wait = teo.reassemble(c, t, p); wait {
+			return
+		}
+
+		// Process streams frames
+		if teo.processStream(c, t, p) {
+			return
+		}
+
+		// Process requests replies
+		if teo.processRPC(c, t, p) {
+			return
+		}
+
+		// Api errors answers send to readers in separate event, requests
+		// continue as application data, unknown frames skipped
+		switch {
+		case t == frameAPIError:
+			e = &Event{Event: EventAPIError}
+		case t != frameData && !p.rpc:
+			log.Debugv.Println("skip unknown frame", t, "from", c)
+			return
+		}
+
+		// Process services registry commands
+		if teo.processRegistry(c, p) {
+			return
+		}
+	}
+
+	// Streaming requests handlers wait credits which received in this
+	// goroutine, so they execute in separate goroutine
+	if p != nil && p.rpcStream {
+		go teo.readers(c, p, e)
+		return
+	}
+
+	// Send to subscribers and client readers in dispatcher workers
+	teo.dispatcher.dispatch(c, e.Event == EventData, func() {
+		teo.readers(c, p, e)
+	})
+}
+
+// readers send packet and event to subscribers and client readers
+func (teo *Teonet) readers(c *Channel, p *Packet, e *Event) {
+
 	// Delete channel on err after all other reader process this error
 	// TODO: Really need this defer?
 	defer func() {
@@ -109,11 +188,6 @@ func reader(teo *Teonet, c *Channel, p *Packet, e *Event) {
 		}
 	}()
 
-	// Process commect messages
-	if e.Event == EventData && (teo.connectToPeer(c, p) || teo.connectToClient(c, p)) {
-		return
-	}
-
 	// Send to subscribers readers (to readers from teo.subscribe)
 	if teo.subscribers.send(teo, c, p, e) {
 		return
@@ -130,9 +204,22 @@ func reader(teo *Teonet, c *Channel, p *Packet, e *Event) {
 //	Stat            set true to show tru statistic table
 //	Hotkey          start hotkey meny
 //	MaxDataLen      set max data length
+//	MaxMessageLen   set max message length (fragmented if larger MaxDataLen)
 //	*teolog.Teolog  teonet logger
 //	ApiInterface    api interface
 //	OsConfigDir     os directory to save config
+//	MaxPeers        max number of connected peers
+//	EvictPolicy     peer disconnected when max number of peers reached
+//	IdleTimeout     disconnect peers without data packets after timeout
+//	HeartbeatInterval interval between heartbeats sent to peers
+//	NetworkCheckInterval interval between local network changes checks
+//	CandidatePolicy local addresses advertised to peers and punched
+//	DirectConnect   accept direct connections without teonet auth server
+//	RegistryAddress services registry peer address
+//	DispatchWorkers number of readers workers, readers execute in receive
+//	                goroutine by default
+//	DispatchQueue   max number of packets queued to channel in dispatcher
+//	QueuePolicy     dispatcher policy when channel queue is full
 //	func(c *Channel, p *Packet, e *Event) - message receiver
 //	func(t *Teonet, c *Channel, p *Packet, e *Event) - message receiver
 func New(appName string, attr ...interface{}) (teo *Teonet, err error) {
@@ -143,18 +230,32 @@ func New(appName string, attr ...interface{}) (teo *Teonet, err error) {
 		stat       tru.Stat
 		hotkey     tru.Hotkey
 		maxDataLen tru.MaxDataLenType
+		maxMsgLen  MaxMessageLen
 		logLevel   string
 		logFilter  LogFilter
 		log        *teolog.Teolog
 		reader     Treceivecb
 		api        ApiInterface
 		configDir  OsConfigDir
+		keeper     keeper
+		netwatch   time.Duration
+		candidates *CandidatePolicy
+		direct     DirectConnect
+		registry   RegistryAddress
+		dispatch   struct {
+			workers DispatchWorkers
+			queue   DispatchQueue
+			policy  QueuePolicy
+		}
 	}
 	// Set default
 	// Teonet applications in some hosts can't receive max UDP packets, so
 	// we set default max data length to 1024 bytes. This packet size will
 	// awailable for any hosts.
 	param.maxDataLen = 1024
+	param.maxMsgLen = defaultMaxMessageLen
+	param.keeper.heartbeat = defaultHeartbeatInterval
+	param.netwatch = defaultNetworkCheckInterval
 	// Parse attributes
 	for i := range attr {
 		switch d := attr[i].(type) {
@@ -176,6 +277,9 @@ func New(appName string, attr ...interface{}) (teo *Teonet, err error) {
 		// Max data length
 		case tru.MaxDataLenType:
 			param.maxDataLen = d
+		// Max message length
+		case MaxMessageLen:
+			param.maxMsgLen = d
 		// Logger
 		case *teolog.Teolog:
 			param.log = d
@@ -193,6 +297,37 @@ func New(appName string, attr ...interface{}) (teo *Teonet, err error) {
 		// Config file folder
 		case OsConfigDir:
 			param.configDir = d
+		// Max number of connected peers
+		case MaxPeers:
+			param.keeper.maxPeers = int(d)
+		// Evict policy
+		case EvictPolicy:
+			param.keeper.policy = d
+		// Idle timeout
+		case IdleTimeout:
+			param.keeper.idle = time.Duration(d)
+		// Heartbeat interval
+		case HeartbeatInterval:
+			param.keeper.heartbeat = time.Duration(d)
+		// Network changes check interval
+		case NetworkCheckInterval:
+			param.netwatch = time.Duration(d)
+		// Local candidates policy
+		case CandidatePolicy:
+			param.candidates = &d
+		// Accept direct connections
+		case DirectConnect:
+			param.direct = d
+		// Services registry address
+		case RegistryAddress:
+			param.registry = d
+		// Readers dispatcher parameters
+		case DispatchWorkers:
+			param.dispatch.workers = d
+		case DispatchQueue:
+			param.dispatch.queue = d
+		case QueuePolicy:
+			param.dispatch.policy = d
 		// Some enother (incorrect) attribute
 		default:
 			err = fmt.Errorf("incorrect attribute type '%T'", d)
@@ -218,6 +353,21 @@ func New(appName string, attr ...interface{}) (teo *Teonet, err error) {
 	teo.newPeerRequests()
 	teo.newConnRequests()
 	teo.newClientReaders()
+	teo.newStreams()
+	teo.newRPC()
+	teo.newRegistry(string(param.registry))
+	teo.dispatcher = newDispatcher(int(param.dispatch.workers),
+		int(param.dispatch.queue), param.dispatch.policy)
+	teo.keeper = &param.keeper
+	teo.direct = bool(param.direct)
+	teo.directIDs = newDirectRequests()
+	teo.fragmenter = newFragmenter(int(param.maxDataLen), int(param.maxMsgLen))
+	teo.candidates = new(candidates)
+	if param.candidates != nil {
+		if err = teo.SetCandidatePolicy(*param.candidates); err != nil {
+			return
+		}
+	}
 	teo.log = log
 
 	// Create config holder and read config
@@ -230,16 +380,19 @@ func New(appName string, attr ...interface{}) (teo *Teonet, err error) {
 	teo.addApiReader(param.api)
 	teo.clientReaders.add(param.reader)
 
-	// Init tru and start listen port to get messages
+	// Init tru and start listen port to get messages. The tru keeps log in
+	// global variable, so all tru instances use log of last created teonet
+	teo.started = make(chan struct{})
 	teo.tru, err = tru.New(param.port, param.stat, param.hotkey, param.maxDataLen,
 		teo.log, param.logLevel, param.logFilter, teo.config.trudpPrivateKey,
 
 		// Receive data callback
 		func(c *tru.Channel, p *tru.Packet, err error) bool {
+			<-teo.started
 			auth := teo.getAuth()
 			ch, ok := teo.channels.get(c)
 			if !ok {
-				if auth != nil && c == auth.c {
+				if auth != nil && c == auth.truChannel() {
 					// There is Auth channel
 					ch = auth
 				} else {
@@ -254,7 +407,7 @@ func New(appName string, attr ...interface{}) (teo *Teonet, err error) {
 			// Create packet
 			var pac *Packet
 			if p != nil {
-				pac = &Packet{p, ch.a, false}
+				pac = &Packet{Packet: p, from: ch.a}
 			}
 
 			// Create Disconnect, TeonetDisconnect or Data Events
@@ -278,6 +431,8 @@ func New(appName string, attr ...interface{}) (teo *Teonet, err error) {
 
 		// Connect to this server callback
 		func(c *tru.Channel, err error) {
+			<-teo.started
+
 			// Wait this tru channel connected to teonet channel and delete
 			// it if not connected during timeout
 			_, exists := teo.channels.get(c)
@@ -309,6 +464,9 @@ func New(appName string, attr ...interface{}) (teo *Teonet, err error) {
 	}
 	teo.newChannels()
 	teo.newPuncher()
+	close(teo.started)
+	go teo.keeperProcess()
+	go teo.netwatchProcess(param.netwatch, teo.netwatchIPs)
 	log.Connect.Println("start listen teonet at port", teo.tru.LocalPort())
 
 	return
@@ -318,6 +476,7 @@ func New(appName string, attr ...interface{}) (teo *Teonet, err error) {
 func (teo *Teonet) Close() {
 	close(teo.closing)
 	teo.tru.Close()
+	teo.dispatcher.close()
 }
 
 // RHost return current auth server
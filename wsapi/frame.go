@@ -0,0 +1,165 @@
+// Copyright 2023 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// WebSocket protocol (RFC 6455) server side handshake and frames module
+
+package wsapi
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// WebSocket opcodes
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// WebSocket close codes
+const (
+	closeNormal        = 1000
+	closeProtocolError = 1002
+	closePolicy        = 1008
+	closeTooBig        = 1009
+)
+
+// acceptGUID is WebSocket accept key GUID
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var (
+	ErrBadHandshake  = errors.New("bad websocket handshake")
+	ErrProtocol      = errors.New("websocket protocol error")
+	ErrMessageTooBig = errors.New("websocket message too big")
+)
+
+// frame is WebSocket frame
+type frame struct {
+	fin     bool
+	opcode  byte
+	payload []byte
+}
+
+// acceptKey return Sec-WebSocket-Accept value of Sec-WebSocket-Key
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// checkHandshake check WebSocket upgrade request and return client key
+func checkHandshake(r *http.Request) (key string, err error) {
+	key = r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" || key == "" {
+		err = ErrBadHandshake
+	}
+	return
+}
+
+// headerContains check header contains token (case insensitive)
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// readFrame read client frame. Client frames should be masked. The maxSize
+// limits frame payload length
+func readFrame(r *bufio.Reader, maxSize int) (f frame, err error) {
+	var h [2]byte
+	if _, err = io.ReadFull(r, h[:]); err != nil {
+		return
+	}
+	f.fin = h[0]&0x80 != 0
+	f.opcode = h[0] & 0x0F
+	if h[0]&0x70 != 0 || h[1]&0x80 == 0 {
+		// Reserved bits set or frame not masked
+		err = ErrProtocol
+		return
+	}
+
+	// Payload length
+	length := uint64(h[1] & 0x7F)
+	switch length {
+	case 126:
+		var l [2]byte
+		if _, err = io.ReadFull(r, l[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(l[:]))
+	case 127:
+		var l [8]byte
+		if _, err = io.ReadFull(r, l[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(l[:])
+	}
+	if f.opcode >= opClose && (length > 125 || !f.fin) {
+		err = ErrProtocol
+		return
+	}
+	if length > uint64(maxSize) {
+		err = ErrMessageTooBig
+		return
+	}
+
+	// Mask and payload
+	var mask [4]byte
+	if _, err = io.ReadFull(r, mask[:]); err != nil {
+		return
+	}
+	f.payload = make([]byte, length)
+	if _, err = io.ReadFull(r, f.payload); err != nil {
+		return
+	}
+	for i := range f.payload {
+		f.payload[i] ^= mask[i%4]
+	}
+	return
+}
+
+// writeFrame write not masked server frame
+func writeFrame(w *bufio.Writer, opcode byte, payload []byte) (err error) {
+	h := []byte{0x80 | opcode, 0}
+	switch l := len(payload); {
+	case l < 126:
+		h[1] = byte(l)
+	case l <= 0xFFFF:
+		h[1] = 126
+		h = binary.BigEndian.AppendUint16(h, uint16(l))
+	default:
+		h[1] = 127
+		h = binary.BigEndian.AppendUint64(h, uint64(l))
+	}
+	if _, err = w.Write(h); err != nil {
+		return
+	}
+	if _, err = w.Write(payload); err != nil {
+		return
+	}
+	return w.Flush()
+}
+
+// closePayload return close frame payload
+func closePayload(code uint16, reason string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, code), reason...)
+}
@@ -0,0 +1,337 @@
+// Copyright 2023 Kirill Scherba <kirill@scherba.ru>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Teonet WebSocket api package: WebSocket server adapter which executes
+// teonet API commands by API.Reader2. Browsers may call the same command
+// handlers without teonet client.
+//
+// Each WebSocket binary message is a command frame: [cmd][data], where cmd
+// is one byte command number or CmdExtended byte with two bytes extended
+// command number. Answers sends back in binary messages with the same
+// [cmd][data] format. Errors sends in text messages with json object:
+//
+//	{"cmd":129,"code":2,"error":"unknown command"}
+//
+// Commands should have second reader set by APIData.SetReader2 to be
+// executed by this adapter:
+//
+//	api := teo.NewAPI(...)
+//	...
+//	http.Handle("/ws", wsapi.New(api.Reader2(), wsapi.Origins{"https://example.com"}))
+package wsapi
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/teonet-go/teonet"
+)
+
+// Default limits
+const (
+	DefaultMaxConnections = 1024
+	DefaultMaxMessageSize = 64 * 1024
+	DefaultMaxPending     = 16
+)
+
+// Origins used in New parameter to set allowed request origins. The "*"
+// allows any origin. Requests without Origin header (not browsers) and
+// requests with the same origin as request host allowed always
+type Origins []string
+
+// MaxConnections used in New parameter to set max number of connections
+type MaxConnections int
+
+// MaxMessageSize used in New parameter to set max size of received message
+type MaxMessageSize int
+
+// MaxPending used in New parameter to set max number of commands executed
+// at the same time in one connection. Next commands reading waits until
+// executed commands done
+type MaxPending int
+
+// Reader2 is API second reader, use teonet API.Reader2() to get it
+type Reader2 func(data []byte, answer func(data []byte)) bool
+
+// ErrTooManyConnections returns when max number of connections reached
+var ErrTooManyConnections = errors.New("too many websocket connections")
+
+// Server is WebSocket api server, it implements http.Handler
+type Server struct {
+	reader         Reader2
+	origins        Origins
+	maxConnections int
+	maxMessageSize int
+	maxPending     int
+	conns          map[*conn]struct{}
+	sync.Mutex
+}
+
+// Error is error message
+type Error struct {
+	Cmd   byte                `json:"cmd"`
+	Ext   teonet.ExtCmd       `json:"ext,omitempty"`
+	Code  teonet.APIErrorCode `json:"code"`
+	Error string              `json:"error"`
+}
+
+// New create new WebSocket api server.
+//
+// Parameters by type:
+//
+//	wsapi.Origins         - allowed request origins
+//	wsapi.MaxConnections  - max number of connections
+//	wsapi.MaxMessageSize  - max size of received message
+//	wsapi.MaxPending      - max number of executed commands per connection
+func New(reader Reader2, attr ...interface{}) (s *Server) {
+	s = &Server{
+		reader:         reader,
+		maxConnections: DefaultMaxConnections,
+		maxMessageSize: DefaultMaxMessageSize,
+		maxPending:     DefaultMaxPending,
+		conns:          make(map[*conn]struct{}),
+	}
+	for i := range attr {
+		switch v := attr[i].(type) {
+		case Origins:
+			s.origins = v
+		case MaxConnections:
+			s.maxConnections = int(v)
+		case MaxMessageSize:
+			s.maxMessageSize = int(v)
+		case MaxPending:
+			s.maxPending = int(v)
+		}
+	}
+	return
+}
+
+// Close close all connections
+func (s *Server) Close() {
+	s.Lock()
+	defer s.Unlock()
+	for c := range s.conns {
+		c.c.Close()
+	}
+}
+
+// ServeHTTP upgrade HTTP request to WebSocket and process its messages
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key, err := checkHandshake(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !s.checkOrigin(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	// Hijack connection and send handshake answer
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return
+	}
+	nc, rw, err := hj.Hijack()
+	if err != nil {
+		return
+	}
+	c := &conn{
+		s:       s,
+		c:       nc,
+		r:       rw.Reader,
+		w:       rw.Writer,
+		pending: make(chan struct{}, s.maxPending),
+	}
+	if err = s.add(c); err != nil {
+		rw.WriteString("HTTP/1.1 503 Service Unavailable\r\n\r\n")
+		rw.Flush()
+		nc.Close()
+		return
+	}
+	defer s.del(c)
+
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n")
+	if err = rw.Flush(); err != nil {
+		nc.Close()
+		return
+	}
+	c.process()
+}
+
+// checkOrigin check request origin
+func (s *Server) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, o := range s.origins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// add connection to connections map
+func (s *Server) add(c *conn) (err error) {
+	s.Lock()
+	defer s.Unlock()
+	if len(s.conns) >= s.maxConnections {
+		err = ErrTooManyConnections
+		return
+	}
+	s.conns[c] = struct{}{}
+	return
+}
+
+// del remove connection from connections map
+func (s *Server) del(c *conn) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.conns, c)
+}
+
+// conn is WebSocket connection
+type conn struct {
+	s       *Server
+	c       net.Conn
+	r       *bufio.Reader
+	w       *bufio.Writer
+	pending chan struct{}
+	closed  bool
+	sync.Mutex
+}
+
+// process read connection messages and execute commands until connection
+// closed
+func (c *conn) process() {
+	var msg []byte
+	var started bool
+	for {
+		f, err := readFrame(c.r, c.s.maxMessageSize)
+		switch {
+		case err == ErrMessageTooBig:
+			c.close(closeTooBig, err.Error())
+			return
+		case err == ErrProtocol:
+			c.close(closeProtocolError, err.Error())
+			return
+		case err != nil:
+			c.close(0, "")
+			return
+		}
+
+		switch f.opcode {
+
+		// Control frames
+		case opPing:
+			c.write(opPong, f.payload)
+			continue
+		case opPong:
+			continue
+		case opClose:
+			c.close(closeNormal, "")
+			return
+
+		// Data frames
+		case opBinary:
+			if started {
+				c.close(closeProtocolError, "unexpected binary frame")
+				return
+			}
+			started, msg = true, f.payload
+		case opContinuation:
+			if !started {
+				c.close(closeProtocolError, "unexpected continuation frame")
+				return
+			}
+			msg = append(msg, f.payload...)
+			if len(msg) > c.s.maxMessageSize {
+				c.close(closeTooBig, ErrMessageTooBig.Error())
+				return
+			}
+		case opText:
+			c.close(closePolicy, "text messages not supported")
+			return
+		default:
+			c.close(closeProtocolError, "unknown opcode")
+			return
+		}
+		if !f.fin {
+			continue
+		}
+		started = false
+
+		// Execute command. Wait free pending slot before
+		c.pending <- struct{}{}
+		go func(msg []byte) {
+			defer func() { <-c.pending }()
+			c.exec(msg)
+		}(msg)
+	}
+}
+
+// exec execute command message
+func (c *conn) exec(msg []byte) {
+	var cmd teonet.Command
+	if err := cmd.UnmarshalBinary(msg); err != nil {
+		c.writeError(cmd, teonet.ErrAPIBadArgument)
+		return
+	}
+
+	// Answer has the same command header as request
+	header := teonet.Command{Cmd: cmd.Cmd, Ext: cmd.Ext}.Bytes()
+	answer := func(data []byte) {
+		if apiErr, ok := teonet.APIErrorAnswer(data); ok {
+			c.writeError(cmd, apiErr)
+			return
+		}
+		c.write(opBinary, append(header[:len(header):len(header)], data...))
+	}
+	if !c.s.reader(msg, answer) {
+		c.writeError(cmd, teonet.ErrAPIUnknownCommand)
+	}
+}
+
+// writeError write error message
+func (c *conn) writeError(cmd teonet.Command, err *teonet.APIError) {
+	data, _ := json.Marshal(Error{cmd.Cmd, cmd.Ext, err.Code, err.Message})
+	c.write(opText, data)
+}
+
+// write frame to connection
+func (c *conn) write(opcode byte, payload []byte) (err error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	return writeFrame(c.w, opcode, payload)
+}
+
+// close send close frame and close connection. The close frame does not
+// send if code is 0
+func (c *conn) close(code uint16, reason string) {
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		return
+	}
+	if code != 0 {
+		writeFrame(c.w, opClose, closePayload(code, reason))
+	}
+	c.closed = true
+	c.c.Close()
+}
@@ -0,0 +1,162 @@
+// Test of WebSocket api server
+package wsapi
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/teonet-go/teonet"
+)
+
+// dial connect to websocket server and return connection
+func dial(t *testing.T, url, origin string) (c net.Conn, r *bufio.Reader, status string) {
+	c, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := "GET / HTTP/1.1\r\nHost: " + strings.TrimPrefix(url, "http://") +
+		"\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+		"Sec-WebSocket-Version: 13\r\n"
+	if origin != "" {
+		req += "Origin: " + origin + "\r\n"
+	}
+	c.Write([]byte(req + "\r\n"))
+	r = bufio.NewReader(c)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status = resp.Status
+	if resp.StatusCode == http.StatusSwitchingProtocols &&
+		resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("wrong accept key: %s", resp.Header.Get("Sec-WebSocket-Accept"))
+	}
+	return
+}
+
+// send masked client frame
+func send(c net.Conn, opcode byte, payload []byte) {
+	h := []byte{0x80 | opcode, 0x80}
+	if len(payload) < 126 {
+		h[1] |= byte(len(payload))
+	} else {
+		h[1] |= 126
+		h = binary.BigEndian.AppendUint16(h, uint16(len(payload)))
+	}
+	mask := []byte{1, 2, 3, 4}
+	data := append(h, mask...)
+	for i := range payload {
+		data = append(data, payload[i]^mask[i%4])
+	}
+	c.Write(data)
+}
+
+// recv server frame
+func recv(r *bufio.Reader) (opcode byte, payload []byte, err error) {
+	var h [2]byte
+	if _, err = r.Read(h[:1]); err != nil {
+		return
+	}
+	if _, err = r.Read(h[1:]); err != nil {
+		return
+	}
+	payload = make([]byte, h[1]&0x7F)
+	_, err = r.Read(payload)
+	return h[0] & 0x0F, payload, err
+}
+
+func TestServer(t *testing.T) {
+
+	api := new(teonet.Teonet).NewAPI("test", "test", "test api", "0.0.1")
+	api.Add(
+		teonet.MakeAPI2().SetName("hello").SetCmd(129).
+			SetReader2(func(data []byte, answer func(data []byte)) bool {
+				answer(append([]byte("hello "), data...))
+				return true
+			}),
+		teonet.MakeAPI2().SetName("ext").SetExtCmd(1000).
+			SetReader2(func(data []byte, answer func(data []byte)) bool {
+				answer(data)
+				return true
+			}),
+		teonet.MakeAPI2().SetName("admin").SetCmd(131).
+			SetAccess(teonet.AccessPolicy{Roles: []string{"admin"}}).
+			SetReader2(func(data []byte, answer func(data []byte)) bool {
+				answer(data)
+				return true
+			}),
+	)
+	s := New(api.Reader2(), MaxMessageSize(100), MaxConnections(1))
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	defer s.Close()
+
+	t.Run("Origin", func(t *testing.T) {
+		c, _, status := dial(t, ts.URL, "https://other.example.com")
+		defer c.Close()
+		if !strings.HasPrefix(status, "403") {
+			t.Errorf("wrong status: %s", status)
+		}
+	})
+
+	c, r, status := dial(t, ts.URL, ts.URL)
+	if !strings.HasPrefix(status, "101") {
+		t.Fatalf("wrong status: %s", status)
+	}
+	defer c.Close()
+
+	t.Run("MaxConnections", func(t *testing.T) {
+		c, _, status := dial(t, ts.URL, "")
+		defer c.Close()
+		if !strings.HasPrefix(status, "503") {
+			t.Errorf("wrong status: %s", status)
+		}
+	})
+
+	for _, test := range []struct {
+		name   string
+		msg    []byte
+		opcode byte
+		want   string
+	}{
+		{"Command", teonet.Command{Cmd: 129, Data: []byte("world")}.Bytes(),
+			opBinary, "\x81hello world"},
+		{"ExtCommand", teonet.Command{Cmd: teonet.CmdExtended, Ext: 1000,
+			Data: []byte("data")}.Bytes(), opBinary, "\xfd\xe8\x03data"},
+		{"UnknownCommand", []byte{130}, opText,
+			`{"cmd":130,"code":2,"error":"unknown command"}`},
+		{"NotAuthorized", []byte{131}, opText,
+			`{"cmd":131,"code":4,"error":"not authorized"}`},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			send(c, opBinary, test.msg)
+			opcode, payload, err := recv(r)
+			if err != nil || opcode != test.opcode || string(payload) != test.want {
+				t.Errorf("wrong answer: %d %q %v", opcode, payload, err)
+			}
+		})
+	}
+
+	t.Run("Ping", func(t *testing.T) {
+		send(c, opPing, []byte("ping"))
+		opcode, payload, err := recv(r)
+		if err != nil || opcode != opPong || string(payload) != "ping" {
+			t.Errorf("wrong pong: %d %q %v", opcode, payload, err)
+		}
+	})
+
+	t.Run("MessageTooBig", func(t *testing.T) {
+		send(c, opBinary, make([]byte, 200))
+		opcode, payload, err := recv(r)
+		if err != nil || opcode != opClose ||
+			binary.BigEndian.Uint16(payload) != closeTooBig {
+			t.Errorf("wrong close: %d %q %v", opcode, payload, err)
+		}
+	})
+}